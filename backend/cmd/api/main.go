@@ -7,12 +7,17 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/ouroboros/backend/internal/auth"
+	"github.com/ouroboros/backend/internal/backup"
 	"github.com/ouroboros/backend/internal/handlers"
+	"github.com/ouroboros/backend/internal/migrations"
+	"github.com/ouroboros/backend/internal/provision"
+	"github.com/ouroboros/backend/internal/replica"
 	"github.com/ouroboros/backend/internal/sync"
 	"github.com/ouroboros/backend/internal/tenant"
 	"github.com/redis/go-redis/v9"
@@ -24,53 +29,115 @@ func main() {
 	jwtSecret := envOr("JWT_SECRET", "ouroboros-dev-secret-change-in-prod")
 	dataDir := envOr("DATA_DIR", "./data")
 	staticDir := envOr("STATIC_DIR", "")
+	syncRetention := envDuration("SYNC_RETENTION", 30*24*time.Hour)
+	compactInterval := envDuration("SYNC_COMPACT_INTERVAL", time.Hour)
+	hubBackend := envOr("SYNC_BACKEND", "redis") // redis | local (single node, no Redis)
+	maxStreamsPerTenant := envInt("SYNC_MAX_STREAMS_PER_TENANT", 500)
+	maxStreamsPerUser := envInt("SYNC_MAX_STREAMS_PER_USER", 10)
+	maxOpenTenants := envInt("TENANT_MAX_OPEN", 256) // hard ceiling; 64 stay cached while idle
+	tenantReadConns := envInt("TENANT_READ_CONNS", 4)
+	tenantIdleTimeout := envDuration("TENANT_IDLE_TIMEOUT", 30*time.Minute)
+	janitorInterval := envDuration("TENANT_JANITOR_INTERVAL", time.Minute)
+	backupDir := envOr("BACKUP_DIR", filepath.Join(dataDir, "backups"))
+	backupInterval := envDuration("BACKUP_INTERVAL", 24*time.Hour) // 0 disables scheduled snapshots
+	backupRetention := envDuration("BACKUP_RETENTION", 7*24*time.Hour)
+	adminToken := envOr("ADMIN_TOKEN", "") // enables the /admin/ API
+	replicaURL := envOr("REPLICA_URL", "") // continuous WAL shipping, e.g. s3://bucket/prefix; empty disables
+	replicaInterval := envDuration("REPLICA_INTERVAL", time.Second)
+	replicaRetention := envDuration("REPLICA_RETENTION", 72*time.Hour)
+	migrationDrift := envOr("MIGRATION_DRIFT", "warn")     // warn | refuse to open databases whose applied migrations were edited
+	tenantMigrations := envOr("TENANT_MIGRATIONS", "lazy") // lazy (on open) | require (refuse tenants cmd/migrate has not migrated)
 
 	// Ensure data directory exists
 	if err := os.MkdirAll(dataDir, 0o755); err != nil {
 		log.Fatalf("failed to create data dir: %v", err)
 	}
 
+	switch migrationDrift {
+	case "warn":
+	case "refuse":
+		migrations.Tenant.Drift = migrations.DriftRefuse
+		migrations.System.Drift = migrations.DriftRefuse
+	default:
+		log.Fatalf("invalid MIGRATION_DRIFT %q (want warn or refuse)", migrationDrift)
+	}
+
 	// Central system database (users, auth — NOT per-tenant)
 	sdb, err := auth.OpenSystemDB(filepath.Join(dataDir, "system.db"))
 	if err != nil {
 		log.Fatalf("failed to open system db: %v", err)
 	}
 
-	// Redis client — supports both host:port and redis:// URL formats
-	var rdb *redis.Client
-	if strings.HasPrefix(redisAddr, "redis://") || strings.HasPrefix(redisAddr, "rediss://") {
-		opt, err := redis.ParseURL(redisAddr)
+	ctx := context.Background()
+	tm := tenant.NewManager(dataDir, 64)
+	tm.SetMaxOpen(maxOpenTenants)
+	tm.SetReadConns(tenantReadConns)
+	tm.SetRegistry(sdb) // only registered, active tenants get a database
+	switch tenantMigrations {
+	case "lazy":
+	case "require":
+		tm.SetMigrate(migrations.Require)
+	default:
+		log.Fatalf("invalid TENANT_MIGRATIONS %q (want lazy or require)", tenantMigrations)
+	}
+
+	// Replication follows tenant databases from their first open, so it is
+	// wired up before anything opens one
+	var rep *replica.Replicator
+	if replicaURL != "" {
+		store, err := replica.OpenStore(replicaURL)
 		if err != nil {
-			log.Fatalf("invalid REDIS_ADDR URL: %v", err)
+			log.Fatalf("invalid REPLICA_URL: %v", err)
 		}
-		rdb = redis.NewClient(opt)
-	} else {
-		rdb = redis.NewClient(&redis.Options{Addr: redisAddr})
+		rep = replica.New(store, replicaInterval, replicaRetention)
+		tm.SetLifecycle(rep)
+		tm.SetHooks(rep.Hooks(tenant.DefaultHooks)...)
+		log.Printf("replicating tenant databases to %s", replicaURL)
 	}
 
-	ctx := context.Background()
-	const maxRetries = 5
-	for i := range maxRetries {
-		if err := rdb.Ping(ctx).Err(); err != nil {
-			if i == maxRetries-1 {
-				log.Fatalf("failed to connect to redis after %d attempts: %v", maxRetries, err)
-			}
-			log.Printf("redis not ready (attempt %d/%d), retrying in 1s...", i+1, maxRetries)
-			time.Sleep(time.Second)
-			continue
-		}
-		break
+	// Hub backend — Redis for multi-node deployments, in-process for a single node
+	var backend sync.Backend
+	var rdb *redis.Client
+	switch hubBackend {
+	case "redis":
+		rdb = connectRedis(ctx, redisAddr)
+		backend = sync.NewRedisBackend(rdb)
+	case "local":
+		backend = sync.NewLocalBackend()
+		log.Printf("using in-process sync backend (single node)")
+	default:
+		log.Fatalf("invalid SYNC_BACKEND %q (want redis or local)", hubBackend)
 	}
-	log.Printf("connected to redis")
+
+	// Versions are allocated in the tenant databases; bring Redis's copy in line
+	// without holding up serving
+	go func() {
+		if err := sync.Reconcile(ctx, tm, backend); err != nil {
+			log.Printf("failed to reconcile sync versions: %v", err)
+		}
+	}()
 
 	// Core services
-	tm := tenant.NewManager(dataDir, 64)
-	hub := sync.NewHub(rdb)
+	hub := sync.NewHub(backend)
+	hub.SetLimits(maxStreamsPerTenant, maxStreamsPerUser)
 	jwtAuth := auth.New([]byte(jwtSecret))
 
-	// Start SSE hub (subscribes to Redis Pub/Sub)
+	// Start SSE hub (fans out version notifications from the backend)
 	go hub.Run(ctx)
 
+	// Periodically collapse sync_log entries older than the retention horizon
+	go sync.RunCompactor(ctx, tm, compactInterval, syncRetention)
+
+	// Close idle tenant databases and run checkpoints, optimize and vacuum on them
+	go tm.RunJanitor(ctx, janitorInterval, tenantIdleTimeout)
+
+	// Scheduled tenant snapshots
+	backups := backup.NewStore(backupDir, tm, hub)
+	if backupInterval > 0 {
+		go backups.Run(ctx, backupInterval, backupRetention)
+	}
+	tenants := provision.NewService(sdb, tm, hub, backups, rep)
+
 	// Router — single mux, middleware skips /api/auth/ paths
 	mux := http.NewServeMux()
 
@@ -78,40 +145,78 @@ func main() {
 	mux.HandleFunc("POST /api/auth/register", handlers.Register(jwtAuth, sdb))
 	mux.HandleFunc("POST /api/auth/login", handlers.Login(jwtAuth, sdb))
 
-	// Protected API routes
-	mux.HandleFunc("GET /api/sync", handlers.GetSync(tm))
-	mux.HandleFunc("POST /api/projects", handlers.CreateProject(tm, hub))
-	mux.HandleFunc("DELETE /api/projects/{id}", handlers.DeleteProject(tm, hub))
-	mux.HandleFunc("GET /api/projects", handlers.ListProjects(tm))
-	mux.HandleFunc("POST /api/kanban/cards", handlers.CreateCard(tm, hub))
-	mux.HandleFunc("PUT /api/kanban/cards/{id}", handlers.UpdateCard(tm, hub))
-	mux.HandleFunc("GET /api/kanban/cards", handlers.ListCards(tm))
-	mux.HandleFunc("POST /api/products", handlers.CreateProduct(tm, hub))
-	mux.HandleFunc("GET /api/products", handlers.ListProducts(tm))
-	mux.HandleFunc("POST /api/orders", handlers.CreateOrder(tm, hub))
-	mux.HandleFunc("GET /api/orders", handlers.ListOrders(tm))
-	mux.HandleFunc("POST /api/users", handlers.InviteUser(sdb, tm, hub))
-	mux.HandleFunc("GET /api/users", handlers.ListTenantUsers(sdb))
+	// Protected API routes, each behind the permission it needs (see auth.Role).
+	// Writes go by the role in system.db, so a demotion applies at once.
+	read := auth.Require(auth.PermReadData)
+	writeProjects := auth.RequireCurrent(sdb, auth.PermWriteProjects)
+	deleteProjects := auth.RequireCurrent(sdb, auth.PermDeleteProjects)
+	board := auth.RequireCurrent(sdb, auth.PermWriteBoard)
+	writeProducts := auth.RequireCurrent(sdb, auth.PermWriteProducts)
+	createOrders := auth.RequireCurrent(sdb, auth.PermCreateOrders)
+	manageUsers := auth.RequireCurrent(sdb, auth.PermManageUsers)
+	push := auth.RequireCurrent(sdb, auth.PermReadData) // each mutation checked as its REST route
+
+	mux.Handle("GET /api/sync", read(handlers.GetSync(tm)))
+	mux.Handle("GET /api/sync/snapshot", read(handlers.GetSnapshot(tm)))
+	mux.Handle("GET /api/sync/checksum", read(handlers.GetChecksum(tm)))
+	mux.Handle("GET /api/sync/manifest", read(handlers.GetManifest(tm)))
+	mux.Handle("POST /api/sync/push", push(handlers.PushMutations(tm, hub)))
+	mux.Handle("POST /api/projects", writeProjects(handlers.CreateProject(tm, hub)))
+	mux.Handle("DELETE /api/projects/{id}", deleteProjects(handlers.DeleteProject(tm, hub)))
+	mux.Handle("GET /api/projects", read(handlers.ListProjects(tm)))
+	mux.Handle("POST /api/kanban/cards", board(handlers.CreateCard(tm, hub)))
+	mux.Handle("PUT /api/kanban/cards/{id}", board(handlers.UpdateCard(tm, hub)))
+	mux.Handle("GET /api/kanban/cards", read(handlers.ListCards(tm)))
+	mux.Handle("POST /api/products", writeProducts(handlers.CreateProduct(tm, hub)))
+	mux.Handle("GET /api/products", read(handlers.ListProducts(tm)))
+	mux.Handle("POST /api/orders", createOrders(handlers.CreateOrder(tm, hub)))
+	mux.Handle("GET /api/orders", read(handlers.ListOrders(tm)))
+	mux.Handle("POST /api/users", manageUsers(handlers.InviteUser(sdb, tm, hub)))
+	mux.Handle("GET /api/users", read(handlers.ListTenantUsers(sdb)))
+	mux.Handle("PUT /api/users/{id}/role", manageUsers(handlers.UpdateUserRole(sdb)))
+	mux.Handle("GET /api/tenant", read(handlers.GetTenantStatus(tm)))
 
 	// Kanban columns
-	mux.HandleFunc("POST /api/kanban/columns", handlers.CreateColumn(tm, hub))
-	mux.HandleFunc("PUT /api/kanban/columns/{id}", handlers.UpdateColumn(tm, hub))
-	mux.HandleFunc("DELETE /api/kanban/columns/{id}", handlers.DeleteColumn(tm, hub))
-	mux.HandleFunc("GET /api/kanban/columns", handlers.ListColumns(tm))
+	mux.Handle("POST /api/kanban/columns", board(handlers.CreateColumn(tm, hub)))
+	mux.Handle("PUT /api/kanban/columns/{id}", board(handlers.UpdateColumn(tm, hub)))
+	mux.Handle("DELETE /api/kanban/columns/{id}", board(handlers.DeleteColumn(tm, hub)))
+	mux.Handle("GET /api/kanban/columns", read(handlers.ListColumns(tm)))
 
 	// Card details: tags, assignees, approvers, sessions
-	mux.HandleFunc("POST /api/kanban/cards/{cardId}/tags", handlers.AddTag(tm, hub))
-	mux.HandleFunc("DELETE /api/kanban/cards/{cardId}/tags/{tagId}", handlers.RemoveTag(tm, hub))
-	mux.HandleFunc("POST /api/kanban/cards/{cardId}/assignees", handlers.AssignUser(tm, hub))
-	mux.HandleFunc("DELETE /api/kanban/cards/{cardId}/assignees/{assigneeId}", handlers.UnassignUser(tm, hub))
-	mux.HandleFunc("POST /api/kanban/cards/{cardId}/approvers", handlers.AddApprover(tm, hub))
-	mux.HandleFunc("DELETE /api/kanban/cards/{cardId}/approvers/{approverId}", handlers.RemoveApprover(tm, hub))
-	mux.HandleFunc("POST /api/kanban/cards/{cardId}/approvers/{approverId}/decide", handlers.DecideApproval(tm, hub))
-	mux.HandleFunc("POST /api/kanban/cards/{cardId}/sessions", handlers.CreateSession(tm, hub))
-	mux.HandleFunc("DELETE /api/kanban/cards/{cardId}/sessions/{sessionId}", handlers.DeleteSession(tm, hub))
+	mux.Handle("POST /api/kanban/cards/{cardId}/tags", board(handlers.AddTag(tm, hub)))
+	mux.Handle("DELETE /api/kanban/cards/{cardId}/tags/{tagId}", board(handlers.RemoveTag(tm, hub)))
+	mux.Handle("POST /api/kanban/cards/{cardId}/assignees", board(handlers.AssignUser(tm, hub)))
+	mux.Handle("DELETE /api/kanban/cards/{cardId}/assignees/{assigneeId}", board(handlers.UnassignUser(tm, hub)))
+	mux.Handle("POST /api/kanban/cards/{cardId}/approvers", board(handlers.AddApprover(tm, hub)))
+	mux.Handle("DELETE /api/kanban/cards/{cardId}/approvers/{approverId}", board(handlers.RemoveApprover(tm, hub)))
+	mux.Handle("POST /api/kanban/cards/{cardId}/approvers/{approverId}/decide", board(handlers.DecideApproval(tm, hub)))
+	mux.Handle("POST /api/kanban/cards/{cardId}/sessions", board(handlers.CreateSession(tm, hub)))
+	mux.Handle("DELETE /api/kanban/cards/{cardId}/sessions/{sessionId}", board(handlers.DeleteSession(tm, hub)))
 
 	// SSE endpoint (protected)
-	mux.HandleFunc("GET /sse/events", handlers.SSEHandler(hub))
+	mux.Handle("GET /sse/events", read(handlers.SSEHandler(tm, hub)))
+
+	// WebSocket sync: deltas down, mutation batches up (protected)
+	mux.Handle("GET /ws/sync", read(handlers.WSSync(tm, hub, sdb)))
+
+	// Operator API (static bearer token, not a user JWT)
+	if adminToken != "" {
+		admin := auth.Admin(adminToken)
+		mux.Handle("POST /admin/tenants", admin(handlers.CreateTenant(tenants)))
+		mux.Handle("GET /admin/tenants", admin(handlers.ListTenants(sdb)))
+		mux.Handle("GET /admin/tenants/{id}", admin(handlers.GetTenant(sdb)))
+		mux.Handle("PATCH /admin/tenants/{id}", admin(handlers.UpdateTenant(sdb)))
+		mux.Handle("DELETE /admin/tenants/{id}", admin(handlers.DeleteTenant(tenants)))
+		mux.Handle("POST /admin/tenants/{id}/suspend", admin(handlers.SuspendTenant(tenants)))
+		mux.Handle("POST /admin/tenants/{id}/resume", admin(handlers.ResumeTenant(tenants)))
+		mux.Handle("GET /admin/tenants/{id}/export", admin(handlers.ExportTenant(tenants)))
+		mux.Handle("POST /admin/tenants/{id}/users", admin(handlers.AddTenantUser(sdb, tm, hub)))
+		mux.Handle("POST /admin/tenants/{id}/backups", admin(handlers.CreateBackup(backups)))
+		mux.Handle("GET /admin/tenants/{id}/backups", admin(handlers.ListBackups(backups)))
+		mux.Handle("POST /admin/tenants/{id}/backups/{name}/restore", admin(handlers.RestoreBackup(backups)))
+	} else {
+		log.Printf("admin API disabled (set ADMIN_TOKEN to enable)")
+	}
 
 	// Serve frontend static files in production (SPA with fallback to index.html)
 	if staticDir != "" {
@@ -156,15 +261,55 @@ func main() {
 	<-quit
 
 	log.Println("shutting down...")
+	// Tell SSE and WebSocket clients to reconnect so their handlers return
+	// instead of holding up srv.Shutdown.
+	hub.Shutdown()
 	shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 	srv.Shutdown(shutdownCtx)
 	tm.CloseAll()
+	if rep != nil {
+		if err := rep.Close(shutdownCtx); err != nil {
+			log.Printf("replica: %v", err)
+		}
+	}
 	sdb.Close()
-	rdb.Close()
+	if rdb != nil {
+		rdb.Close()
+	}
 	log.Println("goodbye")
 }
 
+// connectRedis dials Redis, accepting both host:port and redis:// URL formats,
+// and waits for it to answer before returning.
+func connectRedis(ctx context.Context, redisAddr string) *redis.Client {
+	var rdb *redis.Client
+	if strings.HasPrefix(redisAddr, "redis://") || strings.HasPrefix(redisAddr, "rediss://") {
+		opt, err := redis.ParseURL(redisAddr)
+		if err != nil {
+			log.Fatalf("invalid REDIS_ADDR URL: %v", err)
+		}
+		rdb = redis.NewClient(opt)
+	} else {
+		rdb = redis.NewClient(&redis.Options{Addr: redisAddr})
+	}
+
+	const maxRetries = 5
+	for i := range maxRetries {
+		if err := rdb.Ping(ctx).Err(); err != nil {
+			if i == maxRetries-1 {
+				log.Fatalf("failed to connect to redis after %d attempts: %v", maxRetries, err)
+			}
+			log.Printf("redis not ready (attempt %d/%d), retrying in 1s...", i+1, maxRetries)
+			time.Sleep(time.Second)
+			continue
+		}
+		break
+	}
+	log.Printf("connected to redis")
+	return rdb
+}
+
 func envOr(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -172,11 +317,36 @@ func envOr(key, fallback string) string {
 	return fallback
 }
 
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+	return d
+}
+
+func envInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+	return n
+}
+
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, Last-Event-ID, X-Sync-Protocol, X-Sync-Schema, X-Sync-Replica")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Sync-Protocol, X-Sync-Schema")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
 			return
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ouroboros/backend/internal/backup"
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: backup create [tenant_id]\n")
+	fmt.Fprintf(os.Stderr, "       backup list <tenant_id>\n")
+	fmt.Fprintf(os.Stderr, "       backup restore <tenant_id> <snapshot>\n")
+	fmt.Fprintf(os.Stderr, "       backup prune [tenant_id]\n")
+	fmt.Fprintf(os.Stderr, "\nSnapshots tenant databases from DATA_DIR into BACKUP_DIR (default DATA_DIR/backups).\n")
+	fmt.Fprintf(os.Stderr, "create and prune act on every tenant if tenant_id is omitted; prune (and create for\n")
+	fmt.Fprintf(os.Stderr, "every tenant) deletes snapshots older than BACKUP_RETENTION (default 168h).\n")
+	fmt.Fprintf(os.Stderr, "Only restore while the API server is stopped; on a running server use\n")
+	fmt.Fprintf(os.Stderr, "POST /admin/tenants/{id}/backups/{snapshot}/restore instead.\n")
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		usage()
+	}
+
+	dataDir := envOr("DATA_DIR", "./data")
+	backupDir := envOr("BACKUP_DIR", filepath.Join(dataDir, "backups"))
+	retention, err := time.ParseDuration(envOr("BACKUP_RETENTION", "168h"))
+	if err != nil {
+		log.Fatalf("invalid BACKUP_RETENTION: %v", err)
+	}
+
+	tm := tenant.NewManager(dataDir, 4)
+	defer tm.CloseAll()
+	store := backup.NewStore(backupDir, tm, nil)
+	ctx := context.Background()
+	args := os.Args[2:]
+
+	switch {
+	case os.Args[1] == "create" && len(args) == 0:
+		if err := store.CreateAll(ctx, retention); err != nil {
+			log.Fatalf("backup: %v", err)
+		}
+		log.Printf("done")
+	case os.Args[1] == "create" && len(args) == 1:
+		if _, err := store.Create(ctx, args[0]); err != nil {
+			log.Fatalf("backup tenant %s: %v", args[0], err)
+		}
+	case os.Args[1] == "list" && len(args) == 1:
+		snaps, err := store.List(args[0])
+		if err != nil {
+			log.Fatalf("list backups of tenant %s: %v", args[0], err)
+		}
+		for _, s := range snaps {
+			fmt.Printf("%s\t%d\t%s\n", s.Name, s.Size, s.CreatedAt.Format(time.RFC3339))
+		}
+	case os.Args[1] == "restore" && len(args) == 2:
+		version, err := store.Restore(ctx, args[0], args[1])
+		if err != nil {
+			log.Fatalf("restore tenant %s: %v", args[0], err)
+		}
+		log.Printf("tenant %s: restored %s, clients will resync from version %d", args[0], args[1], version)
+	case os.Args[1] == "prune" && len(args) <= 1:
+		ids := args
+		if len(ids) == 0 {
+			if ids, err = tm.Tenants(); err != nil {
+				log.Fatalf("list tenants: %v", err)
+			}
+		}
+		for _, id := range ids {
+			n, err := store.Prune(id, retention)
+			if err != nil {
+				log.Fatalf("prune tenant %s: %v", id, err)
+			}
+			log.Printf("tenant %s: pruned %d snapshot(s)", id, n)
+		}
+	default:
+		usage()
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/ouroboros/backend/internal/sync"
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+func main() {
+	if len(os.Args) > 1 && (os.Args[1] == "-h" || os.Args[1] == "--help") {
+		fmt.Fprintf(os.Stderr, "usage: compact [tenant_id]\n")
+		fmt.Fprintf(os.Stderr, "\nCollapses sync_log entries older than SYNC_RETENTION (default 720h).\n")
+		fmt.Fprintf(os.Stderr, "If tenant_id is omitted, compacts every tenant in DATA_DIR.\n")
+		os.Exit(1)
+	}
+
+	dataDir := envOr("DATA_DIR", "./data")
+	retention, err := time.ParseDuration(envOr("SYNC_RETENTION", "720h"))
+	if err != nil {
+		log.Fatalf("invalid SYNC_RETENTION: %v", err)
+	}
+
+	tm := tenant.NewManager(dataDir, 4)
+	defer tm.CloseAll()
+	ctx := context.Background()
+
+	if len(os.Args) < 2 {
+		if err := sync.CompactAll(ctx, tm, retention); err != nil {
+			log.Fatalf("compact: %v", err)
+		}
+		log.Printf("done")
+		return
+	}
+
+	tenantID := os.Args[1]
+	db, release, err := tm.Acquire(tenantID)
+	if err != nil {
+		log.Fatalf("open tenant db: %v", err)
+	}
+	defer release()
+	res, err := sync.Compact(ctx, db, retention)
+	if err != nil {
+		log.Fatalf("compact tenant %s: %v", tenantID, err)
+	}
+	log.Printf("tenant %s: floor=%d superseded=%d tombstones=%d", tenantID, res.Floor, res.Superseded, res.Tombstones)
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -76,10 +76,11 @@ func main() {
 
 	// 3. Open tenant DB
 	tm := tenant.NewManager(dataDir, 4)
-	db, err := tm.DB(tenantID)
+	db, release, err := tm.Acquire(tenantID)
 	if err != nil {
 		log.Fatalf("open tenant db: %v", err)
 	}
+	defer release()
 
 	// 4. Redis for sync
 	rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
@@ -87,7 +88,7 @@ func main() {
 	if err := rdb.Ping(ctx).Err(); err != nil {
 		log.Fatalf("redis ping: %v", err)
 	}
-	hub := sync.NewHub(rdb)
+	hub := sync.NewHub(sync.NewRedisBackend(rdb))
 
 	// 5. Skip if products already exist (avoids duplicates on container restart)
 	var count int
@@ -142,10 +143,10 @@ func main() {
 	// 7. Write sync_log entries so the frontend worker picks them up.
 	// The worker wraps delta application in BEGIN/COMMIT, so 30k entries
 	// are processed in ~1 second (single transaction, single fsync).
-	newVersion, err := hub.NextVersion(ctx, tenantID)
+	newVersion, err := sync.NextVersion(ctx, tx)
 	if err != nil {
-		log.Printf("warning: redis version failed (sync may be stale): %v", err)
-		newVersion = 1
+		tx.Rollback()
+		log.Fatalf("allocate version: %v", err)
 	}
 
 	syncStmt, err := tx.Prepare(
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ouroboros/backend/internal/backup"
+	"github.com/ouroboros/backend/internal/migrations"
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: migrate [-n] [-j concurrency] [-to version [-force]] [tenant_id...]\n")
+	fmt.Fprintf(os.Stderr, "\nApplies pending migrations to every tenant database in DATA_DIR (or the given\n")
+	fmt.Fprintf(os.Stderr, "tenants), suspended ones included, snapshotting each into BACKUP_DIR (default\n")
+	fmt.Fprintf(os.Stderr, "DATA_DIR/backups) first. -n only lists the pending migrations and any drift.\n")
+	fmt.Fprintf(os.Stderr, "Run it before starting, or alongside an API server with TENANT_MIGRATIONS=require.\n")
+	fmt.Fprintf(os.Stderr, "With -to, rolls the databases back to that version with the down scripts instead;\n")
+	fmt.Fprintf(os.Stderr, "stop the API server first, or it migrates them up again.\n\n")
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+// result is the outcome of migrating one tenant.
+type result struct {
+	id       string
+	from, to int      // schema versions before and after
+	pending  []string // migrations to apply, or down scripts to run
+	drift    []migrations.Drift
+	snapshot string
+	err      error
+}
+
+func main() {
+	dryRun := flag.Bool("n", false, "dry run: report pending migrations without applying them")
+	concurrency := flag.Int("j", 4, "tenants migrated at once")
+	target := flag.Int("to", -1, "roll back to this version")
+	force := flag.Bool("force", false, "with -to, also roll back migrations declared irreversible")
+	flag.Usage = usage
+	flag.Parse()
+	if *concurrency < 1 {
+		usage()
+	}
+
+	dataDir := envOr("DATA_DIR", "./data")
+	backupDir := envOr("BACKUP_DIR", filepath.Join(dataDir, "backups"))
+	switch drift := envOr("MIGRATION_DRIFT", "warn"); drift {
+	case "warn":
+	case "refuse":
+		migrations.Tenant.Drift = migrations.DriftRefuse
+	default:
+		log.Fatalf("invalid MIGRATION_DRIFT %q (want warn or refuse)", drift)
+	}
+
+	// Databases are opened as they are, to be snapshotted before migrating
+	tm := tenant.NewManager(dataDir, *concurrency)
+	tm.SetMigrate(func(*sql.DB) error { return nil })
+	store := backup.NewStore(backupDir, tm, nil)
+	ctx := context.Background()
+
+	ids := flag.Args()
+	if len(ids) == 0 {
+		var err error
+		if ids, err = tm.Tenants(); err != nil {
+			log.Fatalf("list tenants: %v", err)
+		}
+	}
+
+	results := make([]result, len(ids))
+	sem := make(chan struct{}, *concurrency)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if *target >= 0 {
+				results[i] = rollbackTenant(ctx, tm, store, id, *target, *force, *dryRun)
+			} else {
+				results[i] = migrateTenant(ctx, tm, store, id, *dryRun)
+			}
+		}()
+	}
+	wg.Wait()
+	tm.CloseAll()
+
+	done := "migrated"
+	if *target >= 0 {
+		done = "rolled back"
+	}
+	var migrated, current, failed int
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			failed++
+			fmt.Printf("%s\tFAILED\tversion %d -> %d\t%v", r.id, r.from, r.to, r.err)
+		case len(r.pending) == 0:
+			current++
+			fmt.Printf("%s\tup to date\tversion %d", r.id, r.to)
+		case *dryRun:
+			fmt.Printf("%s\tpending\tversion %d\t%s", r.id, r.from, strings.Join(r.pending, ", "))
+		default:
+			migrated++
+			fmt.Printf("%s\t%s\tversion %d -> %d", r.id, done, r.from, r.to)
+		}
+		if r.snapshot != "" {
+			fmt.Printf("\tbackup %s", r.snapshot)
+		}
+		fmt.Println()
+		for _, d := range r.drift {
+			fmt.Printf("%s\tdrift\t%s\n", r.id, d)
+		}
+	}
+
+	if *dryRun {
+		log.Printf("%d tenant(s): %d up to date, %d pending, %d failed", len(results), current, len(results)-current-failed, failed)
+	} else {
+		log.Printf("%d tenant(s): %d %s, %d up to date, %d failed", len(results), migrated, done, current, failed)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// migrateTenant snapshots a tenant's database and applies its pending
+// migrations, or only looks them up on a dry run.
+func migrateTenant(ctx context.Context, tm *tenant.Manager, store *backup.Store, id string, dryRun bool) result {
+	r := result{id: id}
+	db, release, err := open(tm, id)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	defer release()
+
+	if r.from, r.pending, err = migrations.Tenant.Pending(db); err != nil {
+		r.err = err
+		return r
+	}
+	r.to = r.from
+	if dryRun {
+		r.drift, r.err = migrations.Tenant.Verify(db)
+		return r
+	}
+	if len(r.pending) == 0 {
+		return r
+	}
+
+	snap, err := store.Create(ctx, id)
+	if err != nil {
+		r.err = fmt.Errorf("backup: %w", err)
+		return r
+	}
+	r.snapshot = snap.Name
+	r.err = migrations.Tenant.Run(db)
+	// A failed migration leaves those before it applied
+	if version, _, err := migrations.Tenant.Pending(db); err == nil {
+		r.to = version
+	}
+	return r
+}
+
+// rollbackTenant snapshots a tenant's database and rolls it back to target,
+// or only looks up the down scripts to run on a dry run.
+func rollbackTenant(ctx context.Context, tm *tenant.Manager, store *backup.Store, id string, target int, force, dryRun bool) result {
+	r := result{id: id}
+	db, release, err := open(tm, id)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	defer release()
+
+	r.from, r.pending, r.err = migrations.Tenant.RollbackPlan(db, target, force)
+	r.to = r.from
+	if dryRun || r.err != nil || len(r.pending) == 0 {
+		return r
+	}
+
+	snap, err := store.Create(ctx, id)
+	if err != nil {
+		r.err = fmt.Errorf("backup: %w", err)
+		return r
+	}
+	r.snapshot = snap.Name
+	r.err = migrations.Tenant.Rollback(db, target, force)
+	if version, _, err := migrations.Tenant.Pending(db); err == nil {
+		r.to = version
+	}
+	return r
+}
+
+// open leases an existing tenant database; Acquire would create a missing one.
+func open(tm *tenant.Manager, id string) (*sql.DB, func(), error) {
+	path, err := tm.Path(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, nil, err
+	}
+	return tm.Acquire(id)
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
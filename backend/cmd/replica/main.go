@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/ouroboros/backend/internal/replica"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: replica generations <tenant_id>\n")
+	fmt.Fprintf(os.Stderr, "       replica restore <tenant_id> <output.db> [timestamp]\n")
+	fmt.Fprintf(os.Stderr, "       replica purge <tenant_id>\n")
+	fmt.Fprintf(os.Stderr, "\nReads the replica at REPLICA_URL (a directory, file:///path or\n")
+	fmt.Fprintf(os.Stderr, "s3://bucket/prefix?region=...&endpoint=... with AWS_ACCESS_KEY_ID and\n")
+	fmt.Fprintf(os.Stderr, "AWS_SECRET_ACCESS_KEY). restore rebuilds the tenant database as of the RFC 3339\n")
+	fmt.Fprintf(os.Stderr, "timestamp (default now) into a new file; install it with the API server stopped.\n")
+	fmt.Fprintf(os.Stderr, "purge deletes every generation of a tenant, e.g. one deleted without REPLICA_URL.\n")
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) < 3 || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		usage()
+	}
+	replicaURL := os.Getenv("REPLICA_URL")
+	if replicaURL == "" {
+		log.Fatalf("REPLICA_URL required")
+	}
+	store, err := replica.OpenStore(replicaURL)
+	if err != nil {
+		log.Fatalf("invalid REPLICA_URL: %v", err)
+	}
+	ctx := context.Background()
+	tenantID := os.Args[2]
+	args := os.Args[3:]
+
+	switch {
+	case os.Args[1] == "generations" && len(args) == 0:
+		gens, err := replica.Generations(ctx, store, tenantID)
+		if err != nil {
+			log.Fatalf("list generations of tenant %s: %v", tenantID, err)
+		}
+		for _, g := range gens {
+			fmt.Printf("%s\t%s\t%s\t%d\n", g.ID, g.Start.Format(time.RFC3339), g.End.Format(time.RFC3339), g.Segments)
+		}
+	case os.Args[1] == "restore" && (len(args) == 1 || len(args) == 2):
+		at := time.Now()
+		if len(args) == 2 {
+			if at, err = time.Parse(time.RFC3339, args[1]); err != nil {
+				log.Fatalf("invalid timestamp: %v", err)
+			}
+		}
+		restored, err := replica.Restore(ctx, store, tenantID, at, args[0])
+		if err != nil {
+			log.Fatalf("restore tenant %s: %v", tenantID, err)
+		}
+		log.Printf("tenant %s: restored to %s as of %s", tenantID, args[0], restored.Format(time.RFC3339Nano))
+	case os.Args[1] == "purge" && len(args) == 0:
+		if err := replica.Purge(ctx, store, tenantID); err != nil {
+			log.Fatalf("purge tenant %s: %v", tenantID, err)
+		}
+	default:
+		usage()
+	}
+}
@@ -40,10 +40,11 @@ func main() {
 
 	// 2. Open tenant DB
 	tm := tenant.NewManager(dataDir, 4)
-	db, err := tm.DB(user.TenantID)
+	db, release, err := tm.Acquire(user.TenantID)
 	if err != nil {
 		log.Fatalf("open tenant db: %v", err)
 	}
+	defer release()
 
 	// 3. Redis client
 	rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
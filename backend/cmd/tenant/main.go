@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ouroboros/backend/internal/auth"
+	"github.com/ouroboros/backend/internal/backup"
+	"github.com/ouroboros/backend/internal/provision"
+	"github.com/ouroboros/backend/internal/replica"
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: tenant create <tenant_id> [plan]\n")
+	fmt.Fprintf(os.Stderr, "       tenant list\n")
+	fmt.Fprintf(os.Stderr, "       tenant suspend <tenant_id> [reason]\n")
+	fmt.Fprintf(os.Stderr, "       tenant resume <tenant_id>\n")
+	fmt.Fprintf(os.Stderr, "       tenant export <tenant_id> <output.zip>\n")
+	fmt.Fprintf(os.Stderr, "       tenant delete <tenant_id>\n")
+	fmt.Fprintf(os.Stderr, "       tenant vacuum <tenant_id>\n")
+	fmt.Fprintf(os.Stderr, "\nAdministers the tenant registry in DATA_DIR/system.db. A running API server\n")
+	fmt.Fprintf(os.Stderr, "sees suspensions within a few seconds, but only the admin API closes open\n")
+	fmt.Fprintf(os.Stderr, "streams; delete (which also removes the snapshots in BACKUP_DIR and the replica\n")
+	fmt.Fprintf(os.Stderr, "at REPLICA_URL, if set) only with the server stopped, or use\n")
+	fmt.Fprintf(os.Stderr, "DELETE /admin/tenants/{id} instead. vacuum rebuilds a tenant database with a\n")
+	fmt.Fprintf(os.Stderr, "full VACUUM, holding its write lock until done.\n")
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		usage()
+	}
+
+	dataDir := envOr("DATA_DIR", "./data")
+	backupDir := envOr("BACKUP_DIR", filepath.Join(dataDir, "backups"))
+
+	sdb, err := auth.OpenSystemDB(filepath.Join(dataDir, "system.db"))
+	if err != nil {
+		log.Fatalf("open system db: %v", err)
+	}
+	defer sdb.Close()
+	tm := tenant.NewManager(dataDir, 4)
+	tm.SetRegistry(sdb)
+	defer tm.CloseAll()
+	// Nothing is replicated from here; the replicator only purges deleted tenants
+	var rep *replica.Replicator
+	if replicaURL := os.Getenv("REPLICA_URL"); replicaURL != "" {
+		store, err := replica.OpenStore(replicaURL)
+		if err != nil {
+			log.Fatalf("invalid REPLICA_URL: %v", err)
+		}
+		rep = replica.New(store, time.Second, 0)
+	}
+	svc := provision.NewService(sdb, tm, nil, backup.NewStore(backupDir, tm, nil), rep)
+	ctx := context.Background()
+	args := os.Args[2:]
+
+	switch {
+	case os.Args[1] == "create" && (len(args) == 1 || len(args) == 2):
+		plan := ""
+		if len(args) == 2 {
+			plan = args[1]
+		}
+		t, err := svc.Create(args[0], plan, "cli")
+		if err != nil {
+			log.Fatalf("create tenant %s: %v", args[0], err)
+		}
+		printTenant(*t)
+	case os.Args[1] == "list" && len(args) == 0:
+		tenants, err := sdb.ListTenants()
+		if err != nil {
+			log.Fatalf("list tenants: %v", err)
+		}
+		for _, t := range tenants {
+			printTenant(t)
+		}
+	case os.Args[1] == "suspend" && len(args) >= 1:
+		t, err := svc.Suspend(args[0], strings.Join(args[1:], " "))
+		if err != nil {
+			log.Fatalf("suspend tenant %s: %v", args[0], err)
+		}
+		printTenant(*t)
+	case os.Args[1] == "resume" && len(args) == 1:
+		t, err := svc.Resume(args[0])
+		if err != nil {
+			log.Fatalf("resume tenant %s: %v", args[0], err)
+		}
+		printTenant(*t)
+	case os.Args[1] == "export" && len(args) == 2:
+		exp, err := svc.Export(ctx, args[0])
+		if err != nil {
+			log.Fatalf("export tenant %s: %v", args[0], err)
+		}
+		defer exp.Close()
+		f, err := os.OpenFile(args[1], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			log.Fatalf("export tenant %s: %v", args[0], err)
+		}
+		if err := exp.WriteZip(f); err != nil {
+			f.Close()
+			os.Remove(args[1])
+			log.Fatalf("export tenant %s: %v", args[0], err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatalf("export tenant %s: %v", args[0], err)
+		}
+		log.Printf("tenant %s: exported to %s", args[0], args[1])
+	case os.Args[1] == "delete" && len(args) == 1:
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		if err := svc.Delete(ctx, args[0]); err != nil {
+			log.Fatalf("delete tenant %s: %v", args[0], err)
+		}
+	case os.Args[1] == "vacuum" && len(args) == 1:
+		db, release, err := tm.Acquire(args[0])
+		if err != nil {
+			log.Fatalf("vacuum tenant %s: %v", args[0], err)
+		}
+		start := time.Now()
+		err = tenant.Vacuum(ctx, db)
+		release()
+		if err != nil {
+			log.Fatalf("vacuum tenant %s: %v", args[0], err)
+		}
+		log.Printf("tenant %s: vacuumed in %s", args[0], time.Since(start).Round(time.Millisecond))
+	default:
+		usage()
+	}
+}
+
+func printTenant(t auth.Tenant) {
+	fmt.Printf("%s\t%s\t%s\t%d user(s)\tcreated %s by %s", t.ID, t.Status, t.Plan, t.Users, t.CreatedAt, t.CreatedBy)
+	if t.Reason != "" {
+		fmt.Printf("\t(%s)", t.Reason)
+	}
+	fmt.Println()
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -14,6 +14,7 @@ type contextKey string
 const (
 	TenantKey contextKey = "tenant_id"
 	UserKey   contextKey = "user_id"
+	RoleKey   contextKey = "role"
 )
 
 // Auth handles JWT creation and verification entirely in-memory.
@@ -29,15 +30,17 @@ func New(secret []byte) *Auth {
 type Claims struct {
 	TenantID string `json:"tid"`
 	UserID   string `json:"uid"`
+	Role     Role   `json:"role"`
 	jwt.RegisteredClaims
 }
 
 // Issue creates a signed JWT for a given tenant and user.
-func (a *Auth) Issue(tenantID, userID string, ttl time.Duration) (string, error) {
+func (a *Auth) Issue(tenantID, userID string, role Role, ttl time.Duration) (string, error) {
 	now := time.Now()
 	claims := Claims{
 		TenantID: tenantID,
 		UserID:   userID,
+		Role:     role,
 		RegisteredClaims: jwt.RegisteredClaims{
 			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
@@ -59,34 +62,40 @@ func (a *Auth) Verify(tokenStr string) (*Claims, error) {
 }
 
 // Middleware extracts the JWT from the Authorization header, verifies it,
-// and injects tenant_id + user_id into context. No DB lookups.
+// and injects tenant_id, user_id and role into context. No DB lookups.
+// Tokens issued before roles existed are refused, so their users log in again.
 // Public paths under /api/auth/ are passed through without token checks.
 func (a *Auth) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Skip auth for public endpoints and static files (frontend SPA)
-		// Only require JWT for /api/* (except /api/auth/) and /sse/*
+		// Only require JWT for /api/* (except /api/auth/), /sse/* and /ws/*
 		if strings.HasPrefix(r.URL.Path, "/api/auth/") {
 			next.ServeHTTP(w, r)
 			return
 		}
-		if !strings.HasPrefix(r.URL.Path, "/api") && !strings.HasPrefix(r.URL.Path, "/sse") {
+		if !strings.HasPrefix(r.URL.Path, "/api") && !strings.HasPrefix(r.URL.Path, "/sse") && !strings.HasPrefix(r.URL.Path, "/ws") {
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		header := r.Header.Get("Authorization")
+		// Browsers cannot set headers on a WebSocket handshake, so /ws/ also accepts ?token=
+		if t := r.URL.Query().Get("token"); header == "" && t != "" && strings.HasPrefix(r.URL.Path, "/ws/") {
+			header = "Bearer " + t
+		}
 		if !strings.HasPrefix(header, "Bearer ") {
 			http.Error(w, `{"error":"missing authorization"}`, http.StatusUnauthorized)
 			return
 		}
 		tokenStr := strings.TrimPrefix(header, "Bearer ")
 		claims, err := a.Verify(tokenStr)
-		if err != nil {
+		if err != nil || !claims.Role.Valid() {
 			http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
 			return
 		}
 		ctx := context.WithValue(r.Context(), TenantKey, claims.TenantID)
 		ctx = context.WithValue(ctx, UserKey, claims.UserID)
+		ctx = context.WithValue(ctx, RoleKey, claims.Role)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Role is a user's role within their tenant, stored in system.db and carried
+// in the JWT. Routes behind RequireCurrent go by the stored role, so a role
+// change applies to them at once; Require trusts the token until it expires.
+type Role string
+
+const (
+	RoleOwner   Role = "owner"   // everything, including managing other owners
+	RoleAdmin   Role = "admin"   // everything but managing owners
+	RoleMember  Role = "member"  // works on the board and takes orders
+	RoleCashier Role = "cashier" // takes orders at the point of sale
+	RoleViewer  Role = "viewer"  // read only
+)
+
+// Permission is an action a role may be allowed; the name is what a 403
+// reports as missing.
+type Permission string
+
+const (
+	PermReadData       Permission = "data:read"       // list, sync and stream tenant data
+	PermWriteProjects  Permission = "projects:write"  // create projects
+	PermDeleteProjects Permission = "projects:delete" // delete projects with everything in them
+	PermWriteBoard     Permission = "board:write"     // cards, columns, tags, assignees, approvers, sessions
+	PermApproveCards   Permission = "cards:approve"   // set a card's approval status directly
+	PermWriteProducts  Permission = "products:write"  // edit the product catalog
+	PermCreateOrders   Permission = "orders:create"   // place orders
+	PermManageUsers    Permission = "users:manage"    // invite users and change their roles
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleOwner: {PermReadData, PermWriteProjects, PermDeleteProjects, PermWriteBoard, PermApproveCards,
+		PermWriteProducts, PermCreateOrders, PermManageUsers},
+	RoleAdmin: {PermReadData, PermWriteProjects, PermDeleteProjects, PermWriteBoard, PermApproveCards,
+		PermWriteProducts, PermCreateOrders, PermManageUsers},
+	RoleMember:  {PermReadData, PermWriteProjects, PermWriteBoard, PermCreateOrders},
+	RoleCashier: {PermReadData, PermCreateOrders},
+	RoleViewer:  {PermReadData},
+}
+
+// Valid reports whether r is one of the roles above.
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Can reports whether r has permission p.
+func (r Role) Can(p Permission) bool {
+	for _, have := range rolePermissions[r] {
+		if have == p {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleFromCtx extracts the user's role from context.
+func RoleFromCtx(ctx context.Context) Role {
+	v, _ := ctx.Value(RoleKey).(Role)
+	return v
+}
+
+// Can reports whether the user in ctx has permission p.
+func Can(ctx context.Context, p Permission) bool {
+	return RoleFromCtx(ctx).Can(p)
+}
+
+// Require guards a route behind Middleware with permission p.
+func Require(p Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !Can(r.Context(), p) {
+				Forbidden(w, p)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RoleSource looks up users' current roles; SystemDB is one.
+type RoleSource interface {
+	// CurrentRole fails with ErrUserNotFound for users since removed.
+	CurrentRole(tenantID, userID string) (Role, error)
+}
+
+// RequireCurrent is Require by the user's current role, looked up in src, for
+// routes a demoted user must lose at once. The role in the request context
+// is replaced with it; users removed since their token was issued get 401.
+func RequireCurrent(src RoleSource, p Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, err := src.CurrentRole(TenantFromCtx(r.Context()), UserFromCtx(r.Context()))
+			if errors.Is(err, ErrUserNotFound) {
+				http.Error(w, `{"error":"user no longer exists"}`, http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				http.Error(w, `{"error":"role lookup failed"}`, http.StatusInternalServerError)
+				return
+			}
+			if !role.Can(p) {
+				Forbidden(w, p)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), RoleKey, role)))
+		})
+	}
+}
+
+// Forbidden replies 403 naming the missing permission.
+func Forbidden(w http.ResponseWriter, p Permission) {
+	http.Error(w, `{"error":"missing permission `+string(p)+`","permission":"`+string(p)+`"}`, http.StatusForbidden)
+}
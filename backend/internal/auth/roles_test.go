@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var allPermissions = []Permission{
+	PermReadData, PermWriteProjects, PermDeleteProjects, PermWriteBoard,
+	PermApproveCards, PermWriteProducts, PermCreateOrders, PermManageUsers,
+}
+
+// TestRequire checks every role against every permission, spelled out rather
+// than derived from rolePermissions so that a change to a role shows up here.
+func TestRequire(t *testing.T) {
+	allowed := map[Role][]Permission{
+		RoleOwner:   allPermissions,
+		RoleAdmin:   allPermissions,
+		RoleMember:  {PermReadData, PermWriteProjects, PermWriteBoard, PermCreateOrders},
+		RoleCashier: {PermReadData, PermCreateOrders},
+		RoleViewer:  {PermReadData},
+		"":          nil,
+		"root":      nil,
+	}
+	for role, perms := range allowed {
+		for _, p := range allPermissions {
+			want := http.StatusForbidden
+			for _, q := range perms {
+				if q == p {
+					want = http.StatusOK
+				}
+			}
+			ctx := context.WithValue(context.Background(), RoleKey, role)
+			req := httptest.NewRequest(http.MethodGet, "/api/x", nil).WithContext(ctx)
+			rec := httptest.NewRecorder()
+			Require(p)(okHandler()).ServeHTTP(rec, req)
+			if rec.Code != want {
+				t.Errorf("role %q, %s: status %d, want %d", role, p, rec.Code, want)
+			}
+			if want == http.StatusForbidden && !strings.Contains(rec.Body.String(), `"permission":"`+string(p)+`"`) {
+				t.Errorf("role %q, %s: 403 does not name the permission: %s", role, p, rec.Body)
+			}
+		}
+	}
+}
+
+// TestRequireToken follows a role from the token through Middleware.
+func TestRequireToken(t *testing.T) {
+	a := New([]byte("secret"))
+	h := a.Middleware(Require(PermWriteBoard)(okHandler()))
+	tests := []struct {
+		name string
+		role Role
+		want int
+	}{
+		{"member", RoleMember, http.StatusOK},
+		{"viewer", RoleViewer, http.StatusForbidden},
+		{"token without a role", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := a.Issue("acme", "u1", tt.role, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/api/kanban/cards", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+type fakeRoles map[string]Role
+
+func (f fakeRoles) CurrentRole(tenantID, userID string) (Role, error) {
+	if userID == "broken" {
+		return "", errors.New("database is locked")
+	}
+	role, ok := f[tenantID+"/"+userID]
+	if !ok {
+		return "", ErrUserNotFound
+	}
+	return role, nil
+}
+
+// TestRequireCurrent checks that the stored role wins over the token's.
+func TestRequireCurrent(t *testing.T) {
+	src := fakeRoles{"acme/demoted": RoleViewer, "acme/promoted": RoleAdmin}
+	var seen Role
+	h := RequireCurrent(src, PermWriteBoard)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RoleFromCtx(r.Context())
+	}))
+	tests := []struct {
+		user      string
+		tokenRole Role
+		want      int
+		wantRole  Role
+	}{
+		{"demoted", RoleAdmin, http.StatusForbidden, ""},
+		{"promoted", RoleViewer, http.StatusOK, RoleAdmin},
+		{"removed", RoleOwner, http.StatusUnauthorized, ""},
+		{"broken", RoleOwner, http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+			seen = ""
+			ctx := context.WithValue(context.Background(), TenantKey, "acme")
+			ctx = context.WithValue(ctx, UserKey, tt.user)
+			ctx = context.WithValue(ctx, RoleKey, tt.tokenRole)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/x", nil).WithContext(ctx))
+			if rec.Code != tt.want || seen != tt.wantRole {
+				t.Errorf("status %d, role %q; want %d, %q", rec.Code, seen, tt.want, tt.wantRole)
+			}
+		})
+	}
+}
+
+func okHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+}
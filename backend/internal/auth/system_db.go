@@ -8,9 +8,12 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	gosync "sync"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
+	"github.com/ouroboros/backend/internal/migrations"
+	"github.com/ouroboros/backend/internal/tenant"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -18,17 +21,23 @@ var (
 	ErrEmailTaken    = errors.New("email already registered")
 	ErrInvalidCreds  = errors.New("invalid email or password")
 	ErrUserNotFound  = errors.New("user not found")
+	ErrLastOwner     = errors.New("a tenant needs an owner")
 )
 
 type User struct {
 	ID       string `json:"id"`
 	Email    string `json:"email"`
 	TenantID string `json:"tenant_id"`
+	Role     Role   `json:"role"`
 }
 
 // SystemDB manages the central users database (not per-tenant).
 type SystemDB struct {
 	db *sql.DB
+
+	mu       gosync.Mutex
+	statuses map[string]cachedStatus // CheckTenant results by tenant
+	roles    map[userKey]cachedRole  // CurrentRole results by user
 }
 
 func OpenSystemDB(path string) (*SystemDB, error) {
@@ -38,45 +47,79 @@ func OpenSystemDB(path string) (*SystemDB, error) {
 	}
 	db.SetMaxOpenConns(1)
 
-	if err := migrate(db); err != nil {
+	if err := migrations.System.Run(db); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("migrate system db: %w", err)
 	}
 
 	log.Printf("[auth] system.db ready at %s", path)
-	return &SystemDB{db: db}, nil
-}
-
-func migrate(db *sql.DB) error {
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS users (
-			id            TEXT PRIMARY KEY,
-			email         TEXT NOT NULL UNIQUE,
-			password_hash TEXT NOT NULL,
-			tenant_id     TEXT NOT NULL,
-			created_at    TEXT NOT NULL DEFAULT (datetime('now'))
-		);
-		CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
-		CREATE INDEX IF NOT EXISTS idx_users_tenant ON users(tenant_id);
-	`)
-	return err
+	return &SystemDB{db: db, statuses: make(map[string]cachedStatus), roles: make(map[userKey]cachedRole)}, nil
 }
 
 func (s *SystemDB) Close() error {
 	return s.db.Close()
 }
 
-// Register creates a new user with a bcrypt-hashed password.
+// Register signs up a new tenant, whose ID must pass tenant.ValidID, with
+// the user (bcrypt-hashed password) as its owner. Signing up to a tenant that
+// is already registered fails with ErrTenantExists: users join one through
+// Invite.
 func (s *SystemDB) Register(email, password, tenantID string) (*User, error) {
+	return s.register(email, password, tenantID, "")
+}
+
+// Invite creates a user with the given role in an existing tenant. Unknown,
+// suspended and deleted tenants fail with ErrTenantNotFound,
+// ErrTenantSuspended and ErrTenantDeleted.
+func (s *SystemDB) Invite(email, password, tenantID string, role Role) (*User, error) {
+	if !role.Valid() {
+		return nil, fmt.Errorf("invalid role %q", role)
+	}
+	return s.register(email, password, tenantID, role)
+}
+
+// register creates a user; an empty role signs up a tenant for it.
+func (s *SystemDB) register(email, password, tenantID string, role Role) (*User, error) {
+	if !tenant.ValidID(tenantID) {
+		return nil, tenant.ErrInvalidID
+	}
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, fmt.Errorf("hash password: %w", err)
 	}
 
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if role == "" {
+		if _, err := tx.Exec("INSERT INTO tenants (id, created_by) VALUES (?, 'signup')", tenantID); err != nil {
+			if isUniqueViolation(err) {
+				return nil, ErrTenantExists
+			}
+			return nil, fmt.Errorf("insert tenant: %w", err)
+		}
+		role = RoleOwner
+	} else {
+		var status string
+		err := tx.QueryRow("SELECT status FROM tenants WHERE id = ?", tenantID).Scan(&status)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTenantNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("query tenant: %w", err)
+		}
+		if err := statusErr(status); err != nil {
+			return nil, err
+		}
+	}
+
 	id := generateUUIDv7()
-	_, err = s.db.Exec(
-		"INSERT INTO users (id, email, password_hash, tenant_id) VALUES (?, ?, ?, ?)",
-		id, email, string(hash), tenantID,
+	_, err = tx.Exec(
+		"INSERT INTO users (id, email, password_hash, tenant_id, role) VALUES (?, ?, ?, ?, ?)",
+		id, email, string(hash), tenantID, role,
 	)
 	if err != nil {
 		if isUniqueViolation(err) {
@@ -84,17 +127,23 @@ func (s *SystemDB) Register(email, password, tenantID string) (*User, error) {
 		}
 		return nil, fmt.Errorf("insert user: %w", err)
 	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.forgetStatus(tenantID)
 
-	return &User{ID: id, Email: email, TenantID: tenantID}, nil
+	return &User{ID: id, Email: email, TenantID: tenantID, Role: role}, nil
 }
 
-// Login verifies credentials and returns the user.
+// Login verifies credentials and returns the user. Users of a suspended
+// tenant get ErrTenantSuspended once their password checks out.
 func (s *SystemDB) Login(email, password string) (*User, error) {
 	var u User
-	var hash string
+	var hash, status string
 	err := s.db.QueryRow(
-		"SELECT id, email, tenant_id, password_hash FROM users WHERE email = ?", email,
-	).Scan(&u.ID, &u.Email, &u.TenantID, &hash)
+		`SELECT u.id, u.email, u.tenant_id, u.role, u.password_hash, COALESCE(t.status, 'active')
+		 FROM users u LEFT JOIN tenants t ON t.id = u.tenant_id WHERE u.email = ?`, email,
+	).Scan(&u.ID, &u.Email, &u.TenantID, &u.Role, &hash, &status)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrInvalidCreds
@@ -105,13 +154,16 @@ func (s *SystemDB) Login(email, password string) (*User, error) {
 	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
 		return nil, ErrInvalidCreds
 	}
+	if err := statusErr(status); err != nil {
+		return nil, err
+	}
 
 	return &u, nil
 }
 
 // ListByTenant returns all users in a tenant (for approver selection).
 func (s *SystemDB) ListByTenant(tenantID string) ([]User, error) {
-	rows, err := s.db.Query("SELECT id, email, tenant_id FROM users WHERE tenant_id = ?", tenantID)
+	rows, err := s.db.Query("SELECT id, email, tenant_id, role FROM users WHERE tenant_id = ?", tenantID)
 	if err != nil {
 		return nil, err
 	}
@@ -120,7 +172,7 @@ func (s *SystemDB) ListByTenant(tenantID string) ([]User, error) {
 	var users []User
 	for rows.Next() {
 		var u User
-		rows.Scan(&u.ID, &u.Email, &u.TenantID)
+		rows.Scan(&u.ID, &u.Email, &u.TenantID, &u.Role)
 		users = append(users, u)
 	}
 	if users == nil {
@@ -129,6 +181,97 @@ func (s *SystemDB) ListByTenant(tenantID string) ([]User, error) {
 	return users, nil
 }
 
+// UserRole returns the role of a user in a tenant.
+func (s *SystemDB) UserRole(tenantID, userID string) (Role, error) {
+	var role Role
+	err := s.db.QueryRow("SELECT role FROM users WHERE id = ? AND tenant_id = ?", userID, tenantID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("query user: %w", err)
+	}
+	return role, nil
+}
+
+// roleTTL is how long CurrentRole trusts a user's role without asking
+// system.db again; as with statusTTL, changes made through this SystemDB
+// apply at once.
+const roleTTL = 5 * time.Second
+
+type userKey struct{ tenantID, userID string }
+
+type cachedRole struct {
+	role Role
+	err  error
+	at   time.Time
+}
+
+// CurrentRole implements RoleSource: it is UserRole, cached for roleTTL as
+// it is asked on every write.
+func (s *SystemDB) CurrentRole(tenantID, userID string) (Role, error) {
+	key := userKey{tenantID, userID}
+	s.mu.Lock()
+	c, ok := s.roles[key]
+	s.mu.Unlock()
+	if ok && time.Since(c.at) < roleTTL {
+		return c.role, c.err
+	}
+
+	role, err := s.UserRole(tenantID, userID)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return "", err
+	}
+	s.mu.Lock()
+	s.roles[key] = cachedRole{role: role, err: err, at: time.Now()}
+	s.mu.Unlock()
+	return role, err
+}
+
+// forgetRoles drops the cached roles of a tenant's users, or of one of them.
+func (s *SystemDB) forgetRoles(tenantID, userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.roles {
+		if key.tenantID == tenantID && (userID == "" || key.userID == userID) {
+			delete(s.roles, key)
+		}
+	}
+}
+
+// SetUserRole changes the role of a user in a tenant. Demoting the tenant's
+// last owner fails with ErrLastOwner.
+func (s *SystemDB) SetUserRole(tenantID, userID string, role Role) error {
+	if !role.Valid() {
+		return fmt.Errorf("invalid role %q", role)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE users SET role = ? WHERE id = ? AND tenant_id = ?", role, userID, tenantID)
+	if err != nil {
+		return fmt.Errorf("update user: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	var owners int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE tenant_id = ? AND role = 'owner'", tenantID).Scan(&owners); err != nil {
+		return fmt.Errorf("count owners: %w", err)
+	}
+	if owners == 0 {
+		return ErrLastOwner
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.forgetRoles(tenantID, userID)
+	return nil
+}
+
 func (s *SystemDB) DB() *sql.DB {
 	return s.db
 }
@@ -148,6 +291,10 @@ func generateUUIDv7() string {
 		hex.EncodeToString(buf[10:16])
 }
 
+// isUniqueViolation reports whether err is a UNIQUE or PRIMARY KEY constraint
+// failure.
 func isUniqueViolation(err error) bool {
-	return err != nil && (errors.Is(err, sql.ErrNoRows) || err.Error() == "UNIQUE constraint failed: users.email" || len(err.Error()) > 0 && err.Error()[:6] == "UNIQUE")
+	var se sqlite3.Error
+	return errors.As(err, &se) &&
+		(se.ExtendedCode == sqlite3.ErrConstraintUnique || se.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
 }
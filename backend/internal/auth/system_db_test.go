@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+func openTestSystemDB(t *testing.T) *SystemDB {
+	t.Helper()
+	s, err := OpenSystemDB(filepath.Join(t.TempDir(), "system.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestRegisterAndInvite(t *testing.T) {
+	s := openTestSystemDB(t)
+
+	owner, err := s.Register("owner@acme.io", "secret1", "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner.Role != RoleOwner {
+		t.Errorf("signup role = %q, want owner", owner.Role)
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		do   func() (*User, error)
+	}{
+		{"signup to an existing tenant", ErrTenantExists, func() (*User, error) {
+			return s.Register("other@acme.io", "secret1", "acme")
+		}},
+		{"signup with a taken email", ErrEmailTaken, func() (*User, error) {
+			return s.Register("owner@acme.io", "secret1", "globex")
+		}},
+		{"signup with an invalid tenant id", tenant.ErrInvalidID, func() (*User, error) {
+			return s.Register("x@acme.io", "secret1", "../acme")
+		}},
+		{"invite to an unknown tenant", ErrTenantNotFound, func() (*User, error) {
+			return s.Invite("x@acme.io", "secret1", "initech", RoleMember)
+		}},
+		{"invite with a taken email", ErrEmailTaken, func() (*User, error) {
+			return s.Invite("owner@acme.io", "secret1", "acme", RoleMember)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.do(); !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	// A failed signup leaves no tenant behind
+	if _, err := s.GetTenant("globex"); !errors.Is(err, ErrTenantNotFound) {
+		t.Errorf("tenant of a failed signup: err = %v", err)
+	}
+
+	member, err := s.Invite("member@acme.io", "secret1", "acme", RoleMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if member.Role != RoleMember || member.TenantID != "acme" {
+		t.Errorf("invited %+v", member)
+	}
+
+	if _, err := s.SetTenantStatus("acme", TenantSuspended, "unpaid"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Invite("late@acme.io", "secret1", "acme", RoleMember); !errors.Is(err, ErrTenantSuspended) {
+		t.Errorf("invite to a suspended tenant: err = %v", err)
+	}
+}
+
+func TestCurrentRole(t *testing.T) {
+	s := openTestSystemDB(t)
+	owner, err := s.Register("owner@acme.io", "secret1", "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	member, err := s.Invite("member@acme.io", "secret1", "acme", RoleMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if role, err := s.CurrentRole("acme", member.ID); err != nil || role != RoleMember {
+		t.Fatalf("role = %q, err = %v", role, err)
+	}
+	// Cached, yet a change through this SystemDB applies at once
+	if err := s.SetUserRole("acme", member.ID, RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	if role, _ := s.CurrentRole("acme", member.ID); role != RoleViewer {
+		t.Errorf("after demotion: role = %q, want viewer", role)
+	}
+	if err := s.SetUserRole("acme", owner.ID, RoleAdmin); !errors.Is(err, ErrLastOwner) {
+		t.Errorf("demoting the last owner: err = %v", err)
+	}
+	if _, err := s.CurrentRole("globex", member.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("user of another tenant: err = %v", err)
+	}
+
+	if err := s.DeleteTenant("acme"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CurrentRole("acme", member.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("user of a deleted tenant: err = %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+// Tenant statuses. Suspended tenants keep their data but every request is
+// refused; deleted tenants have no data left and their ID stays reserved.
+const (
+	TenantActive    = "active"
+	TenantSuspended = "suspended"
+	TenantDeleted   = "deleted"
+)
+
+var (
+	ErrTenantExists    = errors.New("tenant already exists")
+	ErrTenantNotFound  = errors.New("tenant not found")
+	ErrTenantSuspended = errors.New("tenant suspended")
+	ErrTenantDeleted   = errors.New("tenant deleted")
+)
+
+// Tenant is a tenant's entry in the registry.
+type Tenant struct {
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	Plan      string `json:"plan"`
+	Reason    string `json:"reason,omitempty"` // why it was suspended
+	CreatedBy string `json:"created_by"`       // signup, admin, cli or backfill
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+	Users     int    `json:"users"`
+}
+
+const tenantColumns = `t.id, t.status, t.plan, t.reason, t.created_by, t.created_at, t.updated_at,
+	(SELECT COUNT(*) FROM users u WHERE u.tenant_id = t.id)`
+
+func scanTenant(row interface{ Scan(...any) error }) (*Tenant, error) {
+	var t Tenant
+	err := row.Scan(&t.ID, &t.Status, &t.Plan, &t.Reason, &t.CreatedBy, &t.CreatedAt, &t.UpdatedAt, &t.Users)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// CreateTenant registers a new active tenant; the ID must pass tenant.ValidID.
+func (s *SystemDB) CreateTenant(id, plan, createdBy string) (*Tenant, error) {
+	if !tenant.ValidID(id) {
+		return nil, tenant.ErrInvalidID
+	}
+	if plan == "" {
+		plan = "free"
+	}
+	_, err := s.db.Exec("INSERT INTO tenants (id, plan, created_by) VALUES (?, ?, ?)", id, plan, createdBy)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrTenantExists
+		}
+		return nil, fmt.Errorf("insert tenant: %w", err)
+	}
+	s.forgetStatus(id)
+	return s.GetTenant(id)
+}
+
+// GetTenant returns a tenant's registry entry, deleted ones included.
+func (s *SystemDB) GetTenant(id string) (*Tenant, error) {
+	t, err := scanTenant(s.db.QueryRow("SELECT "+tenantColumns+" FROM tenants t WHERE t.id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTenantNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query tenant: %w", err)
+	}
+	return t, nil
+}
+
+// ListTenants returns every registered tenant, deleted ones included.
+func (s *SystemDB) ListTenants() ([]Tenant, error) {
+	rows, err := s.db.Query("SELECT " + tenantColumns + " FROM tenants t ORDER BY t.id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := []Tenant{}
+	for rows.Next() {
+		t, err := scanTenant(rows)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, *t)
+	}
+	return tenants, rows.Err()
+}
+
+// SetTenantStatus suspends (with a reason) or reactivates a tenant. Deleted
+// tenants cannot be brought back.
+func (s *SystemDB) SetTenantStatus(id, status, reason string) (*Tenant, error) {
+	if status != TenantActive && status != TenantSuspended {
+		return nil, fmt.Errorf("invalid tenant status %q", status)
+	}
+	if status == TenantActive {
+		reason = ""
+	}
+	res, err := s.db.Exec(
+		`UPDATE tenants SET status = ?, reason = ?, updated_at = datetime('now')
+		 WHERE id = ? AND status != 'deleted'`, status, reason, id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("update tenant: %w", err)
+	}
+	s.forgetStatus(id)
+	if n, _ := res.RowsAffected(); n == 0 {
+		t, err := s.GetTenant(id)
+		if err != nil {
+			return nil, err
+		}
+		return nil, statusErr(t.Status)
+	}
+	return s.GetTenant(id)
+}
+
+// SetTenantPlan changes a tenant's plan.
+func (s *SystemDB) SetTenantPlan(id, plan string) (*Tenant, error) {
+	res, err := s.db.Exec(
+		"UPDATE tenants SET plan = ?, updated_at = datetime('now') WHERE id = ? AND status != 'deleted'", plan, id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("update tenant: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, err := s.GetTenant(id); err != nil {
+			return nil, err
+		}
+		return nil, ErrTenantDeleted
+	}
+	return s.GetTenant(id)
+}
+
+// DeleteTenant marks a tenant deleted and removes its users. The entry is
+// kept so the ID is never handed out again.
+func (s *SystemDB) DeleteTenant(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		"UPDATE tenants SET status = 'deleted', reason = '', updated_at = datetime('now') WHERE id = ?", id,
+	)
+	if err != nil {
+		return fmt.Errorf("update tenant: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrTenantNotFound
+	}
+	if _, err := tx.Exec("DELETE FROM users WHERE tenant_id = ?", id); err != nil {
+		return fmt.Errorf("delete users: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.forgetStatus(id)
+	s.forgetRoles(id, "")
+	return nil
+}
+
+// statusTTL is how long CheckTenant trusts a tenant's status without asking
+// system.db again. Changes made through this SystemDB apply at once; those
+// made by another process (the tenant command) within statusTTL.
+const statusTTL = 5 * time.Second
+
+type cachedStatus struct {
+	err error
+	at  time.Time
+}
+
+// CheckTenant implements tenant.Registry: only active tenants may be used.
+// It is called on every Acquire, so the answer is cached for statusTTL.
+func (s *SystemDB) CheckTenant(tenantID string) error {
+	s.mu.Lock()
+	c, ok := s.statuses[tenantID]
+	s.mu.Unlock()
+	if ok && time.Since(c.at) < statusTTL {
+		return c.err
+	}
+
+	var status string
+	err := s.db.QueryRow("SELECT status FROM tenants WHERE id = ?", tenantID).Scan(&status)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		err = tenant.ErrUnknownTenant
+	case err != nil:
+		return fmt.Errorf("query tenant: %w", err)
+	case status == TenantActive:
+	case status == TenantSuspended:
+		err = tenant.ErrSuspended
+	default:
+		err = tenant.ErrUnknownTenant
+	}
+	s.mu.Lock()
+	s.statuses[tenantID] = cachedStatus{err: err, at: time.Now()}
+	s.mu.Unlock()
+	return err
+}
+
+// forgetStatus drops the cached status of a tenant after changing it.
+func (s *SystemDB) forgetStatus(tenantID string) {
+	s.mu.Lock()
+	delete(s.statuses, tenantID)
+	s.mu.Unlock()
+}
+
+// statusErr is the error for joining or logging into a tenant with status.
+func statusErr(status string) error {
+	switch status {
+	case TenantSuspended:
+		return ErrTenantSuspended
+	case TenantDeleted:
+		return ErrTenantDeleted
+	}
+	return nil
+}
@@ -0,0 +1,381 @@
+// Package backup takes online snapshots of tenant databases and restores
+// tenants from them.
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/ouroboros/backend/internal/migrations"
+	"github.com/ouroboros/backend/internal/sync"
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+var (
+	ErrNotFound      = errors.New("snapshot not found")
+	ErrUnknownTenant = errors.New("unknown tenant")
+)
+
+// nameLayout names snapshot files after their UTC creation time.
+const nameLayout = "20060102T150405.000Z"
+
+// Snapshot is one backup of a tenant database.
+type Snapshot struct {
+	TenantID  string    `json:"tenant_id"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Store keeps snapshots of tenant databases in dir/<tenant_id>/.
+type Store struct {
+	dir string
+	tm  *tenant.Manager
+	hub *sync.Hub // nil outside the API server
+}
+
+// NewStore returns a Store writing under dir. hub may be nil; with it,
+// restores disconnect the tenant's streams and announce the new version.
+func NewStore(dir string, tm *tenant.Manager, hub *sync.Hub) *Store {
+	return &Store{dir: dir, tm: tm, hub: hub}
+}
+
+// Create snapshots a tenant's database with Copy.
+func (s *Store) Create(ctx context.Context, tenantID string) (Snapshot, error) {
+	src, err := s.tm.Path(tenantID)
+	if err != nil {
+		return Snapshot{}, ErrUnknownTenant
+	}
+	if _, err := os.Stat(src); err != nil {
+		return Snapshot{}, ErrUnknownTenant
+	}
+	// The lease keeps the file from being restored over meanwhile, and the
+	// database migrated.
+	_, release, err := s.tm.AcquireRead(tenantID)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer release()
+
+	dir := filepath.Join(s.dir, tenantID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Snapshot{}, err
+	}
+	now := time.Now().UTC()
+	name := now.Format(nameLayout) + ".db"
+	tmp := filepath.Join(dir, name+".tmp")
+
+	if err := Copy(ctx, src, tmp); err != nil {
+		os.Remove(tmp)
+		return Snapshot{}, err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		os.Remove(tmp)
+		return Snapshot{}, err
+	}
+
+	info, err := os.Stat(filepath.Join(dir, name))
+	if err != nil {
+		return Snapshot{}, err
+	}
+	log.Printf("[backup] tenant %s: wrote %s (%d bytes)", tenantID, name, info.Size())
+	return Snapshot{TenantID: tenantID, Name: name, Size: info.Size(), CreatedAt: now}, nil
+}
+
+// Copy writes a compacted, transactionally consistent copy of the database at
+// src to the new file dst with VACUUM INTO, while writers carry on (WAL).
+func Copy(ctx context.Context, src, dst string) error {
+	// The Manager's read pool is query_only, which VACUUM INTO does not get past
+	db, err := sql.Open("sqlite3", "file:"+src+"?mode=ro&_busy_timeout=5000")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", dst); err != nil {
+		return fmt.Errorf("vacuum into: %w", err)
+	}
+	return nil
+}
+
+// List returns a tenant's snapshots, newest first.
+func (s *Store) List(tenantID string) ([]Snapshot, error) {
+	if !tenant.ValidID(tenantID) {
+		return nil, ErrUnknownTenant
+	}
+	entries, err := os.ReadDir(filepath.Join(s.dir, tenantID))
+	if errors.Is(err, os.ErrNotExist) {
+		return []Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snaps := []Snapshot{}
+	for _, e := range entries {
+		created, ok := parseName(e.Name())
+		if !ok || !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		snaps = append(snaps, Snapshot{TenantID: tenantID, Name: e.Name(), Size: info.Size(), CreatedAt: created})
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].CreatedAt.After(snaps[j].CreatedAt) })
+	return snaps, nil
+}
+
+// Prune deletes a tenant's snapshots older than retention, always keeping the
+// newest one, and returns how many it deleted.
+func (s *Store) Prune(tenantID string, retention time.Duration) (int, error) {
+	snaps, err := s.List(tenantID)
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-retention)
+	n := 0
+	for i, snap := range snaps {
+		if i == 0 || snap.CreatedAt.After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, tenantID, snap.Name)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// DeleteAll deletes every snapshot of a tenant.
+func (s *Store) DeleteAll(tenantID string) error {
+	if !tenant.ValidID(tenantID) {
+		return ErrUnknownTenant
+	}
+	return os.RemoveAll(filepath.Join(s.dir, tenantID))
+}
+
+// Restore replaces a tenant's database with one of its snapshots and returns
+// the tenant's new sync version.
+//
+// The tenant is taken out of service through tenant.Manager (requests get
+// tenant.ErrLocked, streams are told to reconnect) and the snapshot copied in
+// with the SQLite backup API once the last handle is closed. Both the version
+// counter and the compaction floor are then set past any version clients may
+// have seen, so every client's next sync gets 410 and loads a new snapshot.
+//
+// That version is read, best effort, from the current database without
+// migrating it, from the hub and from the tenant's snapshots, so a corrupt or
+// drifted database can still be restored.
+func (s *Store) Restore(ctx context.Context, tenantID, name string) (int64, error) {
+	if !tenant.ValidID(tenantID) {
+		return 0, ErrUnknownTenant
+	}
+	if _, ok := parseName(name); !ok {
+		return 0, ErrNotFound
+	}
+	snap := filepath.Join(s.dir, tenantID, name)
+	if _, err := os.Stat(snap); err != nil {
+		return 0, ErrNotFound
+	}
+
+	var drain func()
+	if s.hub != nil {
+		drain = func() { s.hub.Disconnect(tenantID) }
+	}
+	var version int64
+	err := s.tm.Exclusive(ctx, tenantID, drain, func(path string) error {
+		var err error
+		version, err = restoreFile(ctx, snap, path, s.seenVersion(ctx, tenantID))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if s.hub != nil {
+		s.hub.Notify(ctx, tenantID, version)
+	}
+	log.Printf("[backup] tenant %s: restored %s, sync version reset to %d", tenantID, name, version)
+	return version, nil
+}
+
+// seenVersion returns the highest sync version clients may have seen from a
+// tenant, as far as it can be found out: from its current database, read
+// without migrating it, the hub, and its snapshots. Sources that fail, such
+// as a corrupt database, are logged and skipped.
+func (s *Store) seenVersion(ctx context.Context, tenantID string) int64 {
+	var seen int64
+	if db, err := s.tm.OpenReadOnly(tenantID); err != nil {
+		log.Printf("[backup] tenant %s: open current database: %v", tenantID, err)
+	} else {
+		v, err := sync.PeekVersion(ctx, db)
+		db.Close()
+		if err != nil {
+			log.Printf("[backup] tenant %s: read current version: %v", tenantID, err)
+		}
+		seen = max(seen, v)
+	}
+	if s.hub != nil {
+		if v, err := s.hub.GetVersion(ctx, tenantID); err == nil {
+			seen = max(seen, v)
+		}
+	}
+	snaps, _ := s.List(tenantID)
+	for _, snap := range snaps {
+		if v, err := fileVersion(ctx, filepath.Join(s.dir, tenantID, snap.Name)); err == nil {
+			seen = max(seen, v)
+		}
+	}
+	return seen
+}
+
+// fileVersion reads the sync version of a database file without migrating it.
+func fileVersion(ctx context.Context, path string) (int64, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	return sync.PeekVersion(ctx, db)
+}
+
+// restoreFile replaces the database at dst, which nothing else may have open,
+// with the snapshot at src, and sets its sync versions past seen. The
+// snapshot is copied and migrated next to dst and renamed over it, so a
+// current database that is corrupt or fails to migrate is no obstacle.
+func restoreFile(ctx context.Context, src, dst string, seen int64) (int64, error) {
+	tmp := dst + ".restore"
+	os.Remove(tmp)
+	defer os.Remove(tmp) // left behind only on failure
+	tmpDB, err := sql.Open("sqlite3", tmp+"?_busy_timeout=5000")
+	if err != nil {
+		return 0, err
+	}
+	defer tmpDB.Close()
+	tmpDB.SetMaxOpenConns(1)
+
+	srcDB, err := sql.Open("sqlite3", "file:"+src+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer srcDB.Close()
+	if err := copyDatabase(ctx, tmpDB, srcDB); err != nil {
+		return 0, fmt.Errorf("copy snapshot: %w", err)
+	}
+
+	// The snapshot may predate later migrations
+	if err := migrations.Run(tmpDB); err != nil {
+		return 0, fmt.Errorf("migrate snapshot: %w", err)
+	}
+	restored, err := sync.CurrentVersion(ctx, tmpDB)
+	if err != nil {
+		return 0, err
+	}
+	version := max(seen, restored) + 1
+	if _, err := tmpDB.ExecContext(ctx,
+		`INSERT INTO sync_state (key, value) VALUES ('version', ?1), ('compacted_version', ?1)
+		 ON CONFLICT(key) DO UPDATE SET value = excluded.value`, version,
+	); err != nil {
+		return 0, fmt.Errorf("reset sync versions: %w", err)
+	}
+	if err := tmpDB.Close(); err != nil {
+		return 0, err
+	}
+
+	// The current database's WAL must not be applied to the restored file
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dst + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// copyDatabase copies src over dst page by page with the SQLite backup API.
+func copyDatabase(ctx context.Context, dst, src *sql.DB) error {
+	dc, err := dst.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dc.Close()
+	sc, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer sc.Close()
+
+	return dc.Raw(func(d any) error {
+		return sc.Raw(func(s any) error {
+			b, err := d.(*sqlite3.SQLiteConn).Backup("main", s.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			if _, err := b.Step(-1); err != nil {
+				b.Finish()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+}
+
+// Run snapshots every tenant each interval and prunes snapshots older than
+// retention, until ctx is cancelled. A failing tenant is logged and skipped.
+func (s *Store) Run(ctx context.Context, interval, retention time.Duration) {
+	log.Printf("[backup] snapshotting tenants every %s into %s (retention %s)", interval, s.dir, retention)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.CreateAll(ctx, retention); err != nil {
+				log.Printf("[backup] %v", err)
+			}
+		}
+	}
+}
+
+// CreateAll snapshots and prunes every tenant in the data directory.
+func (s *Store) CreateAll(ctx context.Context, retention time.Duration) error {
+	ids, err := s.tm.Tenants()
+	if err != nil {
+		return fmt.Errorf("list tenants: %w", err)
+	}
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := s.Create(ctx, id); err != nil {
+			log.Printf("[backup] tenant %s: %v", id, err)
+			continue
+		}
+		if n, err := s.Prune(id, retention); err != nil {
+			log.Printf("[backup] tenant %s: prune: %v", id, err)
+		} else if n > 0 {
+			log.Printf("[backup] tenant %s: pruned %d snapshot(s)", id, n)
+		}
+	}
+	return nil
+}
+
+func parseName(name string) (time.Time, bool) {
+	stem, ok := strings.CutSuffix(name, ".db")
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(nameLayout, stem)
+	return t, err == nil
+}
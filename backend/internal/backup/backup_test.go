@@ -0,0 +1,197 @@
+package backup_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ouroboros/backend/internal/auth"
+	"github.com/ouroboros/backend/internal/backup"
+	"github.com/ouroboros/backend/internal/handlers"
+	"github.com/ouroboros/backend/internal/sync"
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+type testEnv struct {
+	t     *testing.T
+	tm    *tenant.Manager
+	hub   *sync.Hub
+	store *backup.Store
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	tm := tenant.NewManager(t.TempDir(), 4)
+	t.Cleanup(tm.CloseAll)
+	hub := sync.NewHub(sync.NewLocalBackend())
+	return &testEnv{t: t, tm: tm, hub: hub, store: backup.NewStore(t.TempDir(), tm, hub)}
+}
+
+// write adds a product at a new version, logged and announced as the
+// handlers do, and returns the version.
+func (e *testEnv) write(name string) int64 {
+	e.t.Helper()
+	ctx := context.Background()
+	db, release, err := e.tm.Acquire("acme")
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	defer release()
+	tx, err := db.Begin()
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	defer tx.Rollback()
+	v, err := sync.NextVersion(ctx, tx)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO products (id, name, price) VALUES (?, ?, 1)", name, name); err != nil {
+		e.t.Fatal(err)
+	}
+	if _, err := tx.Exec(`INSERT INTO sync_log (table_name, entity_id, operation, payload, version)
+		VALUES ('products', ?, 'INSERT', '{}', ?)`, name, v); err != nil {
+		e.t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		e.t.Fatal(err)
+	}
+	e.hub.Notify(ctx, "acme", v)
+	return v
+}
+
+func (e *testEnv) queryInt(query string) int64 {
+	e.t.Helper()
+	db, release, err := e.tm.Acquire("acme")
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	defer release()
+	var n int64
+	if err := db.QueryRow(query).Scan(&n); err != nil {
+		e.t.Fatalf("%s: %v", query, err)
+	}
+	return n
+}
+
+// checkRestored checks that only the snapshot's product is left, that both
+// sync versions sit past old, and that a client at old is sent to the snapshot.
+func (e *testEnv) checkRestored(version, old int64) {
+	e.t.Helper()
+	if n := e.queryInt("SELECT COUNT(*) FROM products"); n != 1 || e.queryInt("SELECT COUNT(*) FROM products WHERE id = 'a'") != 1 {
+		e.t.Errorf("%d products after the restore, want only a", n)
+	}
+	if version <= old {
+		e.t.Errorf("restored at version %d, not past %d", version, old)
+	}
+	for _, key := range []string{"version", "compacted_version"} {
+		if v := e.queryInt("SELECT value FROM sync_state WHERE key = '" + key + "'"); v != version {
+			e.t.Errorf("%s = %d, want %d", key, v, version)
+		}
+	}
+
+	ctx := context.WithValue(context.Background(), auth.TenantKey, "acme")
+	ctx = context.WithValue(ctx, auth.RoleKey, auth.RoleViewer)
+	req := httptest.NewRequest(http.MethodGet, "/api/sync?since="+strconv.FormatInt(old, 10), nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	handlers.GetSync(e.tm).ServeHTTP(rec, req)
+	if rec.Code != http.StatusGone {
+		e.t.Errorf("sync from version %d: status %d, want 410", old, rec.Code)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	e.write("a")
+	snap, err := e.store.Create(ctx, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.write("b")
+	old := e.write("c")
+
+	version, err := e.store.Restore(ctx, "acme", snap.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.checkRestored(version, old)
+
+	if _, err := e.store.Restore(ctx, "acme", "20000101T000000.000Z.db"); err != backup.ErrNotFound {
+		t.Errorf("restore of a missing snapshot: err = %v", err)
+	}
+}
+
+// TestRestoreCorrupt restores over a database that can no longer be opened,
+// taking the version clients have seen from the hub instead.
+func TestRestoreCorrupt(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	e.write("a")
+	snap, err := e.store.Create(ctx, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.write("b")
+	old := e.write("c")
+
+	e.tm.CloseAll()
+	path, _ := e.tm.Path("acme")
+	if err := os.WriteFile(path, []byte("not a database, but long enough to look like one"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path + "-wal")
+	os.Remove(path + "-shm")
+	if _, _, err := e.tm.Acquire("acme"); err == nil {
+		t.Fatal("a corrupt database opened")
+	}
+
+	version, err := e.store.Restore(ctx, "acme", snap.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.checkRestored(version, old)
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	store := backup.NewStore(dir, tenant.NewManager(t.TempDir(), 1), nil)
+	now := time.Now().UTC()
+	ages := []time.Duration{72 * time.Hour, 48 * time.Hour, 24 * time.Hour}
+	if err := os.MkdirAll(filepath.Join(dir, "acme"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, age := range ages {
+		name := now.Add(-age).Format("20060102T150405.000Z") + ".db"
+		if err := os.WriteFile(filepath.Join(dir, "acme", name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		retention time.Duration
+		deleted   int
+		left      int
+	}{
+		{100 * time.Hour, 0, 3},
+		{36 * time.Hour, 2, 1},
+		{time.Hour, 0, 1}, // the newest is kept even when too old
+	}
+	for _, tt := range tests {
+		n, err := store.Prune("acme", tt.retention)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snaps, _ := store.List("acme")
+		if n != tt.deleted || len(snaps) != tt.left {
+			t.Errorf("retention %s: deleted %d, %d left; want %d, %d", tt.retention, n, len(snaps), tt.deleted, tt.left)
+		}
+		if len(snaps) > 0 && now.Sub(snaps[0].CreatedAt).Round(time.Hour) != ages[len(ages)-1] {
+			t.Errorf("retention %s: kept %s", tt.retention, snaps[0].Name)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"math/rand/v2"
 	"net/http"
 	"strings"
 	"time"
@@ -12,9 +15,10 @@ import (
 )
 
 type registerRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
-	TenantID string `json:"tenant_id"`
+	Email    string    `json:"email"`
+	Password string    `json:"password"`
+	TenantID string    `json:"tenant_id"`
+	Role     auth.Role `json:"role"` // invites only, member by default; signup makes an owner
 }
 
 type loginRequest struct {
@@ -29,7 +33,8 @@ type authResponse struct {
 }
 
 // Register handles POST /api/auth/register.
-// Creates a user in system.db with bcrypt-hashed password.
+// Signs up a new tenant with the user (bcrypt-hashed password in system.db)
+// as its owner. Existing tenants are only joined by invitation.
 func Register(a *auth.Auth, sdb *auth.SystemDB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req registerRequest
@@ -46,23 +51,38 @@ func Register(a *auth.Auth, sdb *auth.SystemDB) http.HandlerFunc {
 			return
 		}
 
-		// If no tenant_id provided, generate one from the email prefix
-		tenantID := req.TenantID
-		if tenantID == "" {
-			tenantID = strings.Split(req.Email, "@")[0]
+		var user *auth.User
+		var err error
+		if req.TenantID != "" {
+			user, err = sdb.Register(req.Email, req.Password, req.TenantID)
+		} else {
+			// No tenant_id provided: generate one from the email prefix, made
+			// unique with a random suffix if taken
+			base := tenantIDFromEmail(req.Email)
+			tenantID := base
+			for range 5 {
+				user, err = sdb.Register(req.Email, req.Password, tenantID)
+				if !errors.Is(err, auth.ErrTenantExists) {
+					break
+				}
+				tenantID = fmt.Sprintf("%s-%04x", base[:min(len(base), 59)], rand.IntN(0x10000))
+			}
 		}
-
-		user, err := sdb.Register(req.Email, req.Password, tenantID)
 		if err != nil {
-			if errors.Is(err, auth.ErrEmailTaken) {
+			switch {
+			case errors.Is(err, auth.ErrEmailTaken):
 				http.Error(w, `{"error":"email already registered"}`, http.StatusConflict)
-				return
+			case errors.Is(err, auth.ErrTenantExists):
+				http.Error(w, `{"error":"tenant already exists; ask one of its owners for an invite"}`, http.StatusConflict)
+			case errors.Is(err, tenant.ErrInvalidID):
+				http.Error(w, `{"error":"invalid tenant id"}`, http.StatusBadRequest)
+			default:
+				http.Error(w, `{"error":"registration failed"}`, http.StatusInternalServerError)
 			}
-			http.Error(w, `{"error":"registration failed"}`, http.StatusInternalServerError)
 			return
 		}
 
-		token, err := a.Issue(user.TenantID, user.ID, 24*time.Hour)
+		token, err := a.Issue(user.TenantID, user.ID, user.Role, 24*time.Hour)
 		if err != nil {
 			http.Error(w, `{"error":"token generation failed"}`, http.StatusInternalServerError)
 			return
@@ -96,11 +116,14 @@ func Login(a *auth.Auth, sdb *auth.SystemDB) http.HandlerFunc {
 				http.Error(w, `{"error":"invalid email or password"}`, http.StatusUnauthorized)
 				return
 			}
+			if tenantRefused(w, err) {
+				return
+			}
 			http.Error(w, `{"error":"login failed"}`, http.StatusInternalServerError)
 			return
 		}
 
-		token, err := a.Issue(user.TenantID, user.ID, 24*time.Hour)
+		token, err := a.Issue(user.TenantID, user.ID, user.Role, 24*time.Hour)
 		if err != nil {
 			http.Error(w, `{"error":"token generation failed"}`, http.StatusInternalServerError)
 			return
@@ -114,7 +137,43 @@ func Login(a *auth.Auth, sdb *auth.SystemDB) http.HandlerFunc {
 	}
 }
 
-// InviteUser handles POST /api/users — creates a user in the caller's tenant.
+// tenantIDFromEmail derives a tenant ID from the local part of an email,
+// replacing what tenant.ValidID does not accept.
+func tenantIDFromEmail(email string) string {
+	local, _, _ := strings.Cut(email, "@")
+	id := strings.Map(func(c rune) rune {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '.', c == '_', c == '-':
+			return c
+		}
+		return '-'
+	}, local)
+	id = strings.TrimLeft(id, ".-_")
+	if len(id) > 64 {
+		id = id[:64]
+	}
+	if id == "" {
+		id = "tenant"
+	}
+	return id
+}
+
+// tenantRefused replies 403 if err says the user's tenant is suspended or
+// deleted.
+func tenantRefused(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, auth.ErrTenantSuspended):
+		http.Error(w, `{"error":"tenant suspended"}`, http.StatusForbidden)
+	case errors.Is(err, auth.ErrTenantDeleted):
+		http.Error(w, `{"error":"tenant deleted"}`, http.StatusForbidden)
+	default:
+		return false
+	}
+	return true
+}
+
+// InviteUser handles POST /api/users — creates a user in the caller's tenant
+// with the requested role (member by default); only owners may invite owners.
 // Also notifies via SSE so other sessions see the new member in real-time.
 func InviteUser(sdb *auth.SystemDB, tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -132,8 +191,23 @@ func InviteUser(sdb *auth.SystemDB, tm *tenant.Manager, hub *sync.Hub) http.Hand
 			http.Error(w, `{"error":"password must be at least 6 characters"}`, http.StatusBadRequest)
 			return
 		}
+		if req.Role == "" {
+			req.Role = auth.RoleMember
+		}
+		if !req.Role.Valid() {
+			http.Error(w, `{"error":"invalid role"}`, http.StatusBadRequest)
+			return
+		}
+		if req.Role == auth.RoleOwner && auth.RoleFromCtx(r.Context()) != auth.RoleOwner {
+			http.Error(w, `{"error":"only owners can manage owners"}`, http.StatusForbidden)
+			return
+		}
 
-		user, err := sdb.Register(req.Email, req.Password, tenantID)
+		if err := sdb.CheckTenant(tenantID); err != nil {
+			dbUnavailable(w, err)
+			return
+		}
+		user, err := sdb.Invite(req.Email, req.Password, tenantID, req.Role)
 		if err != nil {
 			if errors.Is(err, auth.ErrEmailTaken) {
 				http.Error(w, `{"error":"email already registered"}`, http.StatusConflict)
@@ -143,30 +217,34 @@ func InviteUser(sdb *auth.SystemDB, tm *tenant.Manager, hub *sync.Hub) http.Hand
 			return
 		}
 
-		// Notify other sessions via SSE
-		ctx := r.Context()
-		if db, dbErr := tm.DB(tenantID); dbErr == nil {
-			if tx, txErr := db.BeginTx(ctx, nil); txErr == nil {
-				newVersion, vErr := hub.NextVersion(ctx, tenantID)
-				if vErr == nil {
-					payload := `{"id":"` + user.ID + `","email":"` + user.Email + `","tenant_id":"` + user.TenantID + `"}`
-					if _, err := tx.ExecContext(ctx,
-						"INSERT INTO sync_log (table_name, entity_id, operation, payload, version) VALUES (?, ?, 'INSERT', ?, ?)",
-						"users", user.ID, payload, newVersion,
-					); err == nil {
-						if tx.Commit() == nil {
-							hub.Notify(ctx, tenantID, newVersion)
-						}
-					} else {
-						tx.Rollback()
+		notifyUserAdded(r.Context(), tm, hub, user)
+		writeJSON(w, http.StatusCreated, user)
+	}
+}
+
+// notifyUserAdded logs a new user in the tenant's sync_log, so other sessions
+// see the new member in real-time. Failures only delay that until reload.
+func notifyUserAdded(ctx context.Context, tm *tenant.Manager, hub *sync.Hub, user *auth.User) {
+	if db, release, dbErr := tm.Acquire(user.TenantID); dbErr == nil {
+		defer release()
+		if tx, txErr := db.BeginTx(ctx, nil); txErr == nil {
+			newVersion, vErr := sync.NextVersion(ctx, tx)
+			if vErr == nil {
+				payload := `{"id":"` + user.ID + `","email":"` + user.Email + `","tenant_id":"` + user.TenantID + `","role":"` + string(user.Role) + `"}`
+				if _, err := tx.ExecContext(ctx,
+					"INSERT INTO sync_log (table_name, entity_id, operation, payload, version) VALUES (?, ?, 'INSERT', ?, ?)",
+					"users", user.ID, payload, newVersion,
+				); err == nil {
+					if tx.Commit() == nil {
+						hub.Notify(ctx, user.TenantID, newVersion)
 					}
 				} else {
 					tx.Rollback()
 				}
+			} else {
+				tx.Rollback()
 			}
 		}
-
-		writeJSON(w, http.StatusCreated, user)
 	}
 }
 
@@ -174,6 +252,10 @@ func InviteUser(sdb *auth.SystemDB, tm *tenant.Manager, hub *sync.Hub) http.Hand
 func ListTenantUsers(sdb *auth.SystemDB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
+		if err := sdb.CheckTenant(tenantID); err != nil {
+			dbUnavailable(w, err)
+			return
+		}
 		users, err := sdb.ListByTenant(tenantID)
 		if err != nil {
 			http.Error(w, `{"error":"query failed"}`, http.StatusInternalServerError)
@@ -182,3 +264,51 @@ func ListTenantUsers(sdb *auth.SystemDB) http.HandlerFunc {
 		writeJSON(w, http.StatusOK, users)
 	}
 }
+
+// UpdateUserRole handles PUT /api/users/{id}/role — changes the role of a user
+// in the caller's tenant. Only owners may make or unmake owners, and the last
+// owner cannot step down. The user gets the new role at their next login.
+func UpdateUserRole(sdb *auth.SystemDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenantID := auth.TenantFromCtx(r.Context())
+		userID := r.PathValue("id")
+		var req struct {
+			Role auth.Role `json:"role"`
+		}
+		if err := decodeJSON(r, &req); err != nil || !req.Role.Valid() {
+			http.Error(w, `{"error":"valid role required"}`, http.StatusBadRequest)
+			return
+		}
+		if err := sdb.CheckTenant(tenantID); err != nil {
+			dbUnavailable(w, err)
+			return
+		}
+
+		current, err := sdb.UserRole(tenantID, userID)
+		if errors.Is(err, auth.ErrUserNotFound) {
+			http.Error(w, `{"error":"user not found"}`, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, `{"error":"query failed"}`, http.StatusInternalServerError)
+			return
+		}
+		if (current == auth.RoleOwner || req.Role == auth.RoleOwner) && auth.RoleFromCtx(r.Context()) != auth.RoleOwner {
+			http.Error(w, `{"error":"only owners can manage owners"}`, http.StatusForbidden)
+			return
+		}
+
+		if err := sdb.SetUserRole(tenantID, userID, req.Role); err != nil {
+			switch {
+			case errors.Is(err, auth.ErrUserNotFound):
+				http.Error(w, `{"error":"user not found"}`, http.StatusNotFound)
+			case errors.Is(err, auth.ErrLastOwner):
+				http.Error(w, `{"error":"a tenant needs an owner"}`, http.StatusConflict)
+			default:
+				http.Error(w, `{"error":"update failed"}`, http.StatusInternalServerError)
+			}
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"id": userID, "role": req.Role})
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ouroboros/backend/internal/backup"
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+// restoreDrainTimeout bounds how long a restore waits for requests and
+// streams to release the tenant's database.
+const restoreDrainTimeout = 30 * time.Second
+
+// CreateBackup handles POST /admin/tenants/{id}/backups
+// Snapshots the tenant's database now and returns the snapshot.
+func CreateBackup(bs *backup.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap, err := bs.Create(r.Context(), r.PathValue("id"))
+		if err != nil {
+			backupError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, snap)
+	}
+}
+
+// ListBackups handles GET /admin/tenants/{id}/backups
+// Returns the tenant's snapshots, newest first.
+func ListBackups(bs *backup.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snaps, err := bs.List(r.PathValue("id"))
+		if err != nil {
+			backupError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, snaps)
+	}
+}
+
+// RestoreBackup handles POST /admin/tenants/{id}/backups/{name}/restore
+// Replaces the tenant's database with the snapshot. The tenant's streams are
+// closed and its requests get 503 meanwhile; afterwards every client resyncs
+// from a fresh snapshot.
+func RestoreBackup(bs *backup.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), restoreDrainTimeout)
+		defer cancel()
+		version, err := bs.Restore(ctx, r.PathValue("id"), r.PathValue("name"))
+		if err != nil {
+			backupError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"tenant_id": r.PathValue("id"),
+			"snapshot":  r.PathValue("name"),
+			"version":   version,
+		})
+	}
+}
+
+func backupError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, backup.ErrUnknownTenant), errors.Is(err, tenant.ErrUnknownTenant):
+		http.Error(w, `{"error":"unknown tenant"}`, http.StatusNotFound)
+	case errors.Is(err, backup.ErrNotFound):
+		http.Error(w, `{"error":"snapshot not found"}`, http.StatusNotFound)
+	case errors.Is(err, tenant.ErrLocked):
+		http.Error(w, `{"error":"tenant is locked by another operation"}`, http.StatusConflict)
+	case errors.Is(err, tenant.ErrSuspended):
+		http.Error(w, `{"error":"tenant suspended"}`, http.StatusConflict)
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, `{"error":"tenant still in use"}`, http.StatusServiceUnavailable)
+	default:
+		http.Error(w, `{"error":"backup failed"}`, http.StatusInternalServerError)
+	}
+}
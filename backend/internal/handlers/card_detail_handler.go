@@ -15,32 +15,36 @@ import (
 // --- DTOs ---
 
 type tagDTO struct {
-	ID     string `json:"id"`
-	CardID string `json:"card_id"`
-	Name   string `json:"name"`
+	ID         string `json:"id"`
+	CardID     string `json:"card_id"`
+	Name       string `json:"name"`
+	RowVersion int64  `json:"row_version"`
 }
 
 type assigneeDTO struct {
-	ID        string `json:"id"`
-	CardID    string `json:"card_id"`
-	UserID    string `json:"user_id"`
-	UserEmail string `json:"user_email"`
+	ID         string `json:"id"`
+	CardID     string `json:"card_id"`
+	UserID     string `json:"user_id"`
+	UserEmail  string `json:"user_email"`
+	RowVersion int64  `json:"row_version"`
 }
 
 type approverDTO struct {
-	ID        string  `json:"id"`
-	CardID    string  `json:"card_id"`
-	UserID    string  `json:"user_id"`
-	UserEmail string  `json:"user_email"`
-	Status    string  `json:"status"`
-	DecidedAt *string `json:"decided_at"`
+	ID         string  `json:"id"`
+	CardID     string  `json:"card_id"`
+	UserID     string  `json:"user_id"`
+	UserEmail  string  `json:"user_email"`
+	Status     string  `json:"status"`
+	DecidedAt  *string `json:"decided_at"`
+	RowVersion int64   `json:"row_version"`
 }
 
 type sessionDTO struct {
-	ID       string `json:"id"`
-	CardID   string `json:"card_id"`
-	Name     string `json:"name"`
-	Position int    `json:"position"`
+	ID         string `json:"id"`
+	CardID     string `json:"card_id"`
+	Name       string `json:"name"`
+	Position   int    `json:"position"`
+	RowVersion int64  `json:"row_version"`
 }
 
 // ──────────────────────────── Tags ────────────────────────────
@@ -49,11 +53,12 @@ func AddTag(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
 		cardID := r.PathValue("cardId")
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.Acquire(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		var req struct {
 			Name string `json:"name"`
@@ -81,12 +86,13 @@ func AddTag(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			return
 		}
 
-		newVersion, err := hub.NextVersion(ctx, tenantID)
+		newVersion, err := sync.NextVersion(ctx, tx)
 		if err != nil {
-			http.Error(w, `{"error":"redis error"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error":"version allocation failed"}`, http.StatusInternalServerError)
 			return
 		}
 
+		t.RowVersion = newVersion
 		payload, _ := json.Marshal(t)
 		if _, err := tx.ExecContext(ctx,
 			"INSERT INTO sync_log (table_name, entity_id, operation, payload, version) VALUES (?, ?, 'INSERT', ?, ?)",
@@ -110,11 +116,12 @@ func RemoveTag(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
 		tagID := r.PathValue("tagId")
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.Acquire(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		ctx := r.Context()
 		tx, err := db.BeginTx(ctx, nil)
@@ -134,9 +141,9 @@ func RemoveTag(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			return
 		}
 
-		newVersion, err := hub.NextVersion(ctx, tenantID)
+		newVersion, err := sync.NextVersion(ctx, tx)
 		if err != nil {
-			http.Error(w, `{"error":"redis error"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error":"version allocation failed"}`, http.StatusInternalServerError)
 			return
 		}
 
@@ -164,11 +171,12 @@ func AssignUser(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
 		cardID := r.PathValue("cardId")
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.Acquire(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		var req struct {
 			UserID    string `json:"user_id"`
@@ -197,12 +205,13 @@ func AssignUser(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			return
 		}
 
-		newVersion, err := hub.NextVersion(ctx, tenantID)
+		newVersion, err := sync.NextVersion(ctx, tx)
 		if err != nil {
-			http.Error(w, `{"error":"redis error"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error":"version allocation failed"}`, http.StatusInternalServerError)
 			return
 		}
 
+		a.RowVersion = newVersion
 		payload, _ := json.Marshal(a)
 		if _, err := tx.ExecContext(ctx,
 			"INSERT INTO sync_log (table_name, entity_id, operation, payload, version) VALUES (?, ?, 'INSERT', ?, ?)",
@@ -226,11 +235,12 @@ func UnassignUser(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
 		assigneeID := r.PathValue("assigneeId")
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.Acquire(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		ctx := r.Context()
 		tx, err := db.BeginTx(ctx, nil)
@@ -250,9 +260,9 @@ func UnassignUser(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			return
 		}
 
-		newVersion, err := hub.NextVersion(ctx, tenantID)
+		newVersion, err := sync.NextVersion(ctx, tx)
 		if err != nil {
-			http.Error(w, `{"error":"redis error"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error":"version allocation failed"}`, http.StatusInternalServerError)
 			return
 		}
 
@@ -280,11 +290,12 @@ func AddApprover(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
 		cardID := r.PathValue("cardId")
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.Acquire(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		var req struct {
 			UserID    string `json:"user_id"`
@@ -303,6 +314,12 @@ func AddApprover(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 		}
 		defer tx.Rollback()
 
+		before, err := selectCard(ctx, tx, cardID)
+		if err != nil {
+			http.Error(w, `{"error":"card not found"}`, http.StatusNotFound)
+			return
+		}
+
 		var a approverDTO
 		err = tx.QueryRowContext(ctx,
 			`INSERT INTO card_approvers (card_id, user_id, user_email)
@@ -316,14 +333,18 @@ func AddApprover(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 		}
 
 		// When approvers are added, card goes to pending
-		tx.ExecContext(ctx, "UPDATE kanban_cards SET approval_status = 'pending' WHERE id = ?", cardID)
+		if _, err := tx.ExecContext(ctx, "UPDATE kanban_cards SET approval_status = 'pending' WHERE id = ?", cardID); err != nil {
+			http.Error(w, `{"error":"update failed"}`, http.StatusInternalServerError)
+			return
+		}
 
-		newVersion, err := hub.NextVersion(ctx, tenantID)
+		newVersion, err := sync.NextVersion(ctx, tx)
 		if err != nil {
-			http.Error(w, `{"error":"redis error"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error":"version allocation failed"}`, http.StatusInternalServerError)
 			return
 		}
 
+		a.RowVersion = newVersion
 		payload, _ := json.Marshal(a)
 		if _, err := tx.ExecContext(ctx,
 			"INSERT INTO sync_log (table_name, entity_id, operation, payload, version) VALUES (?, ?, 'INSERT', ?, ?)",
@@ -333,7 +354,7 @@ func AddApprover(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			return
 		}
 
-		if err := syncCardUpdate(tx, ctx, cardID, newVersion); err != nil {
+		if err := syncCardUpdate(tx, ctx, before, newVersion); err != nil {
 			http.Error(w, `{"error":"sync_log insert failed"}`, http.StatusInternalServerError)
 			return
 		}
@@ -353,11 +374,12 @@ func RemoveApprover(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 		tenantID := auth.TenantFromCtx(r.Context())
 		approverID := r.PathValue("approverId")
 		cardID := r.PathValue("cardId")
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.Acquire(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		ctx := r.Context()
 		tx, err := db.BeginTx(ctx, nil)
@@ -367,6 +389,12 @@ func RemoveApprover(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 		}
 		defer tx.Rollback()
 
+		before, err := selectCard(ctx, tx, cardID)
+		if err != nil {
+			http.Error(w, `{"error":"card not found"}`, http.StatusNotFound)
+			return
+		}
+
 		result, err := tx.ExecContext(ctx, "DELETE FROM card_approvers WHERE id = ?", approverID)
 		if err != nil {
 			http.Error(w, `{"error":"delete failed"}`, http.StatusInternalServerError)
@@ -377,11 +405,14 @@ func RemoveApprover(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			return
 		}
 
-		recalcApprovalStatus(tx, ctx, cardID)
+		if err := recalcApprovalStatus(tx, ctx, cardID); err != nil {
+			http.Error(w, `{"error":"update failed"}`, http.StatusInternalServerError)
+			return
+		}
 
-		newVersion, err := hub.NextVersion(ctx, tenantID)
+		newVersion, err := sync.NextVersion(ctx, tx)
 		if err != nil {
-			http.Error(w, `{"error":"redis error"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error":"version allocation failed"}`, http.StatusInternalServerError)
 			return
 		}
 
@@ -393,7 +424,7 @@ func RemoveApprover(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			return
 		}
 
-		if err := syncCardUpdate(tx, ctx, cardID, newVersion); err != nil {
+		if err := syncCardUpdate(tx, ctx, before, newVersion); err != nil {
 			http.Error(w, `{"error":"sync_log insert failed"}`, http.StatusInternalServerError)
 			return
 		}
@@ -414,11 +445,12 @@ func DecideApproval(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 		userID := auth.UserFromCtx(r.Context())
 		cardID := r.PathValue("cardId")
 		approverID := r.PathValue("approverId")
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.Acquire(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		var req struct {
 			Status string `json:"status"`
@@ -451,13 +483,25 @@ func DecideApproval(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			return
 		}
 
+		before, err := selectCard(ctx, tx, cardID)
+		if err != nil {
+			http.Error(w, `{"error":"card not found"}`, http.StatusNotFound)
+			return
+		}
+
 		decidedAt := time.Now().UTC().Format(time.RFC3339)
-		tx.ExecContext(ctx,
+		if _, err := tx.ExecContext(ctx,
 			"UPDATE card_approvers SET status = ?, decided_at = ? WHERE id = ?",
 			req.Status, decidedAt, approverID,
-		)
+		); err != nil {
+			http.Error(w, `{"error":"update failed"}`, http.StatusInternalServerError)
+			return
+		}
 
-		recalcApprovalStatus(tx, ctx, cardID)
+		if err := recalcApprovalStatus(tx, ctx, cardID); err != nil {
+			http.Error(w, `{"error":"update failed"}`, http.StatusInternalServerError)
+			return
+		}
 
 		// Read updated approver
 		var a approverDTO
@@ -470,12 +514,13 @@ func DecideApproval(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			return
 		}
 
-		newVersion, err := hub.NextVersion(ctx, tenantID)
+		newVersion, err := sync.NextVersion(ctx, tx)
 		if err != nil {
-			http.Error(w, `{"error":"redis error"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error":"version allocation failed"}`, http.StatusInternalServerError)
 			return
 		}
 
+		a.RowVersion = newVersion
 		payload, _ := json.Marshal(a)
 		if _, err := tx.ExecContext(ctx,
 			"INSERT INTO sync_log (table_name, entity_id, operation, payload, version) VALUES (?, ?, 'UPDATE', ?, ?)",
@@ -485,7 +530,7 @@ func DecideApproval(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			return
 		}
 
-		if err := syncCardUpdate(tx, ctx, cardID, newVersion); err != nil {
+		if err := syncCardUpdate(tx, ctx, before, newVersion); err != nil {
 			http.Error(w, `{"error":"sync_log insert failed"}`, http.StatusInternalServerError)
 			return
 		}
@@ -506,11 +551,12 @@ func CreateSession(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
 		cardID := r.PathValue("cardId")
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.Acquire(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		var req struct {
 			Name string `json:"name"`
@@ -529,7 +575,10 @@ func CreateSession(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 		defer tx.Rollback()
 
 		var pos int
-		tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM card_sessions WHERE card_id = ?", cardID).Scan(&pos)
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM card_sessions WHERE card_id = ?", cardID).Scan(&pos); err != nil {
+			http.Error(w, `{"error":"query failed"}`, http.StatusInternalServerError)
+			return
+		}
 
 		var s sessionDTO
 		err = tx.QueryRowContext(ctx,
@@ -541,12 +590,13 @@ func CreateSession(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			return
 		}
 
-		newVersion, err := hub.NextVersion(ctx, tenantID)
+		newVersion, err := sync.NextVersion(ctx, tx)
 		if err != nil {
-			http.Error(w, `{"error":"redis error"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error":"version allocation failed"}`, http.StatusInternalServerError)
 			return
 		}
 
+		s.RowVersion = newVersion
 		payload, _ := json.Marshal(s)
 		if _, err := tx.ExecContext(ctx,
 			"INSERT INTO sync_log (table_name, entity_id, operation, payload, version) VALUES (?, ?, 'INSERT', ?, ?)",
@@ -570,11 +620,12 @@ func DeleteSession(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
 		sessionID := r.PathValue("sessionId")
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.Acquire(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		ctx := r.Context()
 		tx, err := db.BeginTx(ctx, nil)
@@ -594,9 +645,9 @@ func DeleteSession(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			return
 		}
 
-		newVersion, err := hub.NextVersion(ctx, tenantID)
+		newVersion, err := sync.NextVersion(ctx, tx)
 		if err != nil {
-			http.Error(w, `{"error":"redis error"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error":"version allocation failed"}`, http.StatusInternalServerError)
 			return
 		}
 
@@ -622,15 +673,17 @@ func DeleteSession(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 
 // recalcApprovalStatus checks all approvers and updates the card's approval_status.
 // any rejected → rejected, all approved → approved, otherwise pending.
-func recalcApprovalStatus(tx *sql.Tx, ctx context.Context, cardID string) {
+func recalcApprovalStatus(tx *sql.Tx, ctx context.Context, cardID string) error {
 	var total, approved, rejected int
-	tx.QueryRowContext(ctx,
+	if err := tx.QueryRowContext(ctx,
 		`SELECT COUNT(*),
 		        COALESCE(SUM(CASE WHEN status='approved' THEN 1 ELSE 0 END), 0),
 		        COALESCE(SUM(CASE WHEN status='rejected' THEN 1 ELSE 0 END), 0)
 		 FROM card_approvers WHERE card_id = ?`,
 		cardID,
-	).Scan(&total, &approved, &rejected)
+	).Scan(&total, &approved, &rejected); err != nil {
+		return err
+	}
 
 	var newStatus string
 	switch {
@@ -643,26 +696,17 @@ func recalcApprovalStatus(tx *sql.Tx, ctx context.Context, cardID string) {
 	default:
 		newStatus = "pending"
 	}
-	tx.ExecContext(ctx, "UPDATE kanban_cards SET approval_status = ? WHERE id = ?", newStatus, cardID)
+	_, err := tx.ExecContext(ctx, "UPDATE kanban_cards SET approval_status = ? WHERE id = ?", newStatus, cardID)
+	return err
 }
 
-// syncCardUpdate reads the full card and writes a sync_log entry for it.
-func syncCardUpdate(tx *sql.Tx, ctx context.Context, cardID string, version int64) error {
-	var c card
-	err := tx.QueryRowContext(ctx,
-		`SELECT id, project_id, column_name, title, position, approval_status,
-		        assigned_approver_id, due_date, client, priority, notes
-		 FROM kanban_cards WHERE id = ?`, cardID,
-	).Scan(&c.ID, &c.ProjectID, &c.ColumnName, &c.Title, &c.Position, &c.ApprovalStatus,
-		&c.AssignedApproverID, &c.DueDate, &c.Client, &c.Priority, &c.Notes)
+// syncCardUpdate re-reads a card that side effects may have changed and logs
+// the columns that differ from before as a PATCH entry.
+func syncCardUpdate(tx *sql.Tx, ctx context.Context, before card, version int64) error {
+	c, err := selectCard(ctx, tx, before.ID)
 	if err != nil {
 		return err
 	}
-
-	payload, _ := json.Marshal(c)
-	_, err = tx.ExecContext(ctx,
-		"INSERT INTO sync_log (table_name, entity_id, operation, payload, version) VALUES (?, ?, 'UPDATE', ?, ?)",
-		"kanban_cards", c.ID, string(payload), version,
-	)
+	_, err = logPatch(tx, ctx, "kanban_cards", c.ID, before, c, version)
 	return err
 }
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ouroboros/backend/internal/auth"
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+// checksumColumns lists, per table, the columns the sync worker stores in its
+// replica (see the worker's local schema and CHECKSUM_COLUMNS). Both sides
+// hash exactly these, in this order.
+var checksumColumns = map[string][]string{
+	"projects":            {"id", "name"},
+	"kanban_columns":      {"id", "project_id", "name", "color", "position", "row_version"},
+	"kanban_cards":        {"id", "project_id", "column_name", "title", "position", "approval_status", "assigned_approver_id", "due_date", "client", "priority", "notes", "row_version"},
+	"card_tags":           {"id", "card_id", "name"},
+	"card_assigned_users": {"id", "card_id", "user_id", "user_email"},
+	"card_approvers":      {"id", "card_id", "user_id", "user_email", "status", "decided_at"},
+	"card_sessions":       {"id", "card_id", "name", "position"},
+	"products":            {"id", "name", "price"},
+	"os_orders":           {"uuid", "short_id", "card_id", "project_id", "total"},
+	"os_items":            {"id", "order_id", "product_id", "qty"},
+}
+
+// tableChecksum summarises one table's rows.
+type tableChecksum struct {
+	Count int64  `json:"count"`
+	Hash  string `json:"hash"`
+}
+
+type checksumResponse struct {
+	Version int64                    `json:"version"`
+	Tables  map[string]tableChecksum `json:"tables"`
+}
+
+// GetChecksum handles GET /api/sync/checksum?version={v}&tables={t1,t2}&project_id={id}
+// Returns the row count and an order-independent content hash of every synced
+// table (or those selected by the filter, as in GET /api/sync/snapshot), read
+// in one transaction at the returned version. A replica at the same version
+// that hashes to something else has drifted, and can reload just that table.
+//
+// A row hashes as SHA-256 over its checksumColumns values joined by 0x1f:
+// NULL as the empty string (the worker stores some empty strings as NULL),
+// numbers as JavaScript's String() renders them, text as UTF-8. The table
+// hash is the sum, mod 2^64, of the first 8 bytes (big-endian) of every row
+// hash, as 16 hex digits.
+//
+// With version, responds 409 with the current version when the server is
+// elsewhere; the client should catch up first.
+func GetChecksum(tm *tenant.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenantID := auth.TenantFromCtx(r.Context())
+		db, release, err := tm.AcquireRead(tenantID)
+		if err != nil {
+			dbUnavailable(w, err)
+			return
+		}
+		defer release()
+
+		filter, err := parseSyncFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, `{"error":"invalid tables"}`, http.StatusBadRequest)
+			return
+		}
+		if _, ok := negotiate(w, r, db, false); !ok {
+			return
+		}
+
+		ctx := r.Context()
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			http.Error(w, `{"error":"tx begin failed"}`, http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		resp := checksumResponse{Tables: map[string]tableChecksum{}}
+		if resp.Version, err = snapshotVersion(ctx, tx); err != nil {
+			http.Error(w, `{"error":"query failed"}`, http.StatusInternalServerError)
+			return
+		}
+		if v := r.URL.Query().Get("version"); v != "" {
+			want, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				http.Error(w, `{"error":"invalid version"}`, http.StatusBadRequest)
+				return
+			}
+			if want != resp.Version {
+				writeJSON(w, http.StatusConflict, map[string]any{
+					"error":   "version mismatch",
+					"version": resp.Version,
+				})
+				return
+			}
+		}
+
+		for _, t := range snapshotTables {
+			if !filter.includes(t.name) {
+				continue
+			}
+			where, args := t.scope(filter)
+			rows, err := tx.QueryContext(ctx,
+				"SELECT "+strings.Join(checksumColumns[t.name], ", ")+" FROM "+t.name+where, args...)
+			if err != nil {
+				http.Error(w, `{"error":"query failed"}`, http.StatusInternalServerError)
+				return
+			}
+			sum, err := checksumRows(rows)
+			rows.Close()
+			if err != nil {
+				http.Error(w, `{"error":"scan failed"}`, http.StatusInternalServerError)
+				return
+			}
+			resp.Tables[t.name] = sum
+		}
+
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+func checksumRows(rows *sql.Rows) (tableChecksum, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return tableChecksum{}, err
+	}
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+
+	var count int64
+	var total uint64
+	var buf []byte
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return tableChecksum{}, err
+		}
+		buf = buf[:0]
+		for i, v := range vals {
+			if i > 0 {
+				buf = append(buf, 0x1f)
+			}
+			buf = appendChecksumValue(buf, v)
+		}
+		h := sha256.Sum256(buf)
+		total += binary.BigEndian.Uint64(h[:8])
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return tableChecksum{}, err
+	}
+	return tableChecksum{Count: count, Hash: fmt.Sprintf("%016x", total)}, nil
+}
+
+// appendChecksumValue renders a column value the way the worker does.
+func appendChecksumValue(buf []byte, v any) []byte {
+	switch v := v.(type) {
+	case nil:
+		return buf
+	case int64:
+		return strconv.AppendInt(buf, v, 10)
+	case float64:
+		return appendJSNumber(buf, v)
+	case []byte:
+		return append(buf, v...)
+	case string:
+		return append(buf, v...)
+	default:
+		return fmt.Append(buf, v)
+	}
+}
+
+// appendJSNumber formats f as JavaScript's String(f) does: the shortest
+// decimal that round-trips, in exponent form below 1e-6 and from 1e21 on.
+func appendJSNumber(buf []byte, f float64) []byte {
+	switch abs := math.Abs(f); {
+	case f == 0: // -0 too
+		return append(buf, '0')
+	case math.IsInf(f, 0):
+		if f < 0 {
+			buf = append(buf, '-')
+		}
+		return append(buf, "Infinity"...)
+	case abs < 1e-6 || abs >= 1e21:
+		// Go writes 1e-07 and 1e+21; JavaScript 1e-7 and 1e+21
+		s := strconv.FormatFloat(f, 'e', -1, 64)
+		mant, exp, _ := strings.Cut(s, "e")
+		buf = append(buf, mant...)
+		buf = append(buf, 'e', exp[0])
+		return append(buf, strings.TrimLeft(exp[1:], "0")...)
+	default:
+		return strconv.AppendFloat(buf, f, 'f', -1, 64)
+	}
+}
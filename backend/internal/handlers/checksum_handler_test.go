@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/ouroboros/backend/internal/auth"
+)
+
+// TestChecksumRows checks the row encoding against hashes computed by the
+// sync worker's tableChecksum for the same rows.
+func TestChecksumRows(t *testing.T) {
+	e := newTestEnv(t)
+	tests := []struct {
+		name  string
+		query string
+		want  tableChecksum
+	}{
+		{"null", "SELECT NULL", tableChecksum{1, "e3b0c44298fc1c14"}},
+		{"integer", "SELECT 42", tableChecksum{1, "73475cb40a568e8d"}},
+		{"float", "SELECT 0.1 + 0.2", tableChecksum{1, "06bad31060c1212a"}},
+		{"utf-8 text", "SELECT 'héllo 世界 🚀'", tableChecksum{1, "f8f7614aca7d8822"}},
+		{"large float", "SELECT 1e21", tableChecksum{1, "241c4643fa70b1dc"}},
+		{"small float", "SELECT 1.5e-7", tableChecksum{1, "bbe54b73760f9025"}},
+		{"row", "SELECT 'p1', NULL, 3, 2.5, 'Ünïcode'", tableChecksum{1, "90cc27aa0e5f2d9a"}},
+		{"two rows", "SELECT 'b' UNION ALL SELECT 'a'", tableChecksum{2, "08bb6928ca551714"}},
+		{"no rows", "SELECT 1 WHERE 0", tableChecksum{0, "0000000000000000"}},
+	}
+	db, release, err := e.tm.Acquire(testTenant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := db.Query(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			got, err := checksumRows(rows)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("checksum = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAppendJSNumber checks against what JavaScript's String() gives.
+func TestAppendJSNumber(t *testing.T) {
+	tenth, fifth := 0.1, 0.2 // not constants, which would add up to exactly 0.3
+	for f, want := range map[float64]string{
+		0: "0", math.Copysign(0, -1): "0", 5: "5", -2.5: "-2.5", tenth + fifth: "0.30000000000000004",
+		1e20: "100000000000000000000", 1e21: "1e+21", 1.5e300: "1.5e+300",
+		0.000001: "0.000001", 1e-7: "1e-7", -1.5e-7: "-1.5e-7", math.Inf(1): "Infinity",
+	} {
+		if got := string(appendJSNumber(nil, f)); got != want {
+			t.Errorf("%v: %s, want %s", f, got, want)
+		}
+	}
+}
+
+func TestGetChecksumVersion(t *testing.T) {
+	e := newTestEnv(t)
+	e.push(auth.RoleMember, insertProject("m1", "p1", "Alpha"))
+	h := GetChecksum(e.tm)
+
+	rec := e.serve(h, auth.RoleViewer, http.MethodGet, "/api/sync/checksum?version=1&tables=projects", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	res := decode[checksumResponse](t, rec)
+	if res.Version != 1 || len(res.Tables) != 1 || res.Tables["projects"].Count != 1 {
+		t.Errorf("checksum = %+v", res)
+	}
+
+	rec = e.serve(h, auth.RoleViewer, http.MethodGet, "/api/sync/checksum?version=7", nil)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("stale version: status %d, want 409", rec.Code)
+	}
+	if body := decode[map[string]any](t, rec); body["error"] != "version mismatch" || body["version"] != float64(1) {
+		t.Errorf("409 body = %v", body)
+	}
+	if rec := e.serve(h, auth.RoleViewer, http.MethodGet, "/api/sync/checksum?version=x", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid version: status %d, want 400", rec.Code)
+	}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 
@@ -10,21 +11,32 @@ import (
 )
 
 type column struct {
-	ID        string `json:"id"`
-	ProjectID string `json:"project_id"`
-	Name      string `json:"name"`
-	Color     string `json:"color"`
-	Position  int    `json:"position"`
+	ID         string `json:"id"`
+	ProjectID  string `json:"project_id"`
+	Name       string `json:"name"`
+	Color      string `json:"color"`
+	Position   int    `json:"position"`
+	RowVersion int64  `json:"row_version"`
+}
+
+// selectColumn reads a column with its current row version.
+func selectColumn(ctx context.Context, q sync.Querier, colID string) (column, error) {
+	var c column
+	err := q.QueryRowContext(ctx,
+		"SELECT id, project_id, name, color, position, row_version FROM kanban_columns WHERE id = ?", colID,
+	).Scan(&c.ID, &c.ProjectID, &c.Name, &c.Color, &c.Position, &c.RowVersion)
+	return c, err
 }
 
 func CreateColumn(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.Acquire(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		var req struct {
 			ProjectID string `json:"project_id"`
@@ -60,12 +72,13 @@ func CreateColumn(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			return
 		}
 
-		newVersion, err := hub.NextVersion(ctx, tenantID)
+		newVersion, err := sync.NextVersion(ctx, tx)
 		if err != nil {
-			http.Error(w, `{"error":"redis error"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error":"version allocation failed"}`, http.StatusInternalServerError)
 			return
 		}
 
+		c.RowVersion = newVersion
 		payload, _ := json.Marshal(c)
 		if _, err := tx.ExecContext(ctx,
 			"INSERT INTO sync_log (table_name, entity_id, operation, payload, version) VALUES (?, ?, 'INSERT', ?, ?)",
@@ -89,16 +102,18 @@ func UpdateColumn(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
 		colID := r.PathValue("id")
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.Acquire(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		var req struct {
-			Name     *string `json:"name"`
-			Color    *string `json:"color"`
-			Position *int    `json:"position"`
+			Name        *string `json:"name"`
+			Color       *string `json:"color"`
+			Position    *int    `json:"position"`
+			BaseVersion *int64  `json:"base_version"`
 		}
 		if err := decodeJSON(r, &req); err != nil {
 			http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
@@ -113,6 +128,18 @@ func UpdateColumn(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 		}
 		defer tx.Rollback()
 
+		if base, ok := expectedVersion(r, req.BaseVersion); ok {
+			current, err := selectColumn(ctx, tx, colID)
+			if err != nil {
+				http.Error(w, `{"error":"column not found"}`, http.StatusNotFound)
+				return
+			}
+			if current.RowVersion != base {
+				writeJSON(w, http.StatusConflict, conflictResponse{Error: "column was modified by someone else", Current: current})
+				return
+			}
+		}
+
 		if req.Name != nil {
 			tx.ExecContext(ctx, "UPDATE kanban_columns SET name = ? WHERE id = ?", *req.Name, colID)
 		}
@@ -123,21 +150,19 @@ func UpdateColumn(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			tx.ExecContext(ctx, "UPDATE kanban_columns SET position = ? WHERE id = ?", *req.Position, colID)
 		}
 
-		var c column
-		err = tx.QueryRowContext(ctx,
-			"SELECT id, project_id, name, color, position FROM kanban_columns WHERE id = ?", colID,
-		).Scan(&c.ID, &c.ProjectID, &c.Name, &c.Color, &c.Position)
+		c, err := selectColumn(ctx, tx, colID)
 		if err != nil {
 			http.Error(w, `{"error":"column not found"}`, http.StatusNotFound)
 			return
 		}
 
-		newVersion, err := hub.NextVersion(ctx, tenantID)
+		newVersion, err := sync.NextVersion(ctx, tx)
 		if err != nil {
-			http.Error(w, `{"error":"redis error"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error":"version allocation failed"}`, http.StatusInternalServerError)
 			return
 		}
 
+		c.RowVersion = newVersion
 		payload, _ := json.Marshal(c)
 		if _, err := tx.ExecContext(ctx,
 			"INSERT INTO sync_log (table_name, entity_id, operation, payload, version) VALUES (?, ?, 'UPDATE', ?, ?)",
@@ -161,11 +186,12 @@ func DeleteColumn(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
 		colID := r.PathValue("id")
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.Acquire(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		ctx := r.Context()
 		tx, err := db.BeginTx(ctx, nil)
@@ -185,9 +211,9 @@ func DeleteColumn(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			return
 		}
 
-		newVersion, err := hub.NextVersion(ctx, tenantID)
+		newVersion, err := sync.NextVersion(ctx, tx)
 		if err != nil {
-			http.Error(w, `{"error":"redis error"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error":"version allocation failed"}`, http.StatusInternalServerError)
 			return
 		}
 
@@ -212,14 +238,15 @@ func DeleteColumn(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 func ListColumns(tm *tenant.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.AcquireRead(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		projectID := r.URL.Query().Get("project_id")
-		query := "SELECT id, project_id, name, color, position FROM kanban_columns"
+		query := "SELECT id, project_id, name, color, position, row_version FROM kanban_columns"
 		var args []any
 		if projectID != "" {
 			query += " WHERE project_id = ?"
@@ -237,7 +264,7 @@ func ListColumns(tm *tenant.Manager) http.HandlerFunc {
 		var cols []column
 		for rows.Next() {
 			var c column
-			rows.Scan(&c.ID, &c.ProjectID, &c.Name, &c.Color, &c.Position)
+			rows.Scan(&c.ID, &c.ProjectID, &c.Name, &c.Color, &c.Position, &c.RowVersion)
 			cols = append(cols, c)
 		}
 		if cols == nil {
@@ -5,8 +5,14 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/ouroboros/backend/internal/migrations"
+	"github.com/ouroboros/backend/internal/tenant"
 )
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
@@ -15,11 +21,62 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	json.NewEncoder(w).Encode(v)
 }
 
+// dbUnavailable replies to a failed tenant.Manager.Acquire: 503 when the
+// server has too many tenant databases in use or the tenant's is being
+// restored, 404 or 403 when the tenant is unknown or suspended, 500 otherwise.
+func dbUnavailable(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, tenant.ErrAtCapacity):
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, `{"error":"too many tenants active"}`, http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, tenant.ErrLocked):
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, `{"error":"tenant under maintenance"}`, http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, tenant.ErrUnknownTenant), errors.Is(err, tenant.ErrInvalidID):
+		http.Error(w, `{"error":"unknown tenant"}`, http.StatusNotFound)
+		return
+	case errors.Is(err, tenant.ErrSuspended):
+		http.Error(w, `{"error":"tenant suspended"}`, http.StatusForbidden)
+		return
+	case errors.Is(err, migrations.ErrBehind):
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, `{"error":"tenant schema out of date"}`, http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, migrations.ErrDrift):
+		http.Error(w, `{"error":"tenant schema drift"}`, http.StatusInternalServerError)
+		return
+	}
+	http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+}
+
 func decodeJSON(r *http.Request, v any) error {
 	defer r.Body.Close()
 	return json.NewDecoder(r.Body).Decode(v)
 }
 
+// conflictResponse is returned with 409 when a write was based on a stale row version.
+type conflictResponse struct {
+	Error   string `json:"error"`
+	Current any    `json:"current"`
+}
+
+// expectedVersion returns the row version the client based its write on,
+// taken from the If-Match header ("12", W/"12" or 12) or else the body's base_version.
+func expectedVersion(r *http.Request, bodyVersion *int64) (int64, bool) {
+	if h := r.Header.Get("If-Match"); h != "" {
+		h = strings.Trim(strings.TrimPrefix(h, "W/"), `"`)
+		if v, err := strconv.ParseInt(h, 10, 64); err == nil {
+			return v, true
+		}
+	}
+	if bodyVersion != nil {
+		return *bodyVersion, true
+	}
+	return 0, false
+}
+
 // uuidV7 generates a UUIDv7 (time-ordered, random).
 func uuidV7() string {
 	var buf [16]byte
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ouroboros/backend/internal/auth"
+	"github.com/ouroboros/backend/internal/sync"
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+const testTenant = "acme"
+
+// testEnv is a tenant database behind a Manager, and a hub without Redis.
+type testEnv struct {
+	t   *testing.T
+	tm  *tenant.Manager
+	hub *sync.Hub
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	tm := tenant.NewManager(t.TempDir(), 4)
+	t.Cleanup(tm.CloseAll)
+	return &testEnv{t: t, tm: tm, hub: sync.NewHub(sync.NewLocalBackend())}
+}
+
+// exec runs statements on the tenant database, failing the test on error.
+func (e *testEnv) exec(query string, args ...any) {
+	e.t.Helper()
+	db, release, err := e.tm.Acquire(testTenant)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	defer release()
+	if _, err := db.Exec(query, args...); err != nil {
+		e.t.Fatalf("%s: %v", query, err)
+	}
+}
+
+// queryInt returns the single integer a query selects.
+func (e *testEnv) queryInt(query string, args ...any) int64 {
+	e.t.Helper()
+	db, release, err := e.tm.Acquire(testTenant)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	defer release()
+	var n int64
+	if err := db.QueryRow(query, args...).Scan(&n); err != nil {
+		e.t.Fatalf("%s: %v", query, err)
+	}
+	return n
+}
+
+// serve calls h as a user of the test tenant with the given role; body, if
+// not nil, is sent as JSON.
+func (e *testEnv) serve(h http.Handler, role auth.Role, method, target string, body any) *httptest.ResponseRecorder {
+	e.t.Helper()
+	return e.serveHeader(h, role, method, target, body, "", "")
+}
+
+// serveHeader is serve with one request header set, unless its value is empty.
+func (e *testEnv) serveHeader(h http.Handler, role auth.Role, method, target string, body any, key, value string) *httptest.ResponseRecorder {
+	e.t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			e.t.Fatal(err)
+		}
+	}
+	ctx := context.WithValue(context.Background(), auth.TenantKey, testTenant)
+	ctx = context.WithValue(ctx, auth.UserKey, "u1")
+	ctx = context.WithValue(ctx, auth.RoleKey, role)
+	req := httptest.NewRequest(method, target, &buf).WithContext(ctx)
+	if value != "" {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// decode unmarshals a response body, failing the test on error.
+func decode[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decode %s: %v", rec.Body, err)
+	}
+	return v
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 
@@ -21,16 +22,30 @@ type card struct {
 	Client             *string `json:"client"`
 	Priority           string  `json:"priority"`
 	Notes              *string `json:"notes"`
+	RowVersion         int64   `json:"row_version"`
+}
+
+// selectCard reads a card with its current row version.
+func selectCard(ctx context.Context, q sync.Querier, cardID string) (card, error) {
+	var c card
+	err := q.QueryRowContext(ctx,
+		`SELECT id, project_id, column_name, title, position, approval_status,
+		        assigned_approver_id, due_date, client, priority, notes, row_version
+		 FROM kanban_cards WHERE id = ?`, cardID,
+	).Scan(&c.ID, &c.ProjectID, &c.ColumnName, &c.Title, &c.Position, &c.ApprovalStatus,
+		&c.AssignedApproverID, &c.DueDate, &c.Client, &c.Priority, &c.Notes, &c.RowVersion)
+	return c, err
 }
 
 func CreateCard(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.Acquire(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		var req struct {
 			ProjectID  string `json:"project_id"`
@@ -66,12 +81,13 @@ func CreateCard(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			return
 		}
 
-		newVersion, err := hub.NextVersion(ctx, tenantID)
+		newVersion, err := sync.NextVersion(ctx, tx)
 		if err != nil {
-			http.Error(w, `{"error":"redis error"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error":"version allocation failed"}`, http.StatusInternalServerError)
 			return
 		}
 
+		c.RowVersion = newVersion
 		payload, _ := json.Marshal(c)
 		if _, err := tx.ExecContext(ctx,
 			"INSERT INTO sync_log (table_name, entity_id, operation, payload, version) VALUES (?, ?, 'INSERT', ?, ?)",
@@ -95,11 +111,12 @@ func UpdateCard(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
 		cardID := r.PathValue("id")
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.Acquire(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		var req struct {
 			ColumnName         *string `json:"column_name"`
@@ -111,11 +128,17 @@ func UpdateCard(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			Client             *string `json:"client"`
 			Priority           *string `json:"priority"`
 			Notes              *string `json:"notes"`
+			BaseVersion        *int64  `json:"base_version"`
 		}
 		if err := decodeJSON(r, &req); err != nil {
 			http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
 			return
 		}
+		// Approvers decide through DecideApproval; overriding the outcome is privileged
+		if req.ApprovalStatus != nil && !auth.Can(r.Context(), auth.PermApproveCards) {
+			auth.Forbidden(w, auth.PermApproveCards)
+			return
+		}
 
 		ctx := r.Context()
 		tx, err := db.BeginTx(ctx, nil)
@@ -125,6 +148,18 @@ func UpdateCard(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 		}
 		defer tx.Rollback()
 
+		before, err := selectCard(ctx, tx, cardID)
+		if err != nil {
+			http.Error(w, `{"error":"card not found"}`, http.StatusNotFound)
+			return
+		}
+
+		// Optimistic concurrency: refuse to overwrite a newer version than the client saw.
+		if base, ok := expectedVersion(r, req.BaseVersion); ok && before.RowVersion != base {
+			writeJSON(w, http.StatusConflict, conflictResponse{Error: "card was modified by someone else", Current: before})
+			return
+		}
+
 		if req.ColumnName != nil {
 			tx.ExecContext(ctx, "UPDATE kanban_cards SET column_name = ? WHERE id = ?", *req.ColumnName, cardID)
 		}
@@ -153,29 +188,21 @@ func UpdateCard(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			tx.ExecContext(ctx, "UPDATE kanban_cards SET notes = ? WHERE id = ?", *req.Notes, cardID)
 		}
 
-		var c card
-		err = tx.QueryRowContext(ctx,
-			`SELECT id, project_id, column_name, title, position, approval_status,
-			        assigned_approver_id, due_date, client, priority, notes
-			 FROM kanban_cards WHERE id = ?`, cardID,
-		).Scan(&c.ID, &c.ProjectID, &c.ColumnName, &c.Title, &c.Position, &c.ApprovalStatus,
-			&c.AssignedApproverID, &c.DueDate, &c.Client, &c.Priority, &c.Notes)
+		c, err := selectCard(ctx, tx, cardID)
 		if err != nil {
 			http.Error(w, `{"error":"card not found"}`, http.StatusNotFound)
 			return
 		}
 
-		newVersion, err := hub.NextVersion(ctx, tenantID)
+		newVersion, err := sync.NextVersion(ctx, tx)
 		if err != nil {
-			http.Error(w, `{"error":"redis error"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error":"version allocation failed"}`, http.StatusInternalServerError)
 			return
 		}
 
-		payload, _ := json.Marshal(c)
-		if _, err := tx.ExecContext(ctx,
-			"INSERT INTO sync_log (table_name, entity_id, operation, payload, version) VALUES (?, ?, 'UPDATE', ?, ?)",
-			"kanban_cards", c.ID, string(payload), newVersion,
-		); err != nil {
+		// Only the changed columns are logged; an update that changes nothing is not logged.
+		changed, err := logPatch(tx, ctx, "kanban_cards", c.ID, before, c, newVersion)
+		if err != nil {
 			http.Error(w, `{"error":"sync_log insert failed"}`, http.StatusInternalServerError)
 			return
 		}
@@ -185,7 +212,10 @@ func UpdateCard(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			return
 		}
 
-		hub.Notify(ctx, tenantID, newVersion)
+		if changed {
+			c.RowVersion = newVersion
+			hub.Notify(ctx, tenantID, newVersion)
+		}
 		writeJSON(w, http.StatusOK, c)
 	}
 }
@@ -193,14 +223,15 @@ func UpdateCard(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 func ListCards(tm *tenant.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.AcquireRead(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		projectID := r.URL.Query().Get("project_id")
-		query := "SELECT id, project_id, column_name, title, position, approval_status, assigned_approver_id, due_date, client, priority, notes FROM kanban_cards"
+		query := "SELECT id, project_id, column_name, title, position, approval_status, assigned_approver_id, due_date, client, priority, notes, row_version FROM kanban_cards"
 		var args []any
 		if projectID != "" {
 			query += " WHERE project_id = ?"
@@ -218,7 +249,7 @@ func ListCards(tm *tenant.Manager) http.HandlerFunc {
 		var cards []card
 		for rows.Next() {
 			var c card
-			rows.Scan(&c.ID, &c.ProjectID, &c.ColumnName, &c.Title, &c.Position, &c.ApprovalStatus, &c.AssignedApproverID, &c.DueDate, &c.Client, &c.Priority, &c.Notes)
+			rows.Scan(&c.ID, &c.ProjectID, &c.ColumnName, &c.Title, &c.Position, &c.ApprovalStatus, &c.AssignedApproverID, &c.DueDate, &c.Client, &c.Priority, &c.Notes, &c.RowVersion)
 			cards = append(cards, c)
 		}
 		if cards == nil {
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/ouroboros/backend/internal/auth"
+)
+
+// putCard sends a REST update for card c1, with an If-Match header when
+// ifMatch is not empty. It returns the updated card, or on 409 the current
+// one and the error.
+func (e *testEnv) putCard(ifMatch string, body map[string]any) (int, card, string) {
+	e.t.Helper()
+	mux := http.NewServeMux()
+	mux.Handle("PUT /api/kanban/cards/{id}", UpdateCard(e.tm, e.hub))
+	rec := e.serveHeader(mux, auth.RoleMember, http.MethodPut, "/api/kanban/cards/c1", body, "If-Match", ifMatch)
+	switch rec.Code {
+	case http.StatusOK:
+		return rec.Code, decode[card](e.t, rec), ""
+	case http.StatusConflict:
+		res := decode[struct {
+			Error   string `json:"error"`
+			Current card   `json:"current"`
+		}](e.t, rec)
+		return rec.Code, res.Current, res.Error
+	}
+	e.t.Fatalf("PUT: status %d: %s", rec.Code, rec.Body)
+	return 0, card{}, ""
+}
+
+func TestUpdateCardConflict(t *testing.T) {
+	e := newTestEnv(t)
+	res := e.push(auth.RoleMember, insertProject("m1", "p1", "Alpha"), mutation{ID: "m2", Table: "kanban_cards", EntityID: "c1",
+		Operation: "INSERT", Payload: payload(map[string]any{"project_id": "p1", "title": "Draft"})})
+	v1 := res.Version
+	if got := e.queryInt("SELECT row_version FROM kanban_cards WHERE id = 'c1'"); got != v1 {
+		t.Fatalf("row_version after insert = %d, want %d", got, v1)
+	}
+
+	code, c, _ := e.putCard("", map[string]any{"title": "First", "base_version": v1})
+	if code != http.StatusOK || c.Title != "First" || c.RowVersion <= v1 {
+		t.Fatalf("update at the current version: %d %+v", code, c)
+	}
+	v2 := c.RowVersion
+	if got := e.queryInt("SELECT row_version FROM kanban_cards WHERE id = 'c1'"); got != v2 {
+		t.Errorf("stored row_version = %d, response says %d", got, v2)
+	}
+
+	tests := []struct {
+		name    string
+		ifMatch string
+		base    any
+	}{
+		{"stale base_version", "", v1},
+		{"stale If-Match", fmt.Sprintf("%q", fmt.Sprint(v1)), nil},
+		{"stale weak If-Match", fmt.Sprintf("W/%q", fmt.Sprint(v1)), nil},
+		{"If-Match wins over base_version", fmt.Sprint(v1), v2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, current, msg := e.putCard(tt.ifMatch, map[string]any{"title": "Lost", "base_version": tt.base})
+			if code != http.StatusConflict || msg != "card was modified by someone else" {
+				t.Fatalf("status %d, error %q", code, msg)
+			}
+			if current.Title != "First" || current.RowVersion != v2 {
+				t.Errorf("current = %+v", current)
+			}
+		})
+	}
+	if got := e.queryInt("SELECT COUNT(*) FROM kanban_cards WHERE title = 'Lost'"); got != 0 {
+		t.Error("a conflicting update was applied")
+	}
+
+	// Without a version the write is last-writer-wins
+	if code, c, _ := e.putCard("", map[string]any{"title": "Blind"}); code != http.StatusOK || c.Title != "Blind" || c.RowVersion <= v2 {
+		t.Errorf("unversioned update: %d %+v", code, c)
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+)
+
+// fieldChange is one column's transition recorded in a PATCH entry.
+type fieldChange struct {
+	Old json.RawMessage `json:"old"`
+	New json.RawMessage `json:"new"`
+}
+
+// patchPayload is the sync_log payload of a PATCH entry. Only columns whose
+// value changed are listed; clients merge them into the row they already have.
+type patchPayload struct {
+	ID         string                 `json:"id"`
+	RowVersion int64                  `json:"row_version"`
+	Changes    map[string]fieldChange `json:"changes"`
+}
+
+// diffRow compares two rows of the same DTO type by their JSON fields.
+// The id and row_version bookkeeping fields are never reported as changes.
+func diffRow(before, after any) map[string]fieldChange {
+	var oldFields, newFields map[string]json.RawMessage
+	b, _ := json.Marshal(before)
+	json.Unmarshal(b, &oldFields)
+	b, _ = json.Marshal(after)
+	json.Unmarshal(b, &newFields)
+
+	changes := map[string]fieldChange{}
+	for col, v := range newFields {
+		if col == "id" || col == "row_version" {
+			continue
+		}
+		if old, ok := oldFields[col]; !ok || !bytes.Equal(old, v) {
+			changes[col] = fieldChange{Old: old, New: v}
+		}
+	}
+	return changes
+}
+
+// logPatch writes a PATCH entry with the columns that differ between before
+// and after. Nothing is written when the row is unchanged; the return value
+// reports whether an entry was logged.
+func logPatch(tx *sql.Tx, ctx context.Context, table, entityID string, before, after any, version int64) (bool, error) {
+	changes := diffRow(before, after)
+	if len(changes) == 0 {
+		return false, nil
+	}
+	payload, _ := json.Marshal(patchPayload{ID: entityID, RowVersion: version, Changes: changes})
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO sync_log (table_name, entity_id, operation, payload, version) VALUES (?, ?, 'PATCH', ?, ?)",
+		table, entityID, string(payload), version,
+	)
+	return err == nil, err
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/ouroboros/backend/internal/auth"
+)
+
+func TestDiffRow(t *testing.T) {
+	before := card{ID: "c1", Title: "Draft", Position: 1, RowVersion: 3}
+	notes := "call back"
+	after := card{ID: "c1", Title: "Draft", Position: 2, Notes: &notes, RowVersion: 4}
+
+	changes := diffRow(before, after)
+	if len(changes) != 2 {
+		t.Fatalf("changes = %v, want position and notes", changes)
+	}
+	if c := changes["position"]; string(c.Old) != "1" || string(c.New) != "2" {
+		t.Errorf("position: %s -> %s", c.Old, c.New)
+	}
+	if c := changes["notes"]; string(c.Old) != "null" || string(c.New) != `"call back"` {
+		t.Errorf("notes: %s -> %s", c.Old, c.New)
+	}
+	if len(diffRow(before, before)) != 0 {
+		t.Error("an unchanged row has changes")
+	}
+}
+
+// TestUpdateCardPatch checks that card updates are logged as PATCH entries
+// holding only the changed columns, and that a client merging them into its
+// copy of the row ends up with the server's row.
+func TestUpdateCardPatch(t *testing.T) {
+	e := newTestEnv(t)
+	e.push(auth.RoleMember, insertProject("m1", "p1", "Alpha"), mutation{ID: "m2", Table: "kanban_cards", EntityID: "c1",
+		Operation: "INSERT", Payload: payload(map[string]any{"project_id": "p1", "title": "Draft"})})
+	client := map[string]json.RawMessage{}
+	json.Unmarshal([]byte(e.logPayload("INSERT")), &client)
+
+	res := e.push(auth.RoleMember, mutation{ID: "m3", Table: "kanban_cards", EntityID: "c1", Operation: "UPDATE",
+		Payload: payload(map[string]any{"title": "Draft", "priority": "high", "column_name": "doing"})})
+	if r := res.Results[0]; r.Status != "accepted" {
+		t.Fatalf("push update: %+v", r)
+	}
+	patch := decodePatch(t, e.logPayload("PATCH"))
+	if patch.ID != "c1" || patch.RowVersion != res.Version || len(patch.Changes) != 2 {
+		t.Fatalf("patch = %+v, want priority and column_name at version %d", patch, res.Version)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("PUT /api/kanban/cards/{id}", UpdateCard(e.tm, e.hub))
+	rec := e.serve(mux, auth.RoleMember, http.MethodPut, "/api/kanban/cards/c1", map[string]any{"notes": "n"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT: status %d: %s", rec.Code, rec.Body)
+	}
+	server := decode[map[string]json.RawMessage](t, rec)
+
+	// Replay both patches onto the client's copy
+	for _, p := range []patchPayload{patch, decodePatch(t, e.logPayload("PATCH"))} {
+		for col, c := range p.Changes {
+			if string(client[col]) != string(c.Old) {
+				t.Errorf("%s: client has %s, patch expects %s", col, client[col], c.Old)
+			}
+			client[col] = c.New
+		}
+		client["row_version"], _ = json.Marshal(p.RowVersion)
+	}
+	for col, v := range server {
+		if string(client[col]) != string(v) {
+			t.Errorf("%s: merged %s, server %s", col, client[col], v)
+		}
+	}
+
+	// An update that changes nothing is not logged
+	logged := e.queryInt("SELECT COUNT(*) FROM sync_log")
+	if rec := e.serve(mux, auth.RoleMember, http.MethodPut, "/api/kanban/cards/c1", map[string]any{"notes": "n"}); rec.Code != http.StatusOK {
+		t.Fatalf("no-op PUT: status %d", rec.Code)
+	}
+	if n := e.queryInt("SELECT COUNT(*) FROM sync_log"); n != logged {
+		t.Errorf("a no-op update logged %d entries", n-logged)
+	}
+}
+
+// logPayload returns the payload of the latest sync_log entry with the given
+// operation.
+func (e *testEnv) logPayload(op string) string {
+	e.t.Helper()
+	db, release, err := e.tm.Acquire(testTenant)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	defer release()
+	var payload string
+	if err := db.QueryRow("SELECT payload FROM sync_log WHERE operation = ? ORDER BY id DESC LIMIT 1", op).Scan(&payload); err != nil {
+		e.t.Fatalf("%s entry: %v", op, err)
+	}
+	return payload
+}
+
+func decodePatch(t *testing.T, s string) patchPayload {
+	t.Helper()
+	var p patchPayload
+	if err := json.Unmarshal([]byte(s), &p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
@@ -10,25 +10,28 @@ import (
 )
 
 type productDTO struct {
-	ID    string  `json:"id"`
-	Name  string  `json:"name"`
-	Price float64 `json:"price"`
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	Price      float64 `json:"price"`
+	RowVersion int64   `json:"row_version"`
 }
 
 type orderDTO struct {
-	UUID      string    `json:"uuid"`
-	ShortID   string    `json:"short_id"`
-	CardID    *string   `json:"card_id"`
-	ProjectID *string   `json:"project_id"`
-	Total     float64   `json:"total"`
-	Items     []itemDTO `json:"items,omitempty"`
+	UUID       string    `json:"uuid"`
+	ShortID    string    `json:"short_id"`
+	CardID     *string   `json:"card_id"`
+	ProjectID  *string   `json:"project_id"`
+	Total      float64   `json:"total"`
+	RowVersion int64     `json:"row_version"`
+	Items      []itemDTO `json:"items,omitempty"`
 }
 
 type itemDTO struct {
-	ID        string `json:"id"`
-	OrderID   string `json:"order_id"`
-	ProductID string `json:"product_id"`
-	Qty       int    `json:"qty"`
+	ID         string `json:"id"`
+	OrderID    string `json:"order_id"`
+	ProductID  string `json:"product_id"`
+	Qty        int    `json:"qty"`
+	RowVersion int64  `json:"row_version"`
 }
 
 // --- Products ---
@@ -36,11 +39,12 @@ type itemDTO struct {
 func CreateProduct(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.Acquire(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		var req struct {
 			Name  string  `json:"name"`
@@ -69,12 +73,13 @@ func CreateProduct(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			return
 		}
 
-		newVersion, err := hub.NextVersion(ctx, tenantID)
+		newVersion, err := sync.NextVersion(ctx, tx)
 		if err != nil {
-			http.Error(w, `{"error":"redis error"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error":"version allocation failed"}`, http.StatusInternalServerError)
 			return
 		}
 
+		p.RowVersion = newVersion
 		payload, _ := json.Marshal(p)
 		if _, err := tx.ExecContext(ctx,
 			"INSERT INTO sync_log (table_name, entity_id, operation, payload, version) VALUES (?, ?, 'INSERT', ?, ?)",
@@ -97,13 +102,14 @@ func CreateProduct(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 func ListProducts(tm *tenant.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.AcquireRead(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
-		rows, err := db.QueryContext(r.Context(), "SELECT id, name, price FROM products ORDER BY name")
+		rows, err := db.QueryContext(r.Context(), "SELECT id, name, price, row_version FROM products ORDER BY name")
 		if err != nil {
 			http.Error(w, `{"error":"query failed"}`, http.StatusInternalServerError)
 			return
@@ -113,7 +119,7 @@ func ListProducts(tm *tenant.Manager) http.HandlerFunc {
 		var products []productDTO
 		for rows.Next() {
 			var p productDTO
-			rows.Scan(&p.ID, &p.Name, &p.Price)
+			rows.Scan(&p.ID, &p.Name, &p.Price, &p.RowVersion)
 			products = append(products, p)
 		}
 		if products == nil {
@@ -128,11 +134,12 @@ func ListProducts(tm *tenant.Manager) http.HandlerFunc {
 func CreateOrder(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.Acquire(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		var req struct {
 			CardID    *string `json:"card_id"`
@@ -215,12 +222,16 @@ func CreateOrder(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			Items:     items,
 		}
 
-		newVersion, err := hub.NextVersion(ctx, tenantID)
+		newVersion, err := sync.NextVersion(ctx, tx)
 		if err != nil {
-			http.Error(w, `{"error":"redis error"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error":"version allocation failed"}`, http.StatusInternalServerError)
 			return
 		}
 
+		order.RowVersion = newVersion
+		for i := range order.Items {
+			order.Items[i].RowVersion = newVersion
+		}
 		payload, _ := json.Marshal(order)
 		if _, err := tx.ExecContext(ctx,
 			"INSERT INTO sync_log (table_name, entity_id, operation, payload, version) VALUES (?, ?, 'INSERT', ?, ?)",
@@ -231,7 +242,7 @@ func CreateOrder(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 		}
 
 		// Sync each item separately so the worker's os_items table stays populated
-		for _, it := range items {
+		for _, it := range order.Items {
 			itemPayload, _ := json.Marshal(it)
 			if _, err := tx.ExecContext(ctx,
 				"INSERT INTO sync_log (table_name, entity_id, operation, payload, version) VALUES (?, ?, 'INSERT', ?, ?)",
@@ -255,14 +266,15 @@ func CreateOrder(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 func ListOrders(tm *tenant.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.AcquireRead(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		cardID := r.URL.Query().Get("card_id")
-		query := "SELECT uuid, short_id, card_id, project_id, total FROM os_orders"
+		query := "SELECT uuid, short_id, card_id, project_id, total, row_version FROM os_orders"
 		var args []any
 		if cardID != "" {
 			query += " WHERE card_id = ?"
@@ -280,7 +292,7 @@ func ListOrders(tm *tenant.Manager) http.HandlerFunc {
 		var orders []orderDTO
 		for rows.Next() {
 			var o orderDTO
-			rows.Scan(&o.UUID, &o.ShortID, &o.CardID, &o.ProjectID, &o.Total)
+			rows.Scan(&o.UUID, &o.ShortID, &o.CardID, &o.ProjectID, &o.Total, &o.RowVersion)
 			orders = append(orders, o)
 		}
 		if orders == nil {
@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/ouroboros/backend/internal/auth"
@@ -10,18 +13,20 @@ import (
 )
 
 type project struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	RowVersion int64  `json:"row_version"`
 }
 
 func CreateProject(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.Acquire(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		var req struct {
 			Name string `json:"name"`
@@ -48,12 +53,13 @@ func CreateProject(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 			return
 		}
 
-		newVersion, err := hub.NextVersion(ctx, tenantID)
+		newVersion, err := sync.NextVersion(ctx, tx)
 		if err != nil {
-			http.Error(w, `{"error":"redis error"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error":"version allocation failed"}`, http.StatusInternalServerError)
 			return
 		}
 
+		p.RowVersion = newVersion
 		payload, _ := json.Marshal(p)
 		if _, err := tx.ExecContext(ctx,
 			"INSERT INTO sync_log (table_name, entity_id, operation, payload, version) VALUES (?, ?, 'INSERT', ?, ?)",
@@ -77,11 +83,12 @@ func DeleteProject(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
 		projectID := r.PathValue("id")
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.Acquire(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
 		ctx := r.Context()
 		tx, err := db.BeginTx(ctx, nil)
@@ -91,34 +98,30 @@ func DeleteProject(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 		}
 		defer tx.Rollback()
 
-		// Delete cascade: items → orders → cards → columns → project
-		tx.ExecContext(ctx, "DELETE FROM os_items WHERE order_id IN (SELECT uuid FROM os_orders WHERE project_id = ?)", projectID)
-		tx.ExecContext(ctx, "DELETE FROM os_orders WHERE project_id = ?", projectID)
-		tx.ExecContext(ctx, "DELETE FROM kanban_cards WHERE project_id = ?", projectID)
-		tx.ExecContext(ctx, "DELETE FROM kanban_columns WHERE project_id = ?", projectID)
-
-		result, err := tx.ExecContext(ctx, "DELETE FROM projects WHERE id = ?", projectID)
-		if err != nil {
-			http.Error(w, `{"error":"delete failed"}`, http.StatusInternalServerError)
+		deleted, err := deleteProject(ctx, tx, projectID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, `{"error":"project not found"}`, http.StatusNotFound)
 			return
 		}
-		if n, _ := result.RowsAffected(); n == 0 {
-			http.Error(w, `{"error":"project not found"}`, http.StatusNotFound)
+		if err != nil {
+			http.Error(w, `{"error":"delete failed"}`, http.StatusInternalServerError)
 			return
 		}
 
-		newVersion, err := hub.NextVersion(ctx, tenantID)
+		newVersion, err := sync.NextVersion(ctx, tx)
 		if err != nil {
-			http.Error(w, `{"error":"redis error"}`, http.StatusInternalServerError)
+			http.Error(w, `{"error":"version allocation failed"}`, http.StatusInternalServerError)
 			return
 		}
 
-		if _, err := tx.ExecContext(ctx,
-			`INSERT INTO sync_log (table_name, entity_id, operation, payload, version) VALUES (?, ?, 'DELETE', '{}', ?)`,
-			"projects", projectID, newVersion,
-		); err != nil {
-			http.Error(w, `{"error":"sync_log insert failed"}`, http.StatusInternalServerError)
-			return
+		for _, d := range deleted {
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO sync_log (table_name, entity_id, operation, payload, version) VALUES (?, ?, 'DELETE', '{}', ?)`,
+				d.table, d.id, newVersion,
+			); err != nil {
+				http.Error(w, `{"error":"sync_log insert failed"}`, http.StatusInternalServerError)
+				return
+			}
 		}
 
 		if err := tx.Commit(); err != nil {
@@ -131,16 +134,68 @@ func DeleteProject(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
 	}
 }
 
+// deletedRow is a row removed by deleteProject, to be logged as a DELETE.
+type deletedRow struct {
+	table, id string
+}
+
+// projectCascade deletes everything in a project, children before parents.
+// Card children would go with ON DELETE CASCADE, but are deleted explicitly so
+// replicas hear about them. Orders belong to the project directly or through
+// one of its cards.
+var projectCascade = []struct{ table, query string }{
+	{"os_items", `DELETE FROM os_items WHERE order_id IN (SELECT uuid FROM os_orders
+		WHERE project_id = ?1 OR card_id IN (SELECT id FROM kanban_cards WHERE project_id = ?1)) RETURNING id`},
+	{"os_orders", `DELETE FROM os_orders
+		WHERE project_id = ?1 OR card_id IN (SELECT id FROM kanban_cards WHERE project_id = ?1) RETURNING uuid`},
+	{"card_tags", "DELETE FROM card_tags WHERE card_id IN (SELECT id FROM kanban_cards WHERE project_id = ?1) RETURNING id"},
+	{"card_assigned_users", "DELETE FROM card_assigned_users WHERE card_id IN (SELECT id FROM kanban_cards WHERE project_id = ?1) RETURNING id"},
+	{"card_approvers", "DELETE FROM card_approvers WHERE card_id IN (SELECT id FROM kanban_cards WHERE project_id = ?1) RETURNING id"},
+	{"card_sessions", "DELETE FROM card_sessions WHERE card_id IN (SELECT id FROM kanban_cards WHERE project_id = ?1) RETURNING id"},
+	{"kanban_cards", "DELETE FROM kanban_cards WHERE project_id = ?1 RETURNING id"},
+	{"kanban_columns", "DELETE FROM kanban_columns WHERE project_id = ?1 RETURNING id"},
+	{"projects", "DELETE FROM projects WHERE id = ?1 RETURNING id"},
+}
+
+// deleteProject deletes a project with everything in it and returns every
+// removed row. It returns sql.ErrNoRows when the project does not exist.
+func deleteProject(ctx context.Context, tx *sql.Tx, projectID string) ([]deletedRow, error) {
+	var deleted []deletedRow
+	for _, step := range projectCascade {
+		rows, err := tx.QueryContext(ctx, step.query, projectID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			d := deletedRow{table: step.table}
+			if err := rows.Scan(&d.id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			deleted = append(deleted, d)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if len(deleted) == 0 || deleted[len(deleted)-1].table != "projects" {
+		return nil, sql.ErrNoRows
+	}
+	return deleted, nil
+}
+
 func ListProjects(tm *tenant.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.AcquireRead(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
 			return
 		}
+		defer release()
 
-		rows, err := db.QueryContext(r.Context(), "SELECT id, name FROM projects ORDER BY created_at")
+		rows, err := db.QueryContext(r.Context(), "SELECT id, name, row_version FROM projects ORDER BY created_at")
 		if err != nil {
 			http.Error(w, `{"error":"query failed"}`, http.StatusInternalServerError)
 			return
@@ -150,7 +205,7 @@ func ListProjects(tm *tenant.Manager) http.HandlerFunc {
 		var projects []project
 		for rows.Next() {
 			var p project
-			rows.Scan(&p.ID, &p.Name)
+			rows.Scan(&p.ID, &p.Name, &p.RowVersion)
 			projects = append(projects, p)
 		}
 		if projects == nil {
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/ouroboros/backend/internal/auth"
+	"github.com/ouroboros/backend/internal/migrations"
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+const (
+	// syncProtocol is the sync wire protocol this server speaks:
+	//	1 — INSERT, UPDATE and DELETE entries carrying full rows
+	//	2 — adds PATCH entries carrying only the changed columns
+	syncProtocol = 2
+	// minSyncProtocol is the oldest protocol still served; older clients get 426.
+	minSyncProtocol = 1
+)
+
+// syncClient is what a client declared about itself in the X-Sync-Protocol,
+// X-Sync-Schema and X-Sync-Replica headers (or the protocol, schema and
+// replica query parameters, for streams that cannot set headers).
+type syncClient struct {
+	protocol int // wire protocol; 1 when not declared
+	schema   int // schema version the client's local tables follow; latest when not declared
+	replica  int // schema version the replica was last snapshotted under; schema when not declared
+	latest   int // the server's schema version
+}
+
+func parseSyncClient(r *http.Request, latest int) (syncClient, error) {
+	c := syncClient{protocol: 1, schema: latest, latest: latest}
+	param := func(header, query string, dst *int) error {
+		v := r.Header.Get(header)
+		if v == "" {
+			v = r.URL.Query().Get(query)
+		}
+		if v == "" {
+			return nil
+		}
+		n, err := strconv.Atoi(v)
+		if err == nil {
+			*dst = n
+		}
+		return err
+	}
+	if err := param("X-Sync-Protocol", "protocol", &c.protocol); err != nil {
+		return c, err
+	}
+	if err := param("X-Sync-Schema", "schema", &c.schema); err != nil {
+		return c, err
+	}
+	c.replica = c.schema
+	if err := param("X-Sync-Replica", "replica", &c.replica); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// negotiate advertises the server's protocol and schema version on w and
+// checks the client against them. Clients older than minSyncProtocol get 426;
+// with requireReplica, a replica lacking tables or columns the client's schema
+// has (because they were added after its snapshot) gets 409, telling the
+// client to load a fresh snapshot. Returns false when a reply was written.
+func negotiate(w http.ResponseWriter, r *http.Request, db *sql.DB, requireReplica bool) (syncClient, bool) {
+	m, err := migrations.Schema()
+	if err != nil {
+		http.Error(w, `{"error":"schema unavailable"}`, http.StatusInternalServerError)
+		return syncClient{}, false
+	}
+	var schema int
+	if err := db.QueryRowContext(r.Context(), "PRAGMA user_version").Scan(&schema); err != nil {
+		http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+		return syncClient{}, false
+	}
+	w.Header().Set("X-Sync-Protocol", strconv.Itoa(syncProtocol))
+	w.Header().Set("X-Sync-Schema", strconv.Itoa(schema))
+
+	c, err := parseSyncClient(r, m.Version)
+	if err != nil {
+		http.Error(w, `{"error":"invalid sync protocol headers"}`, http.StatusBadRequest)
+		return c, false
+	}
+	if c.protocol < minSyncProtocol {
+		writeJSON(w, http.StatusUpgradeRequired, map[string]any{
+			"error":        "sync protocol no longer supported",
+			"min_protocol": minSyncProtocol,
+		})
+		return c, false
+	}
+	if requireReplica && c.missesData(m) {
+		writeJSON(w, http.StatusConflict, map[string]any{
+			"error":          "resnapshot required",
+			"schema_version": schema,
+			"snapshot":       "/api/sync/snapshot",
+		})
+		return c, false
+	}
+	return c, true
+}
+
+// missesData reports whether a synced table or column the client knows was
+// added after its replica's snapshot: its rows were never sent to the client.
+func (c syncClient) missesData(m *migrations.Manifest) bool {
+	known := min(c.schema, m.Version)
+	for _, st := range snapshotTables {
+		t := m.Tables[st.name]
+		if t.Since > c.replica && t.Since <= known {
+			return true
+		}
+		for _, col := range t.Columns {
+			if col.Since > c.replica && col.Since <= known && syncedColumn(col.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// current reports whether the client takes entries as stored.
+func (c syncClient) current() bool {
+	return c.protocol >= syncProtocol && c.schema >= c.latest
+}
+
+// adapt rewrites entries for an older client: PATCH entries become UPDATEs
+// with the row's current state for protocol 1 (rows deleted since are left
+// to the DELETE that follows), and tables and columns newer than the
+// client's schema are dropped.
+func (c syncClient) adapt(ctx context.Context, db *sql.DB, entries []syncEntry) ([]syncEntry, error) {
+	if c.current() {
+		return entries, nil
+	}
+	m, err := migrations.Schema()
+	if err != nil {
+		return nil, err
+	}
+	out := entries[:0:0]
+	for _, e := range entries {
+		if m.Tables[e.Table].Since > c.schema {
+			continue
+		}
+		if e.Operation == "PATCH" && c.protocol < 2 {
+			row, err := currentRow(ctx, db, e.Table, e.EntityID)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			e.Operation, e.Payload = "UPDATE", row
+		}
+		if e.Operation != "DELETE" {
+			e.Payload = string(c.adaptPayload(m, e.Table, e.Operation, []byte(e.Payload)))
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// adaptPayload drops the columns newer than the client's schema from a row
+// payload, and for PATCH from its changes as well (row_version sits at the top).
+func (c syncClient) adaptPayload(m *migrations.Manifest, table, op string, payload []byte) []byte {
+	newer := map[string]bool{}
+	for _, col := range m.Tables[table].Columns {
+		if col.Since > c.schema {
+			newer[col.Name] = true
+		}
+	}
+	if len(newer) == 0 {
+		return payload
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(payload, &fields) != nil {
+		return payload
+	}
+	var changes map[string]json.RawMessage
+	if op == "PATCH" {
+		json.Unmarshal(fields["changes"], &changes)
+	}
+	for col := range newer {
+		delete(fields, col)
+		delete(changes, col)
+	}
+	if changes != nil {
+		fields["changes"], _ = json.Marshal(changes)
+	}
+	b, _ := json.Marshal(fields)
+	return b
+}
+
+// currentRow renders a row the way the snapshot does.
+func currentRow(ctx context.Context, db *sql.DB, table, id string) (string, error) {
+	for _, t := range snapshotTables {
+		if t.name != table {
+			continue
+		}
+		pk := "id"
+		if table == "os_orders" {
+			pk = "uuid"
+		}
+		var row string
+		err := db.QueryRowContext(ctx, t.query+" WHERE "+pk+" = ?", id).Scan(&row)
+		return row, err
+	}
+	return "", sql.ErrNoRows
+}
+
+// syncedColumn reports whether a column is part of the synced payloads;
+// created_at is server bookkeeping only.
+func syncedColumn(name string) bool {
+	return name != "created_at"
+}
+
+// manifestResponse is the body of GET /api/sync/manifest.
+type manifestResponse struct {
+	Protocol    int                         `json:"protocol"`
+	MinProtocol int                         `json:"min_protocol"`
+	Schema      int                         `json:"schema_version"`
+	Tables      map[string]migrations.Table `json:"tables"`
+}
+
+// GetManifest handles GET /api/sync/manifest
+// Returns the sync protocol range and, for every synced table, the columns
+// carried in sync payloads with their types and the migration that added
+// them, generated from the migrations. Clients use it to check their local
+// schema and to tell whether an upgrade needs a fresh snapshot.
+func GetManifest(tm *tenant.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenantID := auth.TenantFromCtx(r.Context())
+		db, release, err := tm.AcquireRead(tenantID)
+		if err != nil {
+			dbUnavailable(w, err)
+			return
+		}
+		defer release()
+		if _, ok := negotiate(w, r, db, false); !ok {
+			return
+		}
+		m, _ := migrations.Schema()
+
+		resp := manifestResponse{
+			Protocol:    syncProtocol,
+			MinProtocol: minSyncProtocol,
+			Schema:      m.Version,
+			Tables:      make(map[string]migrations.Table, len(snapshotTables)),
+		}
+		for _, st := range snapshotTables {
+			t := m.Tables[st.name]
+			var cols []migrations.Column
+			for _, col := range t.Columns {
+				if syncedColumn(col.Name) {
+					cols = append(cols, col)
+				}
+			}
+			resp.Tables[st.name] = migrations.Table{Since: t.Since, Columns: cols}
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/ouroboros/backend/internal/auth"
+)
+
+// seedPatched creates project p1 with cards c1 and c2 and tag t1, then
+// updates both cards so that PATCH entries are logged. It returns the version
+// before the updates.
+func (e *testEnv) seedPatched() int64 {
+	e.t.Helper()
+	v := e.push(auth.RoleMember,
+		insertProject("m1", "p1", "Alpha"),
+		mutation{ID: "m2", Table: "kanban_cards", EntityID: "c1", Operation: "INSERT",
+			Payload: payload(map[string]any{"project_id": "p1", "title": "Draft"})},
+		mutation{ID: "m3", Table: "kanban_cards", EntityID: "c2", Operation: "INSERT",
+			Payload: payload(map[string]any{"project_id": "p1", "title": "Other"})},
+		mutation{ID: "m4", Table: "card_tags", EntityID: "t1", Operation: "INSERT",
+			Payload: payload(map[string]any{"card_id": "c1", "name": "urgent"})},
+	).Version
+	for i, c := range []string{"c1", "c2"} {
+		if r := e.push(auth.RoleMember, mutation{ID: fmt.Sprint("u", i), Table: "kanban_cards", EntityID: c, Operation: "UPDATE",
+			Payload: payload(map[string]any{"title": "Final", "priority": "high"})}).Results[0]; r.Status != "accepted" {
+			e.t.Fatalf("update %s: %+v", c, r)
+		}
+	}
+	return v
+}
+
+// syncAs fetches the log after since as a client with the given parameters.
+func (e *testEnv) syncAs(since int64, client string) []syncEntry {
+	e.t.Helper()
+	rec := e.serve(GetSync(e.tm), auth.RoleViewer, http.MethodGet, fmt.Sprintf("/api/sync?since=%d&%s", since, client), nil)
+	if rec.Code != http.StatusOK {
+		e.t.Fatalf("sync as %s: status %d: %s", client, rec.Code, rec.Body)
+	}
+	return decode[syncPage](e.t, rec).Entries
+}
+
+func fields(t *testing.T, payload string) map[string]json.RawMessage {
+	t.Helper()
+	var f map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(payload), &f); err != nil {
+		t.Fatalf("payload %s: %v", payload, err)
+	}
+	return f
+}
+
+func TestAdaptProtocol1(t *testing.T) {
+	e := newTestEnv(t)
+	since := e.seedPatched()
+	if got := e.syncAs(since, "protocol=2"); len(got) != 2 || got[0].Operation != "PATCH" {
+		t.Fatalf("protocol 2 entries = %+v", got)
+	}
+
+	// c2 is deleted after its PATCH was logged
+	e.exec("DELETE FROM kanban_cards WHERE id = 'c2'")
+	e.exec(`INSERT INTO sync_log (table_name, entity_id, operation, payload, version)
+		VALUES ('kanban_cards', 'c2', 'DELETE', '{}', (SELECT value + 1 FROM sync_state WHERE key = 'version'))`)
+	e.exec("UPDATE sync_state SET value = value + 1 WHERE key = 'version'")
+
+	got := e.syncAs(since, "protocol=1")
+	if len(got) != 2 {
+		t.Fatalf("protocol 1 entries = %+v, want c1's UPDATE and c2's DELETE", got)
+	}
+	if got[0].EntityID != "c1" || got[0].Operation != "UPDATE" {
+		t.Fatalf("PATCH for c1 sent as %s %s", got[0].Operation, got[0].EntityID)
+	}
+	row := fields(t, got[0].Payload)
+	if string(row["title"]) != `"Final"` || string(row["priority"]) != `"high"` || string(row["project_id"]) != `"p1"` {
+		t.Errorf("UPDATE payload is not the full row: %s", got[0].Payload)
+	}
+	if _, ok := row["changes"]; ok {
+		t.Errorf("UPDATE payload carries changes: %s", got[0].Payload)
+	}
+	if got[1].EntityID != "c2" || got[1].Operation != "DELETE" {
+		t.Errorf("second entry = %+v, want c2's DELETE", got[1])
+	}
+}
+
+func TestAdaptOlderSchema(t *testing.T) {
+	e := newTestEnv(t)
+	e.seedPatched()
+	// Schema 4 has neither the card detail columns and card_tags (5) nor row_version (8)
+	got := e.syncAs(0, "protocol=2&schema=4")
+	var ops []string
+	for _, en := range got {
+		if en.Table == "card_tags" {
+			t.Errorf("entry of a table newer than the client: %+v", en)
+		}
+		ops = append(ops, en.Operation)
+		f := fields(t, en.Payload)
+		for _, col := range []string{"priority", "notes", "due_date", "client", "row_version"} {
+			if _, ok := f[col]; ok && en.Table == "kanban_cards" {
+				t.Errorf("%s %s payload carries %s: %s", en.Operation, en.EntityID, col, en.Payload)
+			}
+		}
+		if en.Operation == "PATCH" {
+			var changes map[string]fieldChange
+			json.Unmarshal(f["changes"], &changes)
+			if _, ok := changes["title"]; !ok || len(changes) != 1 {
+				t.Errorf("PATCH changes = %s, want title only", f["changes"])
+			}
+		}
+	}
+	if fmt.Sprint(ops) != "[INSERT INSERT INSERT PATCH PATCH]" {
+		t.Errorf("operations = %v", ops)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	e := newTestEnv(t)
+	h := GetSync(e.tm)
+	tests := []struct {
+		client string
+		want   int
+		error  string
+	}{
+		{"protocol=2", http.StatusOK, ""},
+		{"protocol=0", http.StatusUpgradeRequired, "sync protocol no longer supported"},
+		{"protocol=x", http.StatusBadRequest, "invalid sync protocol headers"},
+		// row_version (8) is synced but missing from a replica snapshotted under 4
+		{"schema=10&replica=4", http.StatusConflict, "resnapshot required"},
+		{"schema=4&replica=4", http.StatusOK, ""},
+		// Migration 10 added nothing synced
+		{"schema=10&replica=8", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.client, func(t *testing.T) {
+			rec := e.serve(h, auth.RoleViewer, http.MethodGet, "/api/sync?since=0&"+tt.client, nil)
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if rec.Header().Get("X-Sync-Protocol") != fmt.Sprint(syncProtocol) {
+				t.Errorf("X-Sync-Protocol = %q", rec.Header().Get("X-Sync-Protocol"))
+			}
+			if tt.error != "" {
+				if body := decode[map[string]any](t, rec); body["error"] != tt.error {
+					t.Errorf("error = %v, want %q", body["error"], tt.error)
+				}
+			}
+		})
+	}
+}
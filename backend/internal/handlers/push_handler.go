@@ -0,0 +1,843 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ouroboros/backend/internal/auth"
+	"github.com/ouroboros/backend/internal/sync"
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+const maxPushBatch = 500
+
+// mutation is a client-originated write, replayed from the offline outbox.
+type mutation struct {
+	ID        string          `json:"id"`
+	Table     string          `json:"table_name"`
+	EntityID  string          `json:"entity_id"`
+	Operation string          `json:"operation"`
+	Payload   json.RawMessage `json:"payload"`
+	// BaseVersion is the row_version the client edited; updates against a
+	// newer row are reported as conflicts instead of being applied.
+	BaseVersion *int64 `json:"base_version,omitempty"`
+}
+
+type mutationResult struct {
+	ID        string `json:"id"`
+	Status    string `json:"status"` // accepted | rejected | conflict
+	EntityID  string `json:"entity_id,omitempty"`
+	Version   int64  `json:"version,omitempty"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Current   any    `json:"current,omitempty"`
+}
+
+// rejectError is a validation failure reported back to the client as-is.
+type rejectError string
+
+func (e rejectError) Error() string { return string(e) }
+
+// conflictError reports a stale base_version together with the current server row.
+type conflictError struct {
+	current any
+}
+
+func (e conflictError) Error() string { return "row was modified by someone else" }
+
+// pushTx carries the state shared by all mutations of one push batch.
+// The version is allocated on first use so a batch of duplicates costs nothing.
+type pushTx struct {
+	ctx     context.Context
+	tx      *sql.Tx
+	userID  string
+	role    auth.Role
+	version int64
+}
+
+func (p *pushTx) nextVersion() (int64, error) {
+	if p.version == 0 {
+		v, err := sync.NextVersion(p.ctx, p.tx)
+		if err != nil {
+			return 0, err
+		}
+		p.version = v
+	}
+	return p.version, nil
+}
+
+// log writes a sync_log entry at the batch version. A nil payload is logged as '{}'.
+func (p *pushTx) log(table, entityID, op string, payload any) error {
+	v, err := p.nextVersion()
+	if err != nil {
+		return err
+	}
+	data := "{}"
+	if payload != nil {
+		b, _ := json.Marshal(payload)
+		data = string(b)
+	}
+	_, err = p.tx.ExecContext(p.ctx,
+		"INSERT INTO sync_log (table_name, entity_id, operation, payload, version) VALUES (?, ?, ?, ?, ?)",
+		table, entityID, op, data, v,
+	)
+	return err
+}
+
+// mutationPermission is the permission a mutation needs, that of the
+// corresponding REST route.
+func mutationPermission(m mutation) auth.Permission {
+	switch m.Table {
+	case "projects":
+		if m.Operation == "DELETE" {
+			return auth.PermDeleteProjects
+		}
+		return auth.PermWriteProjects
+	case "products":
+		return auth.PermWriteProducts
+	case "os_orders":
+		return auth.PermCreateOrders
+	default:
+		return auth.PermWriteBoard
+	}
+}
+
+// applyFunc applies one mutation inside the batch transaction and returns the
+// ID of the affected entity.
+type applyFunc func(p *pushTx, m mutation) (string, error)
+
+// pushAppliers maps table → operation → applier. Each applier enforces the same
+// rules as the corresponding REST handler.
+var pushAppliers = map[string]map[string]applyFunc{
+	"projects": {
+		"INSERT": pushInsertProject,
+		"DELETE": pushDeleteProject,
+	},
+	"kanban_columns": {
+		"INSERT": pushInsertColumn,
+		"UPDATE": pushUpdateColumn,
+		"DELETE": pushDeleteColumn,
+	},
+	"kanban_cards": {
+		"INSERT": pushInsertCard,
+		"UPDATE": pushUpdateCard,
+	},
+	"products": {
+		"INSERT": pushInsertProduct,
+	},
+	"os_orders": {
+		"INSERT": pushInsertOrder,
+	},
+	"card_tags": {
+		"INSERT": pushInsertTag,
+		"DELETE": pushDeleteBy("card_tags", "tag not found"),
+	},
+	"card_assigned_users": {
+		"INSERT": pushInsertAssignee,
+		"DELETE": pushDeleteBy("card_assigned_users", "assignee not found"),
+	},
+	"card_approvers": {
+		"INSERT": pushInsertApprover,
+		"UPDATE": pushDecideApproval,
+		"DELETE": pushDeleteApprover,
+	},
+	"card_sessions": {
+		"INSERT": pushInsertSession,
+		"DELETE": pushDeleteBy("card_sessions", "session not found"),
+	},
+}
+
+// PushMutations handles POST /api/sync/push
+// Applies a batch of client-generated mutations in one transaction. Each
+// mutation is isolated by a savepoint, so a rejected one does not affect the
+// rest. Accepted mutation IDs are remembered; re-pushing them is a no-op that
+// returns the original result.
+func PushMutations(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenantID := auth.TenantFromCtx(r.Context())
+		db, release, err := tm.Acquire(tenantID)
+		if err != nil {
+			dbUnavailable(w, err)
+			return
+		}
+		defer release()
+
+		var req struct {
+			Mutations []mutation `json:"mutations"`
+		}
+		if err := decodeJSON(r, &req); err != nil || len(req.Mutations) == 0 {
+			http.Error(w, `{"error":"mutations required"}`, http.StatusBadRequest)
+			return
+		}
+		if len(req.Mutations) > maxPushBatch {
+			http.Error(w, `{"error":"too many mutations"}`, http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		res, err := runPush(r.Context(), db, hub, tenantID, auth.UserFromCtx(r.Context()), auth.RoleFromCtx(r.Context()), req.Mutations)
+		if err != nil {
+			http.Error(w, `{"error":"push failed"}`, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+type pushResponse struct {
+	Version int64            `json:"version"`
+	Results []mutationResult `json:"results"`
+}
+
+// runPush applies a validated batch in one transaction and notifies the hub
+// when anything new was accepted. Shared by the HTTP and WebSocket transports.
+func runPush(ctx context.Context, db *sql.DB, hub *sync.Hub, tenantID, userID string, role auth.Role, mutations []mutation) (pushResponse, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return pushResponse{}, err
+	}
+	defer tx.Rollback()
+
+	p := &pushTx{ctx: ctx, tx: tx, userID: userID, role: role}
+	results := make([]mutationResult, 0, len(mutations))
+	accepted := 0
+	for _, m := range mutations {
+		res, err := p.apply(m)
+		if err != nil {
+			return pushResponse{}, err
+		}
+		if res.Status == "accepted" && !res.Duplicate {
+			accepted++
+		}
+		results = append(results, res)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return pushResponse{}, err
+	}
+
+	if accepted > 0 {
+		hub.Notify(ctx, tenantID, p.version)
+	}
+	return pushResponse{Version: p.version, Results: results}, nil
+}
+
+// apply runs a single mutation. Validation failures become a rejected result;
+// only errors that break the batch transaction itself are returned.
+func (p *pushTx) apply(m mutation) (mutationResult, error) {
+	res := mutationResult{ID: m.ID, EntityID: m.EntityID}
+	if m.ID == "" {
+		res.Status, res.Error = "rejected", "mutation id required"
+		return res, nil
+	}
+
+	err := p.tx.QueryRowContext(p.ctx,
+		"SELECT entity_id, version FROM sync_mutations WHERE id = ?", m.ID,
+	).Scan(&res.EntityID, &res.Version)
+	if err == nil {
+		res.Status, res.Duplicate = "accepted", true
+		return res, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return res, err
+	}
+
+	fn := pushAppliers[m.Table][m.Operation]
+	if fn == nil {
+		res.Status, res.Error = "rejected", "unsupported table or operation"
+		return res, nil
+	}
+	if perm := mutationPermission(m); !p.role.Can(perm) {
+		res.Status, res.Error = "rejected", "missing permission "+string(perm)
+		return res, nil
+	}
+
+	if _, err := p.tx.ExecContext(p.ctx, "SAVEPOINT mutation"); err != nil {
+		return res, err
+	}
+	// The batch version is allocated by its first accepted mutation: inside
+	// the savepoint, so a rejection takes the allocation back with it
+	allocated := p.version == 0
+	if _, err := p.nextVersion(); err != nil {
+		return res, err
+	}
+	entityID, err := fn(p, m)
+	if err == nil {
+		_, err = p.tx.ExecContext(p.ctx,
+			"INSERT INTO sync_mutations (id, table_name, entity_id, version) VALUES (?, ?, ?, ?)",
+			m.ID, m.Table, entityID, p.version,
+		)
+	}
+	if err != nil {
+		if _, rbErr := p.tx.ExecContext(p.ctx, "ROLLBACK TO mutation"); rbErr != nil {
+			return res, rbErr
+		}
+		if allocated {
+			p.version = 0
+		}
+		res.Status = "rejected"
+		var rej rejectError
+		var conflict conflictError
+		switch {
+		case errors.As(err, &rej):
+			res.Error = rej.Error()
+		case errors.As(err, &conflict):
+			res.Status, res.Error, res.Current = "conflict", conflict.Error(), conflict.current
+		default:
+			res.Error = "apply failed"
+		}
+	} else {
+		res.Status, res.EntityID, res.Version = "accepted", entityID, p.version
+	}
+	if _, err := p.tx.ExecContext(p.ctx, "RELEASE mutation"); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+func decodePayload(m mutation, v any) error {
+	if len(m.Payload) == 0 {
+		return rejectError("payload required")
+	}
+	if err := json.Unmarshal(m.Payload, v); err != nil {
+		return rejectError("invalid payload")
+	}
+	return nil
+}
+
+// ──────────────────────────── Projects ────────────────────────────
+
+func pushInsertProject(p *pushTx, m mutation) (string, error) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := decodePayload(m, &req); err != nil || req.Name == "" {
+		return "", rejectError("name required")
+	}
+
+	var pr project
+	err := p.tx.QueryRowContext(p.ctx,
+		"INSERT INTO projects (id, name) VALUES (COALESCE(NULLIF(?, ''), lower(hex(randomblob(16)))), ?) RETURNING id, name",
+		m.EntityID, req.Name,
+	).Scan(&pr.ID, &pr.Name)
+	if err != nil {
+		return "", rejectError("insert failed")
+	}
+	pr.RowVersion = p.version
+	return pr.ID, p.log("projects", pr.ID, "INSERT", pr)
+}
+
+func pushDeleteProject(p *pushTx, m mutation) (string, error) {
+	deleted, err := deleteProject(p.ctx, p.tx, m.EntityID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", rejectError("project not found")
+	}
+	if err != nil {
+		return "", rejectError("delete failed")
+	}
+	for _, d := range deleted {
+		if err := p.log(d.table, d.id, "DELETE", nil); err != nil {
+			return "", err
+		}
+	}
+	return m.EntityID, nil
+}
+
+// ──────────────────────────── Columns ────────────────────────────
+
+func pushInsertColumn(p *pushTx, m mutation) (string, error) {
+	var req struct {
+		ProjectID string `json:"project_id"`
+		Name      string `json:"name"`
+		Color     string `json:"color"`
+		Position  int    `json:"position"`
+	}
+	if err := decodePayload(m, &req); err != nil || req.Name == "" || req.ProjectID == "" {
+		return "", rejectError("project_id and name required")
+	}
+	if req.Color == "" {
+		req.Color = "bg-gray-500"
+	}
+
+	var c column
+	err := p.tx.QueryRowContext(p.ctx,
+		`INSERT INTO kanban_columns (id, project_id, name, color, position)
+		 VALUES (COALESCE(NULLIF(?, ''), lower(hex(randomblob(16)))), ?, ?, ?, ?)
+		 RETURNING id, project_id, name, color, position`,
+		m.EntityID, req.ProjectID, req.Name, req.Color, req.Position,
+	).Scan(&c.ID, &c.ProjectID, &c.Name, &c.Color, &c.Position)
+	if err != nil {
+		return "", rejectError("insert failed")
+	}
+	c.RowVersion = p.version
+	return c.ID, p.log("kanban_columns", c.ID, "INSERT", c)
+}
+
+func pushUpdateColumn(p *pushTx, m mutation) (string, error) {
+	var req struct {
+		Name     *string `json:"name"`
+		Color    *string `json:"color"`
+		Position *int    `json:"position"`
+	}
+	if err := decodePayload(m, &req); err != nil {
+		return "", err
+	}
+
+	if m.BaseVersion != nil {
+		current, err := selectColumn(p.ctx, p.tx, m.EntityID)
+		if err != nil {
+			return "", rejectError("column not found")
+		}
+		if current.RowVersion != *m.BaseVersion {
+			return "", conflictError{current: current}
+		}
+	}
+
+	set := func(col string, v any) error {
+		if _, err := p.tx.ExecContext(p.ctx, "UPDATE kanban_columns SET "+col+" = ? WHERE id = ?", v, m.EntityID); err != nil {
+			return rejectError("invalid " + col)
+		}
+		return nil
+	}
+	if req.Name != nil {
+		if err := set("name", *req.Name); err != nil {
+			return "", err
+		}
+	}
+	if req.Color != nil {
+		if err := set("color", *req.Color); err != nil {
+			return "", err
+		}
+	}
+	if req.Position != nil {
+		if err := set("position", *req.Position); err != nil {
+			return "", err
+		}
+	}
+
+	c, err := selectColumn(p.ctx, p.tx, m.EntityID)
+	if err != nil {
+		return "", rejectError("column not found")
+	}
+	c.RowVersion = p.version
+	return c.ID, p.log("kanban_columns", c.ID, "UPDATE", c)
+}
+
+func pushDeleteColumn(p *pushTx, m mutation) (string, error) {
+	if err := deleteRow(p, "kanban_columns", m.EntityID, "column not found"); err != nil {
+		return "", err
+	}
+	return m.EntityID, p.log("kanban_columns", m.EntityID, "DELETE", nil)
+}
+
+// ──────────────────────────── Cards ────────────────────────────
+
+func pushInsertCard(p *pushTx, m mutation) (string, error) {
+	var req struct {
+		ProjectID  string `json:"project_id"`
+		ColumnName string `json:"column_name"`
+		Title      string `json:"title"`
+		Position   int    `json:"position"`
+	}
+	if err := decodePayload(m, &req); err != nil || req.Title == "" || req.ProjectID == "" {
+		return "", rejectError("project_id and title required")
+	}
+	if req.ColumnName == "" {
+		req.ColumnName = "backlog"
+	}
+
+	var c card
+	err := p.tx.QueryRowContext(p.ctx,
+		`INSERT INTO kanban_cards (id, project_id, column_name, title, position)
+		 VALUES (COALESCE(NULLIF(?, ''), lower(hex(randomblob(16)))), ?, ?, ?, ?)
+		 RETURNING id, project_id, column_name, title, position, approval_status, assigned_approver_id, due_date, client, priority, notes`,
+		m.EntityID, req.ProjectID, req.ColumnName, req.Title, req.Position,
+	).Scan(&c.ID, &c.ProjectID, &c.ColumnName, &c.Title, &c.Position, &c.ApprovalStatus, &c.AssignedApproverID, &c.DueDate, &c.Client, &c.Priority, &c.Notes)
+	if err != nil {
+		return "", rejectError("insert failed")
+	}
+	c.RowVersion = p.version
+	return c.ID, p.log("kanban_cards", c.ID, "INSERT", c)
+}
+
+func pushUpdateCard(p *pushTx, m mutation) (string, error) {
+	var req struct {
+		ColumnName         *string `json:"column_name"`
+		Title              *string `json:"title"`
+		Position           *int    `json:"position"`
+		ApprovalStatus     *string `json:"approval_status"`
+		AssignedApproverID *string `json:"assigned_approver_id"`
+		DueDate            *string `json:"due_date"`
+		Client             *string `json:"client"`
+		Priority           *string `json:"priority"`
+		Notes              *string `json:"notes"`
+	}
+	if err := decodePayload(m, &req); err != nil {
+		return "", err
+	}
+	if req.ApprovalStatus != nil && !p.role.Can(auth.PermApproveCards) {
+		return "", rejectError("missing permission " + string(auth.PermApproveCards))
+	}
+
+	before, err := selectCard(p.ctx, p.tx, m.EntityID)
+	if err != nil {
+		return "", rejectError("card not found")
+	}
+	if m.BaseVersion != nil && before.RowVersion != *m.BaseVersion {
+		return "", conflictError{current: before}
+	}
+
+	set := func(col string, v any) error {
+		if _, err := p.tx.ExecContext(p.ctx, "UPDATE kanban_cards SET "+col+" = ? WHERE id = ?", v, m.EntityID); err != nil {
+			return rejectError("invalid " + col)
+		}
+		return nil
+	}
+	for _, f := range []struct {
+		col string
+		set bool
+		val any
+	}{
+		{"column_name", req.ColumnName != nil, req.ColumnName},
+		{"title", req.Title != nil, req.Title},
+		{"position", req.Position != nil, req.Position},
+		{"approval_status", req.ApprovalStatus != nil, req.ApprovalStatus},
+		{"assigned_approver_id", req.AssignedApproverID != nil, req.AssignedApproverID},
+		{"due_date", req.DueDate != nil, req.DueDate},
+		{"client", req.Client != nil, req.Client},
+		{"priority", req.Priority != nil, req.Priority},
+		{"notes", req.Notes != nil, req.Notes},
+	} {
+		if f.set {
+			if err := set(f.col, f.val); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	c, err := selectCard(p.ctx, p.tx, m.EntityID)
+	if err != nil {
+		return "", rejectError("card not found")
+	}
+	_, err = logPatch(p.tx, p.ctx, "kanban_cards", c.ID, before, c, p.version)
+	return c.ID, err
+}
+
+// ──────────────────────────── Products & Orders ────────────────────────────
+
+func pushInsertProduct(p *pushTx, m mutation) (string, error) {
+	var req struct {
+		Name  string  `json:"name"`
+		Price float64 `json:"price"`
+	}
+	if err := decodePayload(m, &req); err != nil || req.Name == "" {
+		return "", rejectError("name required")
+	}
+
+	var pr productDTO
+	err := p.tx.QueryRowContext(p.ctx,
+		"INSERT INTO products (id, name, price) VALUES (COALESCE(NULLIF(?, ''), lower(hex(randomblob(16)))), ?, ?) RETURNING id, name, price",
+		m.EntityID, req.Name, req.Price,
+	).Scan(&pr.ID, &pr.Name, &pr.Price)
+	if err != nil {
+		return "", rejectError("insert failed")
+	}
+	pr.RowVersion = p.version
+	return pr.ID, p.log("products", pr.ID, "INSERT", pr)
+}
+
+func pushInsertOrder(p *pushTx, m mutation) (string, error) {
+	var req struct {
+		CardID    *string `json:"card_id"`
+		ProjectID *string `json:"project_id"`
+		Items     []struct {
+			ProductID string `json:"product_id"`
+			Qty       int    `json:"qty"`
+		} `json:"items"`
+	}
+	if err := decodePayload(m, &req); err != nil || len(req.Items) == 0 {
+		return "", rejectError("items required")
+	}
+
+	if req.CardID != nil && *req.CardID != "" {
+		var status string
+		if err := p.tx.QueryRowContext(p.ctx, "SELECT approval_status FROM kanban_cards WHERE id = ?", *req.CardID).Scan(&status); err != nil {
+			return "", rejectError("card not found")
+		}
+		if status == "rejected" {
+			return "", rejectError("card is rejected — sales are locked")
+		}
+	}
+
+	orderUUID := m.EntityID
+	if orderUUID == "" {
+		orderUUID = uuidV7()
+	}
+	sid := shortID()
+
+	// Prices are always taken from the server, never from the client payload.
+	var total float64
+	for _, item := range req.Items {
+		var price float64
+		if err := p.tx.QueryRowContext(p.ctx, "SELECT price FROM products WHERE id = ?", item.ProductID).Scan(&price); err != nil {
+			return "", rejectError("product not found")
+		}
+		total += price * float64(item.Qty)
+	}
+
+	if _, err := p.tx.ExecContext(p.ctx,
+		"INSERT INTO os_orders (uuid, short_id, card_id, project_id, total) VALUES (?, ?, ?, ?, ?)",
+		orderUUID, sid, req.CardID, req.ProjectID, total,
+	); err != nil {
+		return "", rejectError("insert order failed")
+	}
+
+	var items []itemDTO
+	for _, item := range req.Items {
+		var it itemDTO
+		err := p.tx.QueryRowContext(p.ctx,
+			"INSERT INTO os_items (order_id, product_id, qty) VALUES (?, ?, ?) RETURNING id, order_id, product_id, qty",
+			orderUUID, item.ProductID, item.Qty,
+		).Scan(&it.ID, &it.OrderID, &it.ProductID, &it.Qty)
+		if err != nil {
+			return "", rejectError("insert item failed")
+		}
+		items = append(items, it)
+	}
+
+	for i := range items {
+		items[i].RowVersion = p.version
+	}
+	order := orderDTO{
+		UUID:       orderUUID,
+		ShortID:    sid,
+		CardID:     req.CardID,
+		ProjectID:  req.ProjectID,
+		Total:      total,
+		RowVersion: p.version,
+		Items:      items,
+	}
+	if err := p.log("os_orders", order.UUID, "INSERT", order); err != nil {
+		return "", err
+	}
+	for _, it := range items {
+		if err := p.log("os_items", it.ID, "INSERT", it); err != nil {
+			return "", err
+		}
+	}
+	return order.UUID, nil
+}
+
+// ──────────────────────────── Card details ────────────────────────────
+
+func pushInsertTag(p *pushTx, m mutation) (string, error) {
+	var req struct {
+		CardID string `json:"card_id"`
+		Name   string `json:"name"`
+	}
+	if err := decodePayload(m, &req); err != nil || req.Name == "" || req.CardID == "" {
+		return "", rejectError("card_id and name required")
+	}
+
+	var t tagDTO
+	err := p.tx.QueryRowContext(p.ctx,
+		"INSERT INTO card_tags (id, card_id, name) VALUES (COALESCE(NULLIF(?, ''), lower(hex(randomblob(16)))), ?, ?) RETURNING id, card_id, name",
+		m.EntityID, req.CardID, req.Name,
+	).Scan(&t.ID, &t.CardID, &t.Name)
+	if err != nil {
+		return "", rejectError("insert failed")
+	}
+	t.RowVersion = p.version
+	return t.ID, p.log("card_tags", t.ID, "INSERT", t)
+}
+
+func pushInsertAssignee(p *pushTx, m mutation) (string, error) {
+	var req struct {
+		CardID    string `json:"card_id"`
+		UserID    string `json:"user_id"`
+		UserEmail string `json:"user_email"`
+	}
+	if err := decodePayload(m, &req); err != nil || req.CardID == "" || req.UserID == "" || req.UserEmail == "" {
+		return "", rejectError("card_id, user_id and user_email required")
+	}
+
+	var a assigneeDTO
+	err := p.tx.QueryRowContext(p.ctx,
+		`INSERT INTO card_assigned_users (id, card_id, user_id, user_email)
+		 VALUES (COALESCE(NULLIF(?, ''), lower(hex(randomblob(16)))), ?, ?, ?)
+		 RETURNING id, card_id, user_id, user_email`,
+		m.EntityID, req.CardID, req.UserID, req.UserEmail,
+	).Scan(&a.ID, &a.CardID, &a.UserID, &a.UserEmail)
+	if err != nil {
+		return "", rejectError("insert failed")
+	}
+	a.RowVersion = p.version
+	return a.ID, p.log("card_assigned_users", a.ID, "INSERT", a)
+}
+
+func pushInsertApprover(p *pushTx, m mutation) (string, error) {
+	var req struct {
+		CardID    string `json:"card_id"`
+		UserID    string `json:"user_id"`
+		UserEmail string `json:"user_email"`
+	}
+	if err := decodePayload(m, &req); err != nil || req.CardID == "" || req.UserID == "" || req.UserEmail == "" {
+		return "", rejectError("card_id, user_id and user_email required")
+	}
+
+	var a approverDTO
+	err := p.tx.QueryRowContext(p.ctx,
+		`INSERT INTO card_approvers (id, card_id, user_id, user_email)
+		 VALUES (COALESCE(NULLIF(?, ''), lower(hex(randomblob(16)))), ?, ?, ?)
+		 RETURNING id, card_id, user_id, user_email, status, decided_at`,
+		m.EntityID, req.CardID, req.UserID, req.UserEmail,
+	).Scan(&a.ID, &a.CardID, &a.UserID, &a.UserEmail, &a.Status, &a.DecidedAt)
+	if err != nil {
+		return "", rejectError("insert failed")
+	}
+
+	before, err := selectCard(p.ctx, p.tx, req.CardID)
+	if err != nil {
+		return "", rejectError("card not found")
+	}
+
+	// When approvers are added, card goes to pending
+	if _, err := p.tx.ExecContext(p.ctx, "UPDATE kanban_cards SET approval_status = 'pending' WHERE id = ?", req.CardID); err != nil {
+		return "", err
+	}
+
+	a.RowVersion = p.version
+	if err := p.log("card_approvers", a.ID, "INSERT", a); err != nil {
+		return "", err
+	}
+	return a.ID, syncCardUpdate(p.tx, p.ctx, before, p.version)
+}
+
+func pushDecideApproval(p *pushTx, m mutation) (string, error) {
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := decodePayload(m, &req); err != nil || (req.Status != "approved" && req.Status != "rejected") {
+		return "", rejectError("status must be approved or rejected")
+	}
+
+	var cardID, approverUserID string
+	err := p.tx.QueryRowContext(p.ctx,
+		"SELECT card_id, user_id FROM card_approvers WHERE id = ?", m.EntityID,
+	).Scan(&cardID, &approverUserID)
+	if err != nil {
+		return "", rejectError("approver not found")
+	}
+	if approverUserID != p.userID {
+		return "", rejectError("you can only decide your own approval")
+	}
+
+	before, err := selectCard(p.ctx, p.tx, cardID)
+	if err != nil {
+		return "", rejectError("card not found")
+	}
+
+	decidedAt := time.Now().UTC().Format(time.RFC3339)
+	if _, err := p.tx.ExecContext(p.ctx,
+		"UPDATE card_approvers SET status = ?, decided_at = ? WHERE id = ?",
+		req.Status, decidedAt, m.EntityID,
+	); err != nil {
+		return "", err
+	}
+
+	if err := recalcApprovalStatus(p.tx, p.ctx, cardID); err != nil {
+		return "", err
+	}
+
+	var a approverDTO
+	err = p.tx.QueryRowContext(p.ctx,
+		"SELECT id, card_id, user_id, user_email, status, decided_at FROM card_approvers WHERE id = ?",
+		m.EntityID,
+	).Scan(&a.ID, &a.CardID, &a.UserID, &a.UserEmail, &a.Status, &a.DecidedAt)
+	if err != nil {
+		return "", err
+	}
+
+	a.RowVersion = p.version
+	if err := p.log("card_approvers", a.ID, "UPDATE", a); err != nil {
+		return "", err
+	}
+	return a.ID, syncCardUpdate(p.tx, p.ctx, before, p.version)
+}
+
+func pushDeleteApprover(p *pushTx, m mutation) (string, error) {
+	var cardID string
+	if err := p.tx.QueryRowContext(p.ctx, "SELECT card_id FROM card_approvers WHERE id = ?", m.EntityID).Scan(&cardID); err != nil {
+		return "", rejectError("approver not found")
+	}
+	before, err := selectCard(p.ctx, p.tx, cardID)
+	if err != nil {
+		return "", rejectError("card not found")
+	}
+	if err := deleteRow(p, "card_approvers", m.EntityID, "approver not found"); err != nil {
+		return "", err
+	}
+
+	if err := recalcApprovalStatus(p.tx, p.ctx, cardID); err != nil {
+		return "", err
+	}
+
+	if err := p.log("card_approvers", m.EntityID, "DELETE", nil); err != nil {
+		return "", err
+	}
+	return m.EntityID, syncCardUpdate(p.tx, p.ctx, before, p.version)
+}
+
+func pushInsertSession(p *pushTx, m mutation) (string, error) {
+	var req struct {
+		CardID string `json:"card_id"`
+		Name   string `json:"name"`
+	}
+	if err := decodePayload(m, &req); err != nil || req.Name == "" || req.CardID == "" {
+		return "", rejectError("card_id and name required")
+	}
+
+	var pos int
+	if err := p.tx.QueryRowContext(p.ctx, "SELECT COUNT(*) FROM card_sessions WHERE card_id = ?", req.CardID).Scan(&pos); err != nil {
+		return "", err
+	}
+
+	var s sessionDTO
+	err := p.tx.QueryRowContext(p.ctx,
+		`INSERT INTO card_sessions (id, card_id, name, position)
+		 VALUES (COALESCE(NULLIF(?, ''), lower(hex(randomblob(16)))), ?, ?, ?)
+		 RETURNING id, card_id, name, position`,
+		m.EntityID, req.CardID, req.Name, pos,
+	).Scan(&s.ID, &s.CardID, &s.Name, &s.Position)
+	if err != nil {
+		return "", rejectError("insert failed")
+	}
+	s.RowVersion = p.version
+	return s.ID, p.log("card_sessions", s.ID, "INSERT", s)
+}
+
+// pushDeleteBy returns an applier that deletes a row by id from a table whose
+// deletion has no side effects.
+func pushDeleteBy(table, notFound string) applyFunc {
+	return func(p *pushTx, m mutation) (string, error) {
+		if err := deleteRow(p, table, m.EntityID, notFound); err != nil {
+			return "", err
+		}
+		return m.EntityID, p.log(table, m.EntityID, "DELETE", nil)
+	}
+}
+
+// deleteRow deletes a row by id. table is always a constant from pushAppliers.
+func deleteRow(p *pushTx, table, id, notFound string) error {
+	result, err := p.tx.ExecContext(p.ctx, "DELETE FROM "+table+" WHERE id = ?", id)
+	if err != nil {
+		return rejectError("delete failed")
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return rejectError(notFound)
+	}
+	return nil
+}
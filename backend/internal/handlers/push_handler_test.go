@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/ouroboros/backend/internal/auth"
+)
+
+// push sends one batch through PushMutations and returns the response.
+func (e *testEnv) push(role auth.Role, mutations ...mutation) pushResponse {
+	e.t.Helper()
+	rec := e.serve(PushMutations(e.tm, e.hub), role, http.MethodPost, "/api/sync/push", map[string]any{"mutations": mutations})
+	if rec.Code != http.StatusOK {
+		e.t.Fatalf("push: status %d: %s", rec.Code, rec.Body)
+	}
+	res := decode[pushResponse](e.t, rec)
+	if len(res.Results) != len(mutations) {
+		e.t.Fatalf("push: %d results for %d mutations", len(res.Results), len(mutations))
+	}
+	return res
+}
+
+func payload(v any) json.RawMessage {
+	b, _ := json.Marshal(v)
+	return b
+}
+
+// convert re-decodes a value that came out of a JSON response as T.
+func convert[T any](t *testing.T, v any) T {
+	t.Helper()
+	b, _ := json.Marshal(v)
+	var out T
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatalf("convert %s: %v", b, err)
+	}
+	return out
+}
+
+func insertProject(id, entityID, name string) mutation {
+	return mutation{ID: id, Table: "projects", EntityID: entityID, Operation: "INSERT", Payload: payload(map[string]any{"name": name})}
+}
+
+func TestPushIdempotent(t *testing.T) {
+	e := newTestEnv(t)
+	m := insertProject("m1", "p1", "Alpha")
+
+	first := e.push(auth.RoleMember, m)
+	if r := first.Results[0]; r.Status != "accepted" || r.EntityID != "p1" || r.Duplicate || r.Version != first.Version {
+		t.Fatalf("first push: %+v", r)
+	}
+	logged := e.queryInt("SELECT COUNT(*) FROM sync_log")
+
+	again := e.push(auth.RoleMember, m)
+	if r := again.Results[0]; r.Status != "accepted" || !r.Duplicate || r.EntityID != "p1" || r.Version != first.Version {
+		t.Errorf("re-push: %+v", r)
+	}
+	if again.Version != 0 {
+		t.Errorf("a batch of duplicates allocated version %d", again.Version)
+	}
+	if n := e.queryInt("SELECT COUNT(*) FROM projects"); n != 1 {
+		t.Errorf("%d projects after a re-push", n)
+	}
+	if n := e.queryInt("SELECT COUNT(*) FROM sync_log"); n != logged {
+		t.Errorf("re-push logged %d entries", n-logged)
+	}
+
+	// A duplicate in a batch with new work is still not applied twice
+	mixed := e.push(auth.RoleMember, m, insertProject("m2", "p2", "Beta"))
+	if !mixed.Results[0].Duplicate || mixed.Results[1].Status != "accepted" || mixed.Version <= first.Version {
+		t.Errorf("mixed batch: %+v", mixed)
+	}
+}
+
+// TestPushSavepoints checks that a rejected mutation is rolled back alone and
+// the accepted ones of the batch share one version.
+func TestPushSavepoints(t *testing.T) {
+	e := newTestEnv(t)
+	res := e.push(auth.RoleMember,
+		insertProject("m1", "p1", "Alpha"),
+		mutation{ID: "m2", Table: "kanban_cards", EntityID: "c1", Operation: "INSERT",
+			Payload: payload(map[string]any{"project_id": "p1"})}, // no title
+		mutation{ID: "m3", Table: "kanban_cards", EntityID: "c2", Operation: "INSERT",
+			Payload: payload(map[string]any{"project_id": "p1", "title": "Card"})},
+		insertProject("m4", "p1", "Alpha again"), // duplicate primary key
+		mutation{ID: "m5", Table: "products", Operation: "INSERT", Payload: payload(map[string]any{"name": "x"})},
+		mutation{ID: "", Table: "projects", Operation: "INSERT", Payload: payload(map[string]any{"name": "x"})},
+		mutation{ID: "m7", Table: "sync_log", Operation: "DELETE"},
+	)
+	want := []struct{ status, err string }{
+		{"accepted", ""},
+		{"rejected", "project_id and title required"},
+		{"accepted", ""},
+		{"rejected", "insert failed"},
+		{"rejected", "missing permission " + string(auth.PermWriteProducts)},
+		{"rejected", "mutation id required"},
+		{"rejected", "unsupported table or operation"},
+	}
+	for i, w := range want {
+		if r := res.Results[i]; r.Status != w.status || r.Error != w.err {
+			t.Errorf("mutation %d: %s %q, want %s %q", i+1, r.Status, r.Error, w.status, w.err)
+		}
+	}
+	if res.Results[0].Version != res.Version || res.Results[2].Version != res.Version {
+		t.Errorf("accepted mutations at versions %d and %d, batch at %d", res.Results[0].Version, res.Results[2].Version, res.Version)
+	}
+	if n := e.queryInt("SELECT COUNT(*) FROM kanban_cards"); n != 1 {
+		t.Errorf("%d cards, want 1", n)
+	}
+	if name := e.queryInt("SELECT COUNT(*) FROM projects WHERE name = 'Alpha'"); name != 1 {
+		t.Errorf("the rejected insert changed the project")
+	}
+	if n := e.queryInt("SELECT COUNT(*) FROM sync_log WHERE version = ?", res.Version); n != 2 {
+		t.Errorf("%d sync_log entries at the batch version, want 2", n)
+	}
+	// Rejected mutations are not remembered, so a fixed retry can go through
+	if n := e.queryInt("SELECT COUNT(*) FROM sync_mutations"); n != 2 {
+		t.Errorf("%d mutations remembered, want 2", n)
+	}
+}
+
+// TestDeleteProjectCascade deletes a project with something in every child
+// table, by push and by REST, and checks each row is gone and logged.
+func TestDeleteProjectCascade(t *testing.T) {
+	e := newTestEnv(t)
+	seed := func(p string) {
+		e.exec("INSERT INTO projects (id, name) VALUES (?, 'p')", p)
+		e.exec("INSERT INTO kanban_columns (id, project_id, name) VALUES (?, ?, 'todo')", p+"-col", p)
+		e.exec("INSERT INTO kanban_cards (id, project_id, title) VALUES (?, ?, 'c')", p+"-card", p)
+		e.exec("INSERT INTO card_tags (id, card_id, name) VALUES (?, ?, 't')", p+"-tag", p+"-card")
+		e.exec("INSERT INTO card_assigned_users (id, card_id, user_id, user_email) VALUES (?, ?, 'u', 'u@x')", p+"-asg", p+"-card")
+		e.exec("INSERT INTO card_approvers (id, card_id, user_id, user_email) VALUES (?, ?, 'u', 'u@x')", p+"-apr", p+"-card")
+		e.exec("INSERT INTO card_sessions (id, card_id, name) VALUES (?, ?, 's')", p+"-ses", p+"-card")
+	}
+	children := []string{"kanban_columns", "kanban_cards", "card_tags", "card_assigned_users", "card_approvers", "card_sessions"}
+	check := func(p string, version int64) {
+		t.Helper()
+		for _, table := range append([]string{"projects"}, children...) {
+			id := p + map[string]string{"projects": "", "kanban_columns": "-col", "kanban_cards": "-card", "card_tags": "-tag",
+				"card_assigned_users": "-asg", "card_approvers": "-apr", "card_sessions": "-ses"}[table]
+			if n := e.queryInt("SELECT COUNT(*) FROM "+table+" WHERE id = ?", id); n != 0 {
+				t.Errorf("%s %s left behind", table, id)
+			}
+			if n := e.queryInt("SELECT COUNT(*) FROM sync_log WHERE table_name = ? AND entity_id = ? AND operation = 'DELETE' AND version = ?",
+				table, id, version); n != 1 {
+				t.Errorf("%s %s: %d DELETE entries at version %d", table, id, n, version)
+			}
+		}
+	}
+
+	seed("a")
+	seed("b") // untouched by deleting a
+	res := e.push(auth.RoleAdmin, mutation{ID: "m1", Table: "projects", EntityID: "a", Operation: "DELETE"})
+	if r := res.Results[0]; r.Status != "accepted" {
+		t.Fatalf("push delete: %+v", r)
+	}
+	check("a", res.Version)
+	if n := e.queryInt("SELECT COUNT(*) FROM kanban_cards WHERE project_id = 'b'"); n != 1 {
+		t.Errorf("deleting a removed b's cards")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("DELETE /api/projects/{id}", DeleteProject(e.tm, e.hub))
+	rec := e.serve(mux, auth.RoleAdmin, http.MethodDelete, "/api/projects/b", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("REST delete: status %d: %s", rec.Code, rec.Body)
+	}
+	check("b", e.queryInt("SELECT value FROM sync_state WHERE key = 'version'"))
+
+	if r := e.push(auth.RoleAdmin, mutation{ID: "m2", Table: "projects", EntityID: "a", Operation: "DELETE"}).Results[0]; r.Error != "project not found" {
+		t.Errorf("deleting a deleted project: %+v", r)
+	}
+	if r := e.push(auth.RoleMember, mutation{ID: "m3", Table: "projects", EntityID: "b", Operation: "DELETE"}).Results[0]; r.Error != "missing permission "+string(auth.PermDeleteProjects) {
+		t.Errorf("member deleting a project: %+v", r)
+	}
+}
+
+// TestPushConflict checks that an update based on a stale row version is
+// reported with the current row instead of being applied.
+func TestPushConflict(t *testing.T) {
+	e := newTestEnv(t)
+	v1 := e.push(auth.RoleMember,
+		insertProject("m1", "p1", "Alpha"),
+		mutation{ID: "m2", Table: "kanban_columns", EntityID: "col1", Operation: "INSERT",
+			Payload: payload(map[string]any{"project_id": "p1", "name": "todo"})},
+		mutation{ID: "m3", Table: "kanban_cards", EntityID: "c1", Operation: "INSERT",
+			Payload: payload(map[string]any{"project_id": "p1", "title": "Draft"})},
+	).Version
+	update := func(id, table, entityID string, base int64, fields map[string]any) mutation {
+		return mutation{ID: id, Table: table, EntityID: entityID, Operation: "UPDATE", BaseVersion: &base, Payload: payload(fields)}
+	}
+
+	res := e.push(auth.RoleMember,
+		update("m4", "kanban_cards", "c1", v1, map[string]any{"title": "First"}),
+		update("m5", "kanban_columns", "col1", v1, map[string]any{"name": "doing"}),
+	)
+	v2 := res.Version
+	for i, r := range res.Results {
+		if r.Status != "accepted" {
+			t.Fatalf("update %d at the current version: %+v", i+1, r)
+		}
+	}
+	if got := e.queryInt("SELECT row_version FROM kanban_cards WHERE id = 'c1'"); got != v2 {
+		t.Errorf("card row_version = %d, want %d", got, v2)
+	}
+	if got := e.queryInt("SELECT row_version FROM kanban_columns WHERE id = 'col1'"); got != v2 {
+		t.Errorf("column row_version = %d, want %d", got, v2)
+	}
+
+	res = e.push(auth.RoleMember,
+		update("m6", "kanban_cards", "c1", v1, map[string]any{"title": "Lost"}),
+		update("m7", "kanban_columns", "col1", v1, map[string]any{"name": "lost"}),
+		update("m8", "kanban_cards", "missing", v1, map[string]any{"title": "x"}),
+	)
+	if r := res.Results[0]; r.Status != "conflict" {
+		t.Errorf("stale card update: %+v", r)
+	} else if c := convert[card](t, r.Current); c.Title != "First" || c.RowVersion != v2 {
+		t.Errorf("stale card update: current = %+v", c)
+	}
+	if r := res.Results[1]; r.Status != "conflict" {
+		t.Errorf("stale column update: %+v", r)
+	} else if c := convert[column](t, r.Current); c.Name != "doing" || c.RowVersion != v2 {
+		t.Errorf("stale column update: current = %+v", c)
+	}
+	if r := res.Results[2]; r.Status != "rejected" || r.Error != "card not found" {
+		t.Errorf("update of a missing card: %+v", r)
+	}
+	if n := e.queryInt("SELECT COUNT(*) FROM kanban_cards WHERE title = 'Lost'") + e.queryInt("SELECT COUNT(*) FROM kanban_columns WHERE name = 'lost'"); n != 0 {
+		t.Error("a conflicting update was applied")
+	}
+	// A conflict is not remembered: the client may retry the same mutation
+	// after rebasing it
+	if n := e.queryInt("SELECT COUNT(*) FROM sync_mutations WHERE id = 'm6'"); n != 0 {
+		t.Error("the conflicting mutation was remembered")
+	}
+}
+
+// TestPushRejectedBatch checks that a batch with nothing accepted allocates no
+// version.
+func TestPushRejectedBatch(t *testing.T) {
+	e := newTestEnv(t)
+	before := e.push(auth.RoleMember, insertProject("m1", "p1", "Alpha")).Version
+
+	res := e.push(auth.RoleMember,
+		mutation{ID: "m2", Table: "kanban_cards", EntityID: "c1", Operation: "INSERT",
+			Payload: payload(map[string]any{"project_id": "p1"})}, // no title
+		insertProject("m3", "p1", "Alpha again"), // duplicate primary key
+	)
+	if res.Version != 0 || res.Results[0].Status != "rejected" || res.Results[1].Status != "rejected" {
+		t.Errorf("rejected batch: %+v", res)
+	}
+	if v := e.queryInt("SELECT value FROM sync_state WHERE key = 'version'"); v != before {
+		t.Errorf("version %d after a rejected batch, want %d", v, before)
+	}
+
+	// A rejection ahead of the first accepted mutation does not skip a version
+	res = e.push(auth.RoleMember, insertProject("m4", "p1", "dup"), insertProject("m5", "p2", "Beta"))
+	if res.Version != before+1 || res.Results[1].Version != before+1 {
+		t.Errorf("batch after a rejection at version %d, want %d", res.Version, before+1)
+	}
+	if n := e.queryInt("SELECT COUNT(*) FROM sync_log WHERE version = ?", res.Version); n != 1 {
+		t.Errorf("%d sync_log entries at the batch version, want 1", n)
+	}
+}
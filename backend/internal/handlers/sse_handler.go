@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ouroboros/backend/internal/auth"
+	"github.com/ouroboros/backend/internal/sync"
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+// maxSSEReplay caps how many entries are streamed to catch a client up;
+// further behind than that it is told to page through GET /api/sync.
+const maxSSEReplay = 1000
+
+// sseHeartbeat is how often an idle stream gets a comment line, well under
+// the idle timeouts of common proxies and load balancers.
+const sseHeartbeat = 15 * time.Second
+
+// subscribe registers a stream with the hub, replying 429 or 503 when it
+// cannot be opened.
+func subscribe(w http.ResponseWriter, r *http.Request, hub *sync.Hub) (*sync.Subscription, func(), bool) {
+	sub, unsub, err := hub.Subscribe(auth.TenantFromCtx(r.Context()), auth.UserFromCtx(r.Context()))
+	switch {
+	case errors.Is(err, sync.ErrTooManyStreams):
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, `{"error":"too many open streams"}`, http.StatusTooManyRequests)
+		return nil, nil, false
+	case err != nil:
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, `{"error":"server is shutting down"}`, http.StatusServiceUnavailable)
+		return nil, nil, false
+	}
+	return sub, unsub, true
+}
+
+// reconnectDelay spreads clients' reconnects after a shutdown over a few
+// seconds so the next node is not hit by all of them at once.
+func reconnectDelay() time.Duration {
+	return time.Second + rand.N(4*time.Second)
+}
+
+// SSEHandler handles GET /sse/events?deltas=1 — Server-Sent Events for real-time sync.
+// Every event carries the version as its id. By default the data is just the
+// version; with deltas=1 it is {"version","entries"} with that version's
+// sync_log entries, so the client need not call GET /api/sync.
+// With deltas=1, the tables and project_id parameters of GET /api/sync filter
+// the entries, which are adapted to the client's protocol and schema as there.
+// Versions left without entries are skipped, and a "cursor" event with the
+// stream's position is sent along with the heartbeat instead.
+// A Last-Event-ID header (or last_event_id query parameter) resumes after that
+// version by replaying what was missed. A "resync" event means events were
+// lost and the client must catch up through GET /api/sync. A "reconnect" event
+// means the server is shutting down; the stream ends right after it. Idle
+// streams get a comment every sseHeartbeat.
+func SSEHandler(tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenantID := auth.TenantFromCtx(r.Context())
+		db, release, err := tm.AcquireRead(tenantID)
+		if err != nil {
+			dbUnavailable(w, err)
+			return
+		}
+		defer release()
+
+		filter, err := parseSyncFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, `{"error":"invalid tables"}`, http.StatusBadRequest)
+			return
+		}
+		client, ok := negotiate(w, r, db, true)
+		if !ok {
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		// Subscribe before reading the current version so nothing committed
+		// in between goes unnoticed.
+		sub, unsub, ok := subscribe(w, r, hub)
+		if !ok {
+			return
+		}
+		defer unsub()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		// Initial comment forces proxies (Vite, Nginx) to forward the response
+		w.Write([]byte(":ok\n\n"))
+		flusher.Flush()
+
+		ctx := r.Context()
+		s := &sseStream{w: w, flusher: flusher, ctx: ctx, db: db, deltas: r.URL.Query().Get("deltas") == "1", filter: filter, client: client}
+		current, err := sync.CurrentVersion(ctx, db)
+		if err != nil {
+			return
+		}
+		if lastID, ok := lastEventID(r); ok {
+			floor, err := sync.Floor(ctx, db)
+			if err != nil {
+				return
+			}
+			if lastID < floor {
+				s.resync("compacted", current)
+			} else {
+				s.last, s.sent = lastID, lastID
+				if err := s.advance(current); err != nil {
+					return
+				}
+			}
+		} else {
+			s.last, s.sent = current, current
+		}
+
+		heartbeat := time.NewTicker(sseHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-heartbeat.C:
+				if s.deltas {
+					s.sendCursor()
+				}
+				w.Write([]byte(":hb\n\n"))
+				flusher.Flush()
+			case version, ok := <-sub.C:
+				if !ok {
+					s.reconnect()
+					return
+				}
+				if sub.Lagged() {
+					s.resync("lagged", version)
+					continue
+				}
+				if err := s.advance(version); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// lastEventID returns the version a reconnecting client has already seen.
+func lastEventID(r *http.Request) (int64, bool) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("last_event_id")
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	return id, err == nil
+}
+
+// sseStream writes the events of one SSE connection and tracks the last
+// version the client has been sent.
+type sseStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	ctx     context.Context
+	db      *sql.DB
+	deltas  bool
+	filter  syncFilter
+	client  syncClient
+	last    int64 // highest version the client is complete up to
+	sent    int64 // highest event id written
+}
+
+func (s *sseStream) send(id int64, event string, data []byte) {
+	if id > 0 {
+		fmt.Fprintf(s.w, "id: %d\n", id)
+		s.sent = id
+	}
+	if event != "" {
+		fmt.Fprintf(s.w, "event: %s\n", event)
+	}
+	fmt.Fprintf(s.w, "data: %s\n\n", data)
+	s.flusher.Flush()
+}
+
+// resync tells the client it missed events. It carries no id, so a browser
+// EventSource does not resume past entries the client never applied.
+func (s *sseStream) resync(reason string, version int64) {
+	data, _ := json.Marshal(map[string]any{"reason": reason, "version": version})
+	s.send(0, "resync", data)
+	s.last = version
+}
+
+// reconnect tells the client the server is going away and when to come back.
+// The retry field sets the delay for a browser EventSource as well.
+func (s *sseStream) reconnect() {
+	delay := reconnectDelay().Milliseconds()
+	fmt.Fprintf(s.w, "retry: %d\n", delay)
+	data, _ := json.Marshal(map[string]any{"retry_ms": delay})
+	s.send(0, "reconnect", data)
+}
+
+// advance brings the client up to version.
+func (s *sseStream) advance(version int64) error {
+	if version <= s.last {
+		return nil
+	}
+	if !s.deltas {
+		s.send(version, "", []byte(strconv.FormatInt(version, 10)))
+		s.last = version
+		return nil
+	}
+
+	entries, err := readEntries(s.ctx, s.db, s.filter, s.last, version, maxSSEReplay+1)
+	if err != nil {
+		return err
+	}
+	if len(entries) > maxSSEReplay {
+		s.resync("behind", version)
+		return nil
+	}
+
+	// One event per version, so an event id is always a complete version.
+	for _, group := range groupByVersion(entries) {
+		v := group[0].Version
+		if group, err = s.client.adapt(s.ctx, s.db, group); err != nil {
+			return err
+		}
+		if len(group) == 0 {
+			continue // nothing this client understands; the cursor event covers it
+		}
+		data, _ := json.Marshal(map[string]any{"version": v, "entries": group})
+		s.send(v, "delta", data)
+	}
+	s.last = version
+	return nil
+}
+
+// sendCursor tells the client that it is complete up to s.last even though
+// the versions since its last event had no entries for it.
+func (s *sseStream) sendCursor() {
+	if s.sent >= s.last {
+		return
+	}
+	data, _ := json.Marshal(map[string]any{"version": s.last})
+	s.send(s.last, "cursor", data)
+}
+
+// readEntries returns up to limit sync_log entries matching f with after < version <= upto.
+func readEntries(ctx context.Context, db *sql.DB, f syncFilter, after, upto int64, limit int) ([]syncEntry, error) {
+	cond, args := f.where()
+	rows, err := db.QueryContext(ctx,
+		`SELECT id, table_name, entity_id, operation, payload, version FROM sync_log
+		 WHERE version > ? AND version <= ?`+cond+`
+		 ORDER BY version ASC, id ASC LIMIT ?`,
+		append(append([]any{after, upto}, args...), limit)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []syncEntry
+	for rows.Next() {
+		var e syncEntry
+		if err := rows.Scan(&e.ID, &e.Table, &e.EntityID, &e.Operation, &e.Payload, &e.Version); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// groupByVersion splits entries ordered by version into one slice per version.
+func groupByVersion(entries []syncEntry) [][]syncEntry {
+	var groups [][]syncEntry
+	for start := 0; start < len(entries); {
+		end := start
+		for end < len(entries) && entries[end].Version == entries[start].Version {
+			end++
+		}
+		groups = append(groups, entries[start:end])
+		start = end
+	}
+	return groups
+}
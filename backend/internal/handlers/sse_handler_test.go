@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ouroboros/backend/internal/auth"
+	"github.com/ouroboros/backend/internal/migrations"
+)
+
+type sseEvent struct {
+	id, event, data string
+}
+
+// asUser serves h over HTTP as user u1 of the test tenant.
+func (e *testEnv) asUser(h http.Handler) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), auth.TenantKey, testTenant)
+		ctx = context.WithValue(ctx, auth.UserKey, "u1")
+		ctx = context.WithValue(ctx, auth.RoleKey, auth.RoleViewer)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	}))
+	e.t.Cleanup(srv.Close)
+	return srv
+}
+
+// readEvents opens an SSE stream and returns its first n events.
+func (e *testEnv) readEvents(url, lastEventID string, n int) []sseEvent {
+	e.t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		e.t.Fatalf("status %d", res.StatusCode)
+	}
+
+	var events []sseEvent
+	var ev sseEvent
+	sc := bufio.NewScanner(res.Body)
+	sc.Buffer(nil, 1<<20)
+	for len(events) < n && sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if ev != (sseEvent{}) {
+				events = append(events, ev)
+			}
+			ev = sseEvent{}
+		case strings.HasPrefix(line, "id: "):
+			ev.id = line[len("id: "):]
+		case strings.HasPrefix(line, "event: "):
+			ev.event = line[len("event: "):]
+		case strings.HasPrefix(line, "data: "):
+			ev.data = line[len("data: "):]
+		}
+	}
+	if len(events) < n {
+		e.t.Fatalf("stream ended after %d events: %v", len(events), sc.Err())
+	}
+	return events
+}
+
+// logProducts writes one product entry at each of the given versions and
+// moves the version counter to the last.
+func (e *testEnv) logProducts(versions ...int64) {
+	e.t.Helper()
+	for _, v := range versions {
+		e.exec(`INSERT INTO sync_log (table_name, entity_id, operation, payload, version)
+			VALUES ('products', ?, 'INSERT', '{}', ?)`, fmt.Sprint("p", v), v)
+	}
+	e.exec(`INSERT INTO sync_state (key, value) VALUES ('version', ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value`, versions[len(versions)-1])
+}
+
+func TestSSEReplay(t *testing.T) {
+	e := newTestEnv(t)
+	e.logProducts(1, 2, 3)
+	srv := e.asUser(SSEHandler(e.tm, e.hub))
+
+	got := e.readEvents(srv.URL+"?deltas=1", "1", 2)
+	for i, want := range []int64{2, 3} {
+		var data struct {
+			Version int64       `json:"version"`
+			Entries []syncEntry `json:"entries"`
+		}
+		json.Unmarshal([]byte(got[i].data), &data)
+		if got[i].event != "delta" || got[i].id != fmt.Sprint(want) || data.Version != want ||
+			len(data.Entries) != 1 || data.Entries[0].EntityID != fmt.Sprint("p", want) {
+			t.Errorf("event %d = %+v, want the delta of version %d", i, got[i], want)
+		}
+	}
+
+	// Without deltas the events carry just the version
+	if got := e.readEvents(srv.URL, "2", 1); got[0].id != "3" || got[0].data != "3" || got[0].event != "" {
+		t.Errorf("version event = %+v", got[0])
+	}
+}
+
+func TestSSEResync(t *testing.T) {
+	e := newTestEnv(t)
+	versions := make([]int64, maxSSEReplay+1)
+	for i := range versions {
+		versions[i] = int64(i + 3)
+	}
+	e.logProducts(versions...)
+	e.exec("INSERT INTO sync_state (key, value) VALUES ('compacted_version', 2)")
+	last := versions[len(versions)-1]
+	srv := e.asUser(SSEHandler(e.tm, e.hub))
+
+	tests := []struct {
+		name, lastEventID, reason string
+	}{
+		{"below the compaction floor", "1", "compacted"},
+		{"too far behind to replay", "2", "behind"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := e.readEvents(srv.URL+"?deltas=1", tt.lastEventID, 1)[0]
+			var data struct {
+				Reason  string `json:"reason"`
+				Version int64  `json:"version"`
+			}
+			json.Unmarshal([]byte(ev.data), &data)
+			if ev.event != "resync" || ev.id != "" || data.Reason != tt.reason || data.Version != last {
+				t.Errorf("event = %+v, want resync %q at %d without an id", ev, tt.reason, last)
+			}
+		})
+	}
+
+	// Just within the limit, the replay goes through
+	if ev := e.readEvents(srv.URL+"?deltas=1", "3", 1)[0]; ev.event != "delta" || ev.id != "4" {
+		t.Errorf("first event of a full replay = %+v", ev)
+	}
+}
+
+// TestSSECursor checks that versions whose entries are all filtered out send
+// no delta, and that the heartbeat then reports the position in a cursor event.
+func TestSSECursor(t *testing.T) {
+	e := newTestEnv(t)
+	e.logProducts(1, 2)
+	e.push(auth.RoleMember, insertProject("m1", "p1", "Alpha")) // version 3
+	m, err := migrations.Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := parseSyncFilter(url.Values{"tables": {"projects"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, release, err := e.tm.AcquireRead(testTenant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	rec := httptest.NewRecorder()
+	s := &sseStream{w: rec, flusher: rec, ctx: context.Background(), db: db, deltas: true, filter: filter,
+		client: syncClient{protocol: syncProtocol, schema: m.Version, replica: m.Version, latest: m.Version}}
+	if err := s.advance(2); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Body.Len() != 0 || s.last != 2 || s.sent != 0 {
+		t.Fatalf("filtered versions sent %q (last %d, sent %d)", rec.Body, s.last, s.sent)
+	}
+	s.sendCursor()
+	if want := "id: 2\nevent: cursor\ndata: {\"version\":2}\n\n"; rec.Body.String() != want {
+		t.Errorf("cursor = %q, want %q", rec.Body, want)
+	}
+
+	rec.Body.Reset()
+	if err := s.advance(3); err != nil {
+		t.Fatal(err)
+	}
+	s.sendCursor() // nothing to report: the delta has the position
+	if body := rec.Body.String(); !strings.HasPrefix(body, "id: 3\nevent: delta\n") || strings.Contains(body, "cursor") {
+		t.Errorf("after a delta: %q", body)
+	}
+}
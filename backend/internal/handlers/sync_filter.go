@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// syncRelated lists the tables whose rows reference a table's rows. They are
+// synced along with it so a filtered replica never holds dangling references.
+var syncRelated = map[string][]string{
+	"kanban_cards": {"card_tags", "card_assigned_users", "card_approvers", "card_sessions"},
+	"os_orders":    {"os_items"},
+	"os_items":     {"products"},
+}
+
+// syncFilter narrows a sync feed to some tables and/or one project. Entries
+// of tenant-wide tables (products, users) carry no project and pass the
+// project filter. The zero value matches everything.
+type syncFilter struct {
+	tables    []string
+	projectID string
+}
+
+// parseSyncFilter reads the tables (comma-separated) and project_id query
+// parameters, adding the related tables of every requested one.
+func parseSyncFilter(q url.Values) (syncFilter, error) {
+	f := syncFilter{projectID: q.Get("project_id")}
+	if v := q.Get("tables"); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			if !isSyncTable(t) {
+				return syncFilter{}, fmt.Errorf("unknown table %q", t)
+			}
+			f.addTable(t)
+		}
+	}
+	return f, nil
+}
+
+func (f *syncFilter) addTable(t string) {
+	if slices.Contains(f.tables, t) {
+		return
+	}
+	f.tables = append(f.tables, t)
+	for _, rel := range syncRelated[t] {
+		f.addTable(rel)
+	}
+}
+
+func isSyncTable(t string) bool {
+	if t == "users" {
+		return true
+	}
+	for _, st := range snapshotTables {
+		if st.name == t {
+			return true
+		}
+	}
+	return false
+}
+
+// includes reports whether rows of table t pass the table filter.
+func (f syncFilter) includes(t string) bool {
+	return len(f.tables) == 0 || slices.Contains(f.tables, t)
+}
+
+// where returns an SQL condition on sync_log, starting with " AND", and its arguments.
+func (f syncFilter) where() (string, []any) {
+	var sb strings.Builder
+	var args []any
+	if len(f.tables) > 0 {
+		sb.WriteString(" AND table_name IN (?" + strings.Repeat(", ?", len(f.tables)-1) + ")")
+		for _, t := range f.tables {
+			args = append(args, t)
+		}
+	}
+	if f.projectID != "" {
+		sb.WriteString(" AND (project_id = ? OR project_id IS NULL)")
+		args = append(args, f.projectID)
+	}
+	return sb.String(), args
+}
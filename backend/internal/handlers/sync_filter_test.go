@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/ouroboros/backend/internal/auth"
+)
+
+func TestParseSyncFilter(t *testing.T) {
+	f, err := parseSyncFilter(url.Values{"tables": {"os_orders,kanban_cards"}, "project_id": {"p1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"os_orders", "os_items", "products", "kanban_cards", "card_tags", "card_assigned_users", "card_approvers", "card_sessions"}
+	if !slices.Equal(f.tables, want) || f.projectID != "p1" {
+		t.Errorf("filter = %+v, want tables %v", f, want)
+	}
+	cond, args := f.where()
+	if wantCond := " AND table_name IN (?, ?, ?, ?, ?, ?, ?, ?) AND (project_id = ? OR project_id IS NULL)"; cond != wantCond {
+		t.Errorf("where = %q, want %q", cond, wantCond)
+	}
+	if len(args) != len(want)+1 || args[len(want)] != "p1" {
+		t.Errorf("args = %v", args)
+	}
+
+	if cond, args := (syncFilter{}).where(); cond != "" || args != nil {
+		t.Errorf("zero filter: %q %v", cond, args)
+	}
+	for _, tables := range []string{"sync_log", "os_orders,", "users"} {
+		_, err := parseSyncFilter(url.Values{"tables": {tables}})
+		if (err == nil) != (tables == "users") {
+			t.Errorf("tables=%s: err = %v", tables, err)
+		}
+	}
+}
+
+// TestSyncProjectFilter checks that the sync_log_project trigger tags every
+// entry of a project, including child rows and payload-less DELETEs, so that a
+// project subscription gets all of them and nothing of other projects.
+func TestSyncProjectFilter(t *testing.T) {
+	e := newTestEnv(t)
+	e.push(auth.RoleAdmin, mutation{ID: "m0", Table: "products", EntityID: "x", Operation: "INSERT",
+		Payload: payload(map[string]any{"name": "Widget", "price": 2})})
+	for _, p := range []string{"p1", "p2"} {
+		r := e.push(auth.RoleMember,
+			insertProject("m-"+p, p, p),
+			mutation{ID: "mc-" + p, Table: "kanban_cards", EntityID: "c-" + p, Operation: "INSERT",
+				Payload: payload(map[string]any{"project_id": p, "title": "Card"})},
+			mutation{ID: "mt-" + p, Table: "card_tags", EntityID: "t-" + p, Operation: "INSERT",
+				Payload: payload(map[string]any{"card_id": "c-" + p, "name": "urgent"})},
+			mutation{ID: "mo-" + p, Table: "os_orders", EntityID: "o-" + p, Operation: "INSERT",
+				Payload: payload(map[string]any{"project_id": p, "items": []map[string]any{{"product_id": "x", "qty": 1}}})},
+			mutation{ID: "md-" + p, Table: "card_tags", EntityID: "t-" + p, Operation: "DELETE"},
+		)
+		for _, res := range r.Results {
+			if res.Status != "accepted" {
+				t.Fatalf("%s: %+v", p, res)
+			}
+		}
+	}
+	// Items have no DELETE mutation; log one as a later change would
+	e.exec(`INSERT INTO sync_log (table_name, entity_id, operation, payload, version)
+		SELECT 'os_items', entity_id, 'DELETE', '{}', (SELECT MAX(version) + 1 FROM sync_log)
+		FROM sync_log WHERE table_name = 'os_items' AND json_extract(payload, '$.order_id') = 'o-p1'`)
+
+	if n := e.queryInt("SELECT COUNT(*) FROM sync_log WHERE project_id IS NULL AND table_name <> 'products'"); n != 0 {
+		t.Errorf("%d project entries left untagged", n)
+	}
+
+	type key struct{ table, op string }
+	count := func(entries []syncEntry) map[key]int {
+		got := map[key]int{}
+		for _, en := range entries {
+			got[key{en.Table, en.Operation}]++
+			if en.Table != "products" && en.EntityID[len(en.EntityID)-2:] == "p2" {
+				t.Errorf("p1 subscription got %+v", en)
+			}
+		}
+		return got
+	}
+	got := count(e.syncAs(0, "project_id=p1"))
+	want := map[key]int{
+		{"products", "INSERT"}:     1,
+		{"projects", "INSERT"}:     1,
+		{"kanban_cards", "INSERT"}: 1,
+		{"card_tags", "INSERT"}:    1,
+		{"card_tags", "DELETE"}:    1,
+		{"os_orders", "INSERT"}:    1,
+		{"os_items", "INSERT"}:     1,
+		{"os_items", "DELETE"}:     1,
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("project p1 entries = %v, want %v", got, want)
+	}
+
+	got = count(e.syncAs(0, "project_id=p1&tables=os_orders"))
+	want = map[key]int{{"products", "INSERT"}: 1, {"os_orders", "INSERT"}: 1, {"os_items", "INSERT"}: 1, {"os_items", "DELETE"}: 1}
+	if !maps.Equal(got, want) {
+		t.Errorf("p1 orders entries = %v, want %v", got, want)
+	}
+
+	if rec := e.serve(GetSync(e.tm), auth.RoleViewer, http.MethodGet, "/api/sync?since=0&tables=sync_log", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown table: status %d, want 400", rec.Code)
+	}
+}
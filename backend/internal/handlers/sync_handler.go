@@ -1,10 +1,16 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/ouroboros/backend/internal/auth"
+	"github.com/ouroboros/backend/internal/migrations"
 	"github.com/ouroboros/backend/internal/sync"
 	"github.com/ouroboros/backend/internal/tenant"
 )
@@ -18,22 +24,107 @@ type syncEntry struct {
 	Version   int64  `json:"version"`
 }
 
-// GetSync handles GET /api/sync?since={version}
-// Returns all sync_log entries with version > since.
+// syncPage is the envelope returned by GetSync.
+// Version is the highest version fully contained in this and all previous
+// pages — the only value a client may persist as its next "since".
+type syncPage struct {
+	Entries    []syncEntry `json:"entries"`
+	Version    int64       `json:"version"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	HasMore    bool        `json:"has_more"`
+}
+
+const (
+	defaultSyncLimit = 1000
+	maxSyncLimit     = 5000
+)
+
+// GetSync handles GET /api/sync?since={version}&limit={n}&cursor={cursor}&tables={t1,t2}&project_id={id}
+// Returns sync_log entries with version > since, ordered by (version, id),
+// at most limit per page. Pages end on a version boundary whenever possible;
+// a single version larger than limit is split, in which case the returned
+// Version stays below it until its last entry has been delivered.
+// When cursor is present it takes precedence over since.
+// tables and project_id restrict the entries (see syncFilter); the last page's
+// Version is still the tenant's current version, so a filtered client keeps
+// its own cursor and never re-reads versions it had no entries in.
+// Entries are adapted to the client's declared protocol and schema (see negotiate).
+// Responds 410 Gone when since is below the compaction floor.
 func GetSync(tm *tenant.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
-		db, err := tm.DB(tenantID)
+		db, release, err := tm.AcquireRead(tenantID)
 		if err != nil {
-			http.Error(w, `{"error":"db error"}`, http.StatusInternalServerError)
+			dbUnavailable(w, err)
+			return
+		}
+		defer release()
+
+		client, ok := negotiate(w, r, db, true)
+		if !ok {
 			return
 		}
 
-		since, _ := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+		q := r.URL.Query()
+		since, _ := strconv.ParseInt(q.Get("since"), 10, 64)
+
+		limit := defaultSyncLimit
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, `{"error":"invalid limit"}`, http.StatusBadRequest)
+				return
+			}
+			limit = min(n, maxSyncLimit)
+		}
 
+		filter, err := parseSyncFilter(q)
+		if err != nil {
+			http.Error(w, `{"error":"invalid tables"}`, http.StatusBadRequest)
+			return
+		}
+
+		// Without a cursor, start after every row of version "since".
+		afterVersion, afterID := since, int64(math.MaxInt64)
+		if c := q.Get("cursor"); c != "" {
+			afterVersion, afterID, err = parseSyncCursor(c)
+			if err != nil {
+				http.Error(w, `{"error":"invalid cursor"}`, http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Entries at or below the compaction floor may have been collapsed away;
+		// a client positioned there has to start over from a snapshot.
+		floor, err := sync.Floor(r.Context(), db)
+		if err != nil {
+			http.Error(w, `{"error":"query failed"}`, http.StatusInternalServerError)
+			return
+		}
+		if afterVersion < floor || (afterVersion == floor && afterID != math.MaxInt64) {
+			writeJSON(w, http.StatusGone, map[string]any{
+				"error":    "since is older than the compaction floor",
+				"floor":    floor,
+				"snapshot": "/api/sync/snapshot",
+			})
+			return
+		}
+
+		// Read the current version first: everything up to it is committed,
+		// so a last page can safely report it even if no entry matched.
+		var upto int64
+		if err := db.QueryRowContext(r.Context(), "SELECT COALESCE(MAX(version), 0) FROM sync_log").Scan(&upto); err != nil {
+			http.Error(w, `{"error":"query failed"}`, http.StatusInternalServerError)
+			return
+		}
+		upto = max(upto, floor)
+
+		cond, args := filter.where()
 		rows, err := db.QueryContext(r.Context(),
-			"SELECT id, table_name, entity_id, operation, payload, version FROM sync_log WHERE version > ? ORDER BY version ASC",
-			since,
+			`SELECT id, table_name, entity_id, operation, payload, version FROM sync_log
+			 WHERE (version > ? OR (version = ? AND id > ?)) AND version <= ?`+cond+`
+			 ORDER BY version ASC, id ASC LIMIT ?`,
+			append(append([]any{afterVersion, afterVersion, afterID, upto}, args...), limit+1)...,
 		)
 		if err != nil {
 			http.Error(w, `{"error":"query failed"}`, http.StatusInternalServerError)
@@ -53,45 +144,229 @@ func GetSync(tm *tenant.Manager) http.HandlerFunc {
 		if entries == nil {
 			entries = []syncEntry{}
 		}
-		writeJSON(w, http.StatusOK, entries)
+
+		page := buildSyncPage(entries, limit, afterVersion)
+		if !page.HasMore {
+			page.Version = max(page.Version, upto)
+		}
+		// Adapt after paging so cursors and versions refer to the stored log.
+		if page.Entries, err = client.adapt(r.Context(), db, page.Entries); err != nil {
+			http.Error(w, `{"error":"query failed"}`, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, page)
 	}
 }
 
-// SSEHandler handles GET /sse/events — Server-Sent Events for real-time sync.
-func SSEHandler(hub *sync.Hub) http.HandlerFunc {
+// buildSyncPage trims a result set of up to limit+1 rows into a page.
+// The extra row only signals that more data exists.
+func buildSyncPage(entries []syncEntry, limit int, afterVersion int64) syncPage {
+	completed := afterVersion
+	if len(entries) <= limit {
+		if n := len(entries); n > 0 {
+			completed = entries[n-1].Version
+		}
+		return syncPage{Entries: entries, Version: completed}
+	}
+
+	next := entries[limit]
+	entries = entries[:limit]
+
+	// Drop the trailing partial version so the client never persists half of it,
+	// unless that version fills the whole page.
+	cut := len(entries)
+	for cut > 0 && entries[cut-1].Version == next.Version {
+		cut--
+	}
+	if cut > 0 {
+		entries = entries[:cut]
+		completed = entries[cut-1].Version
+	} else {
+		// Everything below next.Version was delivered by earlier pages.
+		completed = next.Version - 1
+	}
+
+	last := entries[len(entries)-1]
+	return syncPage{
+		Entries:    entries,
+		Version:    completed,
+		NextCursor: formatSyncCursor(last.Version, last.ID),
+		HasMore:    true,
+	}
+}
+
+// formatSyncCursor encodes the position of the last delivered entry as "{version}:{id}".
+func formatSyncCursor(version, id int64) string {
+	return strconv.FormatInt(version, 10) + ":" + strconv.FormatInt(id, 10)
+}
+
+func parseSyncCursor(s string) (version, id int64, err error) {
+	v, i, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("malformed cursor %q", s)
+	}
+	if version, err = strconv.ParseInt(v, 10, 64); err != nil {
+		return 0, 0, err
+	}
+	if id, err = strconv.ParseInt(i, 10, 64); err != nil {
+		return 0, 0, err
+	}
+	return version, id, nil
+}
+
+// snapshotTables lists every synced table with a query that renders each row
+// as the same JSON payload the handlers write to sync_log, its ordering, and
+// the condition selecting one project's rows (each ? is the project id; empty
+// for tenant-wide tables). The conditions mirror migration 010's project tags.
+type syncTable struct {
+	name    string
+	query   string
+	order   string
+	project string
+}
+
+var snapshotTables = []syncTable{
+	{"projects", `SELECT json_object('id', id, 'name', name, 'row_version', row_version) FROM projects`,
+		"created_at", "id = ?"},
+	{"kanban_columns", `SELECT json_object('id', id, 'project_id', project_id, 'name', name, 'color', color, 'position', position, 'row_version', row_version) FROM kanban_columns`,
+		"position", "project_id = ?"},
+	{"kanban_cards", `SELECT json_object('id', id, 'project_id', project_id, 'column_name', column_name, 'title', title, 'position', position,
+		'approval_status', approval_status, 'assigned_approver_id', assigned_approver_id, 'due_date', due_date,
+		'client', client, 'priority', priority, 'notes', notes, 'row_version', row_version) FROM kanban_cards`,
+		"position", "project_id = ?"},
+	{"card_tags", `SELECT json_object('id', id, 'card_id', card_id, 'name', name, 'row_version', row_version) FROM card_tags`,
+		"", "card_id IN (SELECT id FROM kanban_cards WHERE project_id = ?)"},
+	{"card_assigned_users", `SELECT json_object('id', id, 'card_id', card_id, 'user_id', user_id, 'user_email', user_email, 'row_version', row_version) FROM card_assigned_users`,
+		"", "card_id IN (SELECT id FROM kanban_cards WHERE project_id = ?)"},
+	{"card_approvers", `SELECT json_object('id', id, 'card_id', card_id, 'user_id', user_id, 'user_email', user_email, 'status', status, 'decided_at', decided_at, 'row_version', row_version) FROM card_approvers`,
+		"", "card_id IN (SELECT id FROM kanban_cards WHERE project_id = ?)"},
+	{"card_sessions", `SELECT json_object('id', id, 'card_id', card_id, 'name', name, 'position', position, 'row_version', row_version) FROM card_sessions`,
+		"position", "card_id IN (SELECT id FROM kanban_cards WHERE project_id = ?)"},
+	{"products", `SELECT json_object('id', id, 'name', name, 'price', price, 'row_version', row_version) FROM products`,
+		"name", ""},
+	{"os_orders", `SELECT json_object('uuid', uuid, 'short_id', short_id, 'card_id', card_id, 'project_id', project_id, 'total', total, 'row_version', row_version) FROM os_orders`,
+		"created_at", orderProject("os_orders")},
+	{"os_items", `SELECT json_object('id', id, 'order_id', order_id, 'product_id', product_id, 'qty', qty, 'row_version', row_version) FROM os_items`,
+		"", "order_id IN (SELECT uuid FROM os_orders o WHERE " + orderProject("o") + ")"},
+}
+
+// orderProject selects the orders of a project, directly or through their
+// card; orders linked to neither belong to every project.
+func orderProject(t string) string {
+	p := "COALESCE(" + t + ".project_id, (SELECT project_id FROM kanban_cards WHERE id = " + t + ".card_id))"
+	return "(" + p + " = ? OR " + p + " IS NULL)"
+}
+
+// scope returns the WHERE clause (or "") and arguments restricting the table to f's project.
+func (t syncTable) scope(f syncFilter) (string, []any) {
+	if f.projectID == "" || t.project == "" {
+		return "", nil
+	}
+	var args []any
+	for range strings.Count(t.project, "?") {
+		args = append(args, f.projectID)
+	}
+	return " WHERE " + t.project, args
+}
+
+// snapshotVersion returns the sync_log version the tenant's tables are
+// consistent at, as seen by q.
+func snapshotVersion(ctx context.Context, q sync.Querier) (int64, error) {
+	var version int64
+	if err := q.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM sync_log").Scan(&version); err != nil {
+		return 0, err
+	}
+	// Compaction may have dropped the newest entry (a tombstone); never
+	// hand out a version the client could not resume from.
+	floor, err := sync.Floor(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+	return max(version, floor), nil
+}
+
+type snapshot struct {
+	Version int64                        `json:"version"`
+	Schema  int                          `json:"schema_version"` // schema the rows follow
+	Tables  map[string][]json.RawMessage `json:"tables"`
+}
+
+// GetSnapshot handles GET /api/sync/snapshot?tables={t1,t2}&project_id={id}
+// Returns the full current state of every synced table plus the sync_log
+// version it is consistent at. All reads happen inside one transaction, so a
+// client can load the snapshot and continue with GET /api/sync?since={version}
+// using the same filter. Tables outside the filter are omitted. Rows follow the
+// client's declared schema (see negotiate); tables and columns it does not
+// know are left out.
+func GetSnapshot(tm *tenant.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tenantID := auth.TenantFromCtx(r.Context())
+		db, release, err := tm.AcquireRead(tenantID)
+		if err != nil {
+			dbUnavailable(w, err)
+			return
+		}
+		defer release()
 
-		flusher, ok := w.(http.Flusher)
+		filter, err := parseSyncFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, `{"error":"invalid tables"}`, http.StatusBadRequest)
+			return
+		}
+		client, ok := negotiate(w, r, db, false)
 		if !ok {
-			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
 			return
 		}
+		m, _ := migrations.Schema()
 
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
-		w.Header().Set("X-Accel-Buffering", "no")
-		// Initial comment forces proxies (Vite, Nginx) to forward the response
-		w.Write([]byte(":ok\n\n"))
-		flusher.Flush()
+		ctx := r.Context()
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			http.Error(w, `{"error":"tx begin failed"}`, http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
 
-		ch, unsub := hub.Subscribe(tenantID)
-		defer unsub()
+		snap := snapshot{
+			Schema: min(client.schema, client.latest),
+			Tables: make(map[string][]json.RawMessage, len(snapshotTables)),
+		}
+		if snap.Version, err = snapshotVersion(ctx, tx); err != nil {
+			http.Error(w, `{"error":"query failed"}`, http.StatusInternalServerError)
+			return
+		}
 
-		ctx := r.Context()
-		for {
-			select {
-			case <-ctx.Done():
+		for _, t := range snapshotTables {
+			if !filter.includes(t.name) || m.Tables[t.name].Since > client.schema {
+				continue
+			}
+			where, args := t.scope(filter)
+			query := t.query + where
+			if t.order != "" {
+				query += " ORDER BY " + t.order
+			}
+			rows, err := tx.QueryContext(ctx, query, args...)
+			if err != nil {
+				http.Error(w, `{"error":"query failed"}`, http.StatusInternalServerError)
 				return
-			case version, ok := <-ch:
-				if !ok {
+			}
+			list := []json.RawMessage{}
+			for rows.Next() {
+				var row string
+				if err := rows.Scan(&row); err != nil {
+					rows.Close()
+					http.Error(w, `{"error":"scan failed"}`, http.StatusInternalServerError)
 					return
 				}
-				// SSE format: "data: {version}\n\n"
-				w.Write([]byte("data: " + strconv.FormatInt(version, 10) + "\n\n"))
-				flusher.Flush()
+				if !client.current() {
+					row = string(client.adaptPayload(m, t.name, "INSERT", []byte(row)))
+				}
+				list = append(list, json.RawMessage(row))
 			}
+			rows.Close()
+			snap.Tables[t.name] = list
 		}
+
+		writeJSON(w, http.StatusOK, snap)
 	}
 }
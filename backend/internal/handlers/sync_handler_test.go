@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/ouroboros/backend/internal/auth"
+)
+
+func TestBuildSyncPage(t *testing.T) {
+	entry := func(id, version int64) syncEntry { return syncEntry{ID: id, Version: version} }
+	tests := []struct {
+		name        string
+		entries     []syncEntry // up to limit+1
+		limit       int
+		after       int64
+		wantIDs     []int64
+		wantVersion int64
+		wantCursor  string
+	}{
+		{"empty", nil, 2, 7, nil, 7, ""},
+		{"last page", []syncEntry{entry(1, 8), entry(2, 9)}, 2, 7, []int64{1, 2}, 9, ""},
+		{"ends on a version boundary", []syncEntry{entry(1, 8), entry(2, 9), entry(3, 10)}, 2, 7,
+			[]int64{1, 2}, 9, "9:2"},
+		{"drops a partial version", []syncEntry{entry(1, 8), entry(2, 9), entry(3, 9)}, 2, 7,
+			[]int64{1}, 8, "8:1"},
+		{"splits a version larger than the page", []syncEntry{entry(1, 8), entry(2, 8), entry(3, 8)}, 2, 7,
+			[]int64{1, 2}, 7, "8:2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := buildSyncPage(tt.entries, tt.limit, tt.after)
+			var ids []int64
+			for _, e := range page.Entries {
+				ids = append(ids, e.ID)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(tt.wantIDs) || page.Version != tt.wantVersion ||
+				page.NextCursor != tt.wantCursor || page.HasMore != (tt.wantCursor != "") {
+				t.Errorf("page = ids %v, version %d, cursor %q, has_more %v; want ids %v, version %d, cursor %q",
+					ids, page.Version, page.NextCursor, page.HasMore, tt.wantIDs, tt.wantVersion, tt.wantCursor)
+			}
+		})
+	}
+}
+
+// TestGetSyncPaging walks the log page by page at every limit and checks
+// that each entry arrives once, in order, and that no page's version claims
+// an entry not yet delivered.
+func TestGetSyncPaging(t *testing.T) {
+	e := newTestEnv(t)
+	// Entries per version 1..6; version 4 is larger than the smaller pages
+	sizes := []int{1, 3, 1, 5, 2, 1}
+	var total int
+	for i, n := range sizes {
+		for j := 0; j < n; j++ {
+			e.exec(`INSERT INTO sync_log (table_name, entity_id, operation, payload, version)
+				VALUES ('products', ?, 'INSERT', '{}', ?)`, fmt.Sprintf("p%d-%d", i+1, j), i+1)
+			total++
+		}
+	}
+	h := GetSync(e.tm)
+
+	for limit := 1; limit <= total+1; limit++ {
+		t.Run(fmt.Sprintf("limit %d", limit), func(t *testing.T) {
+			var got []syncEntry
+			var version int64
+			q := url.Values{"since": {"0"}, "limit": {fmt.Sprint(limit)}}
+			for pages := 0; ; pages++ {
+				if pages > total+1 {
+					t.Fatal("paging does not terminate")
+				}
+				rec := e.serve(h, auth.RoleViewer, http.MethodGet, "/api/sync?"+q.Encode(), nil)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status %d: %s", rec.Code, rec.Body)
+				}
+				page := decode[syncPage](t, rec)
+				if len(page.Entries) > limit {
+					t.Fatalf("%d entries in a page of %d", len(page.Entries), limit)
+				}
+				got = append(got, page.Entries...)
+				if page.Version < version {
+					t.Fatalf("version went back from %d to %d", version, page.Version)
+				}
+				version = page.Version
+				delivered := 0
+				for _, n := range sizes[:version] {
+					delivered += n
+				}
+				if len(got) < delivered {
+					t.Fatalf("version %d claimed after only %d of its %d entries", version, len(got), delivered)
+				}
+				if !page.HasMore {
+					break
+				}
+				q.Set("cursor", page.NextCursor)
+			}
+			if len(got) != total || version != int64(len(sizes)) {
+				t.Fatalf("got %d entries up to version %d, want %d up to %d", len(got), version, total, len(sizes))
+			}
+			for i := 1; i < len(got); i++ {
+				if got[i].ID <= got[i-1].ID {
+					t.Fatalf("entry %d after %d", got[i].ID, got[i-1].ID)
+				}
+			}
+		})
+	}
+
+	t.Run("resumes after since", func(t *testing.T) {
+		rec := e.serve(h, auth.RoleViewer, http.MethodGet, "/api/sync?since=4", nil)
+		page := decode[syncPage](t, rec)
+		if len(page.Entries) != 3 || page.Entries[0].Version != 5 || page.HasMore || page.Version != 6 {
+			t.Errorf("page = %+v", page)
+		}
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for _, q := range []string{"limit=0", "limit=x", "cursor=12", "cursor=a:b"} {
+			if rec := e.serve(h, auth.RoleViewer, http.MethodGet, "/api/sync?"+q, nil); rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: status %d, want 400", q, rec.Code)
+			}
+		}
+	})
+}
+
+// TestGetSyncCompactionFloor checks that a client positioned below the floor,
+// or inside the floor version, is sent to the snapshot.
+func TestGetSyncCompactionFloor(t *testing.T) {
+	e := newTestEnv(t)
+	for v := 4; v <= 5; v++ {
+		e.exec(`INSERT INTO sync_log (table_name, entity_id, operation, payload, version)
+			VALUES ('products', ?, 'INSERT', '{}', ?)`, fmt.Sprint("p", v), v)
+	}
+	e.exec("INSERT INTO sync_state (key, value) VALUES ('compacted_version', 3)")
+	h := GetSync(e.tm)
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"since=0", http.StatusGone},
+		{"since=2", http.StatusGone},
+		{"since=3", http.StatusOK},
+		{"since=4", http.StatusOK},
+		{"cursor=3:1", http.StatusGone},
+		{"cursor=4:1", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := e.serve(h, auth.RoleViewer, http.MethodGet, "/api/sync?"+tt.query, nil)
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusGone {
+				body := decode[map[string]any](t, rec)
+				if body["floor"] != float64(3) || body["snapshot"] != "/api/sync/snapshot" {
+					t.Errorf("body = %v", body)
+				}
+			}
+		})
+	}
+
+	// An empty log reports the floor, not 0, as the version reached
+	e.exec("DELETE FROM sync_log")
+	page := decode[syncPage](t, e.serve(h, auth.RoleViewer, http.MethodGet, "/api/sync?since=3", nil))
+	if page.Version != 3 || len(page.Entries) != 0 {
+		t.Errorf("page = %+v", page)
+	}
+}
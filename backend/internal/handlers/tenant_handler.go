@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+
+	"github.com/ouroboros/backend/internal/auth"
+	"github.com/ouroboros/backend/internal/provision"
+	"github.com/ouroboros/backend/internal/sync"
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+// GetTenantStatus handles GET /api/tenant
+// Reports whether the caller's tenant database is open, how many requests
+// and streams hold it, and when each maintenance hook last ran on it.
+func GetTenantStatus(tm *tenant.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenantID := auth.TenantFromCtx(r.Context())
+		if err := tm.Check(tenantID); err != nil {
+			dbUnavailable(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, tm.Status(tenantID))
+	}
+}
+
+type createTenantRequest struct {
+	ID   string `json:"id"`
+	Plan string `json:"plan"`
+}
+
+type updateTenantRequest struct {
+	Plan string `json:"plan"`
+}
+
+type suspendTenantRequest struct {
+	Reason string `json:"reason"`
+}
+
+// CreateTenant handles POST /admin/tenants
+// Registers a tenant and creates its database.
+func CreateTenant(ps *provision.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req createTenantRequest
+		if err := decodeJSON(r, &req); err != nil {
+			http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
+			return
+		}
+		t, err := ps.Create(req.ID, req.Plan, "admin")
+		if err != nil {
+			tenantError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, t)
+	}
+}
+
+// ListTenants handles GET /admin/tenants
+// Returns every registered tenant, deleted ones included.
+func ListTenants(sdb *auth.SystemDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenants, err := sdb.ListTenants()
+		if err != nil {
+			tenantError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, tenants)
+	}
+}
+
+// GetTenant handles GET /admin/tenants/{id}
+func GetTenant(sdb *auth.SystemDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, err := sdb.GetTenant(r.PathValue("id"))
+		if err != nil {
+			tenantError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, t)
+	}
+}
+
+// UpdateTenant handles PATCH /admin/tenants/{id}
+// Changes the tenant's plan.
+func UpdateTenant(sdb *auth.SystemDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req updateTenantRequest
+		if err := decodeJSON(r, &req); err != nil {
+			http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
+			return
+		}
+		if req.Plan == "" {
+			http.Error(w, `{"error":"plan required"}`, http.StatusBadRequest)
+			return
+		}
+		t, err := sdb.SetTenantPlan(r.PathValue("id"), req.Plan)
+		if err != nil {
+			tenantError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, t)
+	}
+}
+
+// SuspendTenant handles POST /admin/tenants/{id}/suspend
+// Refuses the tenant's logins and requests (403) until it is resumed, and
+// closes its streams.
+func SuspendTenant(ps *provision.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req suspendTenantRequest
+		if r.ContentLength != 0 {
+			if err := decodeJSON(r, &req); err != nil {
+				http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
+				return
+			}
+		}
+		t, err := ps.Suspend(r.PathValue("id"), req.Reason)
+		if err != nil {
+			tenantError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, t)
+	}
+}
+
+// ResumeTenant handles POST /admin/tenants/{id}/resume
+func ResumeTenant(ps *provision.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, err := ps.Resume(r.PathValue("id"))
+		if err != nil {
+			tenantError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, t)
+	}
+}
+
+// ExportTenant handles GET /admin/tenants/{id}/export
+// Streams a zip of the tenant's registry entry, users and database.
+func ExportTenant(ps *provision.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		exp, err := ps.Export(r.Context(), id)
+		if err != nil {
+			tenantError(w, err)
+			return
+		}
+		defer exp.Close()
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "tenant_" + id + ".zip"}))
+		// Headers are out once the archive starts; a failure can only cut it short
+		exp.WriteZip(w)
+	}
+}
+
+// AddTenantUser handles POST /admin/tenants/{id}/users
+// Creates a user in the tenant with the requested role (member by default),
+// e.g. the first owner of a tenant created through CreateTenant.
+func AddTenantUser(sdb *auth.SystemDB, tm *tenant.Manager, hub *sync.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req registerRequest
+		if err := decodeJSON(r, &req); err != nil {
+			http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
+			return
+		}
+		if req.Email == "" || req.Password == "" {
+			http.Error(w, `{"error":"email and password required"}`, http.StatusBadRequest)
+			return
+		}
+		if len(req.Password) < 6 {
+			http.Error(w, `{"error":"password must be at least 6 characters"}`, http.StatusBadRequest)
+			return
+		}
+		if req.Role == "" {
+			req.Role = auth.RoleMember
+		}
+		if !req.Role.Valid() {
+			http.Error(w, `{"error":"invalid role"}`, http.StatusBadRequest)
+			return
+		}
+
+		user, err := sdb.Invite(req.Email, req.Password, r.PathValue("id"), req.Role)
+		if err != nil {
+			if errors.Is(err, auth.ErrEmailTaken) {
+				http.Error(w, `{"error":"email already registered"}`, http.StatusConflict)
+				return
+			}
+			tenantError(w, err)
+			return
+		}
+		notifyUserAdded(r.Context(), tm, hub, user)
+		writeJSON(w, http.StatusCreated, user)
+	}
+}
+
+// DeleteTenant handles DELETE /admin/tenants/{id}
+// Deletes the tenant's users, database and snapshots for good; the ID stays
+// reserved.
+func DeleteTenant(ps *provision.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), restoreDrainTimeout)
+		defer cancel()
+		if err := ps.Delete(ctx, r.PathValue("id")); err != nil {
+			tenantError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// tenantError maps registry and lifecycle errors to responses.
+func tenantError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, tenant.ErrInvalidID):
+		http.Error(w, `{"error":"invalid tenant id"}`, http.StatusBadRequest)
+	case errors.Is(err, auth.ErrTenantExists):
+		http.Error(w, `{"error":"tenant already exists"}`, http.StatusConflict)
+	case errors.Is(err, auth.ErrTenantNotFound):
+		http.Error(w, `{"error":"tenant not found"}`, http.StatusNotFound)
+	case errors.Is(err, auth.ErrTenantDeleted):
+		http.Error(w, `{"error":"tenant deleted"}`, http.StatusGone)
+	case errors.Is(err, auth.ErrTenantSuspended):
+		http.Error(w, `{"error":"tenant suspended"}`, http.StatusConflict)
+	case errors.Is(err, tenant.ErrLocked):
+		http.Error(w, `{"error":"tenant is locked by another operation"}`, http.StatusConflict)
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, `{"error":"tenant still in use"}`, http.StatusServiceUnavailable)
+	default:
+		http.Error(w, `{"error":"tenant operation failed"}`, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ouroboros/backend/internal/auth"
+	"github.com/ouroboros/backend/internal/sync"
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+const (
+	wsWriteWait    = 10 * time.Second
+	wsPongWait     = 60 * time.Second
+	wsPingInterval = 25 * time.Second
+	wsMaxMessage   = 4 << 20 // a full push batch
+	wsWindow       = 32      // delta frames the client may leave unacknowledged
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	// Requests are authenticated by the JWT, not by cookies, so any origin may connect.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// wsMessage is the envelope of every frame, in both directions.
+//
+//	server → client: delta {version, entries}, resync {reason, version},
+//	                 push_ack {id, version, results}, error {id, error},
+//	                 reconnect {retry_ms}, cursor {version}
+//	client → server: ack {version}, push {id, mutations}
+type wsMessage struct {
+	Type      string           `json:"type"`
+	ID        string           `json:"id,omitempty"`
+	Version   int64            `json:"version,omitempty"`
+	Entries   []syncEntry      `json:"entries,omitempty"`
+	Mutations []mutation       `json:"mutations,omitempty"`
+	Results   []mutationResult `json:"results,omitempty"`
+	Reason    string           `json:"reason,omitempty"`
+	Error     string           `json:"error,omitempty"`
+	RetryMS   int64            `json:"retry_ms,omitempty"`
+}
+
+// WSSync handles GET /ws/sync?since={version} — a two-way sync connection.
+// The server streams one delta frame per version after since (or from now on
+// when since is absent), replayed from sync_log, and stops once wsWindow
+// frames are unacknowledged until the client acks. The client pushes mutation
+// batches, each answered by a push_ack with the same results as
+// POST /api/sync/push; each push is checked against the user's current
+// stored role in roles. Pings every wsPingInterval keep the connection alive.
+// On shutdown the server sends a reconnect frame and closes with 1012.
+// The tables and project_id parameters of GET /api/sync filter the deltas,
+// which are adapted to the client's protocol and schema as there. When
+// versions were skipped, a cursor frame with the position follows each ping.
+func WSSync(tm *tenant.Manager, hub *sync.Hub, roles auth.RoleSource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenantID := auth.TenantFromCtx(r.Context())
+		db, release, err := tm.Acquire(tenantID)
+		if err != nil {
+			dbUnavailable(w, err)
+			return
+		}
+		defer release()
+		ro, releaseRead, err := tm.AcquireRead(tenantID)
+		if err != nil {
+			dbUnavailable(w, err)
+			return
+		}
+		defer releaseRead()
+		since, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+		resume := err == nil
+		filter, err := parseSyncFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, `{"error":"invalid tables"}`, http.StatusBadRequest)
+			return
+		}
+		client, ok := negotiate(w, r, ro, true)
+		if !ok {
+			return
+		}
+
+		sub, unsub, ok := subscribe(w, r, hub)
+		if !ok {
+			return
+		}
+		defer unsub()
+
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return // Upgrade has already replied
+		}
+		defer conn.Close()
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		c := &wsConn{
+			conn:     conn,
+			ctx:      ctx,
+			db:       db,
+			ro:       ro,
+			hub:      hub,
+			tenantID: tenantID,
+			userID:   auth.UserFromCtx(r.Context()),
+			roles:    roles,
+			filter:   filter,
+			client:   client,
+		}
+		incoming := make(chan wsMessage)
+		go c.readLoop(incoming, cancel)
+
+		current, err := sync.CurrentVersion(ctx, ro)
+		if err != nil {
+			return
+		}
+		c.last, c.target, c.sent = current, current, current
+		if resume {
+			floor, err := sync.Floor(ctx, ro)
+			if err != nil {
+				return
+			}
+			if since < floor {
+				err = c.resync("compacted", current)
+			} else {
+				c.last, c.sent = since, since
+				err = c.pump()
+			}
+			if err != nil {
+				return
+			}
+		}
+
+		ping := time.NewTicker(wsPingInterval)
+		defer ping.Stop()
+		for {
+			var err error
+			select {
+			case <-ctx.Done():
+				return
+			case version, ok := <-sub.C:
+				if !ok {
+					c.reconnect()
+					return
+				}
+				if sub.Lagged() {
+					// Notifications were dropped, but sync_log still has every entry.
+					if cur, err := sync.CurrentVersion(ctx, ro); err == nil {
+						version = max(version, cur)
+					}
+				}
+				c.target = max(c.target, version)
+				err = c.pump()
+			case msg := <-incoming:
+				err = c.handle(msg)
+			case <-ping.C:
+				err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+				if err == nil {
+					err = c.sendCursor()
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// wsConn is the server side of one /ws/sync connection. Apart from pings,
+// all writes happen on the handler goroutine.
+type wsConn struct {
+	conn     *websocket.Conn
+	ctx      context.Context
+	db       *sql.DB // writer, for pushed mutations
+	ro       *sql.DB // readers, for deltas
+	hub      *sync.Hub
+	tenantID string
+	userID   string
+	roles    auth.RoleSource // asked per push, the connection outliving a role change
+	filter   syncFilter
+	client   syncClient
+
+	last     int64   // highest version sent (or skipped) so far
+	sent     int64   // highest version in a delta or cursor frame
+	target   int64   // highest version known to be committed
+	inflight []int64 // versions sent but not yet acknowledged
+}
+
+// readLoop decodes client frames. The channel is unbuffered, so a client that
+// sends faster than its frames are handled is not read from: TCP pushes back.
+func (c *wsConn) readLoop(out chan<- wsMessage, cancel context.CancelFunc) {
+	defer cancel()
+	c.conn.SetReadLimit(wsMaxMessage)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		var msg wsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			msg = wsMessage{Type: "invalid"}
+		}
+		select {
+		case out <- msg:
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *wsConn) write(msg wsMessage) error {
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return c.conn.WriteJSON(msg)
+}
+
+func (c *wsConn) handle(msg wsMessage) error {
+	switch msg.Type {
+	case "ack":
+		n := 0
+		for n < len(c.inflight) && c.inflight[n] <= msg.Version {
+			n++
+		}
+		c.inflight = c.inflight[n:]
+		return c.pump()
+	case "push":
+		if len(msg.Mutations) == 0 {
+			return c.write(wsMessage{Type: "error", ID: msg.ID, Error: "mutations required"})
+		}
+		if len(msg.Mutations) > maxPushBatch {
+			return c.write(wsMessage{Type: "error", ID: msg.ID, Error: "too many mutations"})
+		}
+		role, err := c.roles.CurrentRole(c.tenantID, c.userID)
+		if errors.Is(err, auth.ErrUserNotFound) {
+			c.write(wsMessage{Type: "error", ID: msg.ID, Error: "user no longer exists"})
+			return err
+		}
+		if err != nil {
+			return c.write(wsMessage{Type: "error", ID: msg.ID, Error: "push failed"})
+		}
+		res, err := runPush(c.ctx, c.db, c.hub, c.tenantID, c.userID, role, msg.Mutations)
+		if err != nil {
+			return c.write(wsMessage{Type: "error", ID: msg.ID, Error: "push failed"})
+		}
+		return c.write(wsMessage{Type: "push_ack", ID: msg.ID, Version: res.Version, Results: res.Results})
+	default:
+		return c.write(wsMessage{Type: "error", ID: msg.ID, Error: "unknown message type"})
+	}
+}
+
+// pump sends delta frames from sync_log up to target while the window allows.
+func (c *wsConn) pump() error {
+	if c.last >= c.target || len(c.inflight) >= wsWindow {
+		return nil
+	}
+	entries, err := readEntries(c.ctx, c.ro, c.filter, c.last, c.target, maxSSEReplay+1)
+	if err != nil {
+		return err
+	}
+	if len(entries) > maxSSEReplay {
+		return c.resync("behind", c.target)
+	}
+	for _, group := range groupByVersion(entries) {
+		if len(c.inflight) >= wsWindow {
+			return nil // resumes from c.last on the next ack
+		}
+		v := group[0].Version
+		if group, err = c.client.adapt(c.ctx, c.ro, group); err != nil {
+			return err
+		}
+		if len(group) == 0 {
+			c.last = v // nothing this client understands; the cursor frame covers it
+			continue
+		}
+		if err := c.write(wsMessage{Type: "delta", Version: v, Entries: group}); err != nil {
+			return err
+		}
+		c.inflight = append(c.inflight, v)
+		c.last, c.sent = v, v
+	}
+	c.last = c.target
+	return nil
+}
+
+// sendCursor tells the client that it is complete up to c.last even though
+// the versions since its last frame had no entries for it.
+func (c *wsConn) sendCursor() error {
+	if c.sent >= c.last || len(c.inflight) > 0 {
+		return nil
+	}
+	c.sent = c.last
+	return c.write(wsMessage{Type: "cursor", Version: c.last})
+}
+
+// reconnect tells the client the server is going away and closes the
+// connection with 1012 (service restart).
+func (c *wsConn) reconnect() {
+	if c.write(wsMessage{Type: "reconnect", RetryMS: reconnectDelay().Milliseconds()}) != nil {
+		return
+	}
+	c.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server restarting"),
+		time.Now().Add(wsWriteWait))
+}
+
+// resync tells the client to catch up through GET /api/sync and continues
+// streaming from version.
+func (c *wsConn) resync(reason string, version int64) error {
+	c.last, c.inflight = version, nil
+	return c.write(wsMessage{Type: "resync", Reason: reason, Version: version})
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ouroboros/backend/internal/auth"
+)
+
+// memberRoles makes every user a member.
+type memberRoles struct{}
+
+func (memberRoles) CurrentRole(tenantID, userID string) (auth.Role, error) {
+	return auth.RoleMember, nil
+}
+
+// dialWS opens /ws/sync as user u1 of the test tenant.
+func (e *testEnv) dialWS(query string) *websocket.Conn {
+	e.t.Helper()
+	srv := e.asUser(WSSync(e.tm, e.hub, memberRoles{}))
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?"+query, nil)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readFrame(t *testing.T, conn *websocket.Conn) wsMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg wsMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// readDeltas reads n delta frames and checks they carry versions from..from+n-1.
+func readDeltas(t *testing.T, conn *websocket.Conn, from int64, n int) {
+	t.Helper()
+	for i := range int64(n) {
+		msg := readFrame(t, conn)
+		if msg.Type != "delta" || msg.Version != from+i || len(msg.Entries) != 1 {
+			t.Fatalf("frame %d = %+v, want the delta of version %d", i, msg, from+i)
+		}
+	}
+}
+
+func TestWSWindow(t *testing.T) {
+	e := newTestEnv(t)
+	versions := make([]int64, wsWindow+5)
+	for i := range versions {
+		versions[i] = int64(i + 1)
+	}
+	e.logProducts(versions...)
+	conn := e.dialWS("since=0")
+
+	readDeltas(t, conn, 1, wsWindow)
+	// The window is full: the answer to a push comes before any further delta
+	if err := conn.WriteJSON(wsMessage{Type: "push", ID: "b1", Mutations: []mutation{insertProject("m1", "p1", "Alpha")}}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readFrame(t, conn); msg.Type != "push_ack" || msg.ID != "b1" {
+		t.Fatalf("frame after a full window = %+v, want push_ack", msg)
+	}
+
+	// Acknowledging part of the window lets that many more through
+	if err := conn.WriteJSON(wsMessage{Type: "ack", Version: 3}); err != nil {
+		t.Fatal(err)
+	}
+	readDeltas(t, conn, wsWindow+1, 3)
+	if err := conn.WriteJSON(wsMessage{Type: "push", ID: "b2", Mutations: []mutation{insertProject("m1", "p1", "Alpha")}}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readFrame(t, conn); msg.Type != "push_ack" || msg.ID != "b2" {
+		t.Fatalf("frame after a refilled window = %+v, want push_ack", msg)
+	}
+	if err := conn.WriteJSON(wsMessage{Type: "ack", Version: wsWindow + 3}); err != nil {
+		t.Fatal(err)
+	}
+	readDeltas(t, conn, wsWindow+4, 2)
+}
+
+// TestWSReplay checks that a connection resumes after since, and that one
+// without since only gets what is new.
+func TestWSReplay(t *testing.T) {
+	e := newTestEnv(t)
+	e.logProducts(1, 2, 3)
+	readDeltas(t, e.dialWS("since=1"), 2, 2)
+
+	e.exec("INSERT INTO sync_state (key, value) VALUES ('compacted_version', 2)")
+	if msg := readFrame(t, e.dialWS("since=1")); msg.Type != "resync" || msg.Reason != "compacted" || msg.Version != 3 {
+		t.Errorf("below the floor: %+v", msg)
+	}
+}
+
+// TestWSPush checks that a push over the socket gets the same answer as the
+// same batch through POST /api/sync/push on an identical tenant.
+func TestWSPush(t *testing.T) {
+	batch := []mutation{
+		insertProject("m1", "p1", "Alpha"),
+		{ID: "m2", Table: "kanban_cards", EntityID: "c1", Operation: "INSERT",
+			Payload: payload(map[string]any{"project_id": "p1"})}, // no title
+		insertProject("m3", "p1", "Alpha again"), // duplicate primary key
+		{ID: "m4", Table: "products", Operation: "INSERT", Payload: payload(map[string]any{"name": "x"})},
+		insertProject("m0", "p0", "Zero"), // pushed before
+	}
+	setup := func(e *testEnv) {
+		e.logProducts(1, 2)
+		e.push(auth.RoleMember, insertProject("m0", "p0", "Zero"))
+	}
+
+	overHTTP := newTestEnv(t)
+	setup(overHTTP)
+	want := overHTTP.push(auth.RoleMember, batch...)
+
+	overWS := newTestEnv(t)
+	setup(overWS)
+	conn := overWS.dialWS("")
+	if err := conn.WriteJSON(wsMessage{Type: "push", ID: "b1", Mutations: batch}); err != nil {
+		t.Fatal(err)
+	}
+	got := readFrame(t, conn)
+	if got.Type != "push_ack" || got.ID != "b1" || got.Version != want.Version {
+		t.Fatalf("push_ack = %+v, want version %d", got, want.Version)
+	}
+	gotJSON, _ := json.Marshal(got.Results)
+	wantJSON, _ := json.Marshal(want.Results)
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("results over the socket:\n%s\nover HTTP:\n%s", gotJSON, wantJSON)
+	}
+
+	if err := conn.WriteJSON(wsMessage{Type: "push", ID: "b2"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readFrame(t, conn); msg.Type != "error" || msg.ID != "b2" || msg.Error != "mutations required" {
+		t.Errorf("empty push: %+v", msg)
+	}
+}
@@ -0,0 +1,135 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Column describes a table column as of the latest migration.
+type Column struct {
+	Name    string  `json:"name"`
+	Type    string  `json:"type"`
+	NotNull bool    `json:"not_null"`
+	Default *string `json:"default,omitempty"`
+	PK      bool    `json:"primary_key,omitempty"`
+	Since   int     `json:"since"` // migration that added the column
+}
+
+// Table describes a table as of the latest migration.
+type Table struct {
+	Since   int      `json:"since"` // migration that created the table
+	Columns []Column `json:"columns"`
+}
+
+// Manifest is the schema produced by the embedded migrations.
+type Manifest struct {
+	Version int              `json:"schema_version"`
+	Tables  map[string]Table `json:"tables"`
+}
+
+var (
+	manifestOnce sync.Once
+	manifest     *Manifest
+	manifestErr  error
+)
+
+// Schema returns the manifest of the embedded migrations, built once by
+// applying them one at a time to an in-memory database and recording which
+// migration introduced every table and column.
+func Schema() (*Manifest, error) {
+	manifestOnce.Do(func() {
+		manifest, manifestErr = buildManifest()
+	})
+	return manifest, manifestErr
+}
+
+func buildManifest() (*Manifest, error) {
+	migrations, err := Tenant.list()
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1) // every connection would get its own :memory: database
+
+	m := &Manifest{Tables: map[string]Table{}}
+	for _, mig := range migrations {
+		content, err := Tenant.read(mig.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := db.Exec(string(content)); err != nil {
+			return nil, fmt.Errorf("exec migration %s: %w", mig.name, err)
+		}
+		if err := m.record(db, mig.version); err != nil {
+			return nil, err
+		}
+		m.Version = mig.version
+	}
+	return m, nil
+}
+
+// record refreshes the manifest from db after migration version, keeping the
+// Since of tables and columns that already existed.
+func (m *Manifest) record(db *sql.DB, version int) error {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		return err
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+
+	tables := make(map[string]Table, len(names))
+	for _, name := range names {
+		prev, existed := m.Tables[name]
+		t := Table{Since: version}
+		if existed {
+			t.Since = prev.Since
+		}
+		since := map[string]int{}
+		for _, c := range prev.Columns {
+			since[c.Name] = c.Since
+		}
+
+		cols, err := db.Query("SELECT name, type, \"notnull\", dflt_value, pk FROM pragma_table_info(?)", name)
+		if err != nil {
+			return err
+		}
+		for cols.Next() {
+			var c Column
+			var def sql.NullString
+			var pk int
+			if err := cols.Scan(&c.Name, &c.Type, &c.NotNull, &def, &pk); err != nil {
+				cols.Close()
+				return err
+			}
+			if def.Valid {
+				c.Default = &def.String
+			}
+			c.PK = pk > 0
+			c.Since = version
+			if s, ok := since[c.Name]; ok {
+				c.Since = s
+			}
+			t.Columns = append(t.Columns, c)
+		}
+		cols.Close()
+		tables[name] = t
+	}
+	m.Tables = tables
+	return nil
+}
@@ -1,35 +1,111 @@
 package migrations
 
 import (
+	"crypto/sha256"
 	"database/sql"
 	"embed"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
 )
 
-//go:embed sql/*.sql
+//go:embed sql/*.sql sql/system/*.sql
 var sqlFiles embed.FS
 
-// Run applies all pending migrations to the database.
-// It uses PRAGMA user_version to track the current schema version.
+// ErrDrift is returned by Run, under DriftRefuse, when migrations a database
+// has applied no longer match the embedded files.
+var ErrDrift = errors.New("applied migrations differ from embedded files")
+
+// ErrIrreversible is returned by Rollback for a migration that has no down
+// script, or whose down script declares it irreversible and was not forced.
+var ErrIrreversible = errors.New("migration is irreversible")
+
+// ErrBehind is returned by Require for a database with migrations pending.
+var ErrBehind = errors.New("schema is behind the embedded migrations")
+
+// DriftPolicy says what Run does about drift.
+type DriftPolicy int
+
+const (
+	DriftWarn   DriftPolicy = iota // log it and carry on
+	DriftRefuse                    // fail with ErrDrift
+)
+
+// Source is a directory of numbered migration files (001_description.sql)
+// applied in order. Each database records what it applied, with checksums,
+// in its schema_migrations table, and keeps PRAGMA user_version at the
+// latest version.
+//
+// A migration may have a down script (001_description.down.sql) that undoes
+// it for Rollback. A down script starting with an "-- irreversible" comment
+// line declares that the migration cannot be undone faithfully; it is only
+// run when forced.
+type Source struct {
+	fsys fs.FS
+	dir  string
+
+	// Drift is what Run does on drift; set it before the Source is used.
+	Drift DriftPolicy
+}
+
+// NewSource returns the migrations in dir of fsys.
+func NewSource(fsys fs.FS, dir string) *Source {
+	return &Source{fsys: fsys, dir: dir}
+}
+
+// Tenant is the source of tenant database migrations.
+var Tenant = NewSource(sqlFiles, "sql")
+
+// System is the source of system.db migrations.
+var System = NewSource(sqlFiles, "sql/system")
+
+// Run applies all pending tenant migrations to the database; see Source.Run.
 func Run(db *sql.DB) error {
-	entries, err := sqlFiles.ReadDir("sql")
+	return Tenant.Run(db)
+}
+
+// Rollback reverts a tenant database to the target version; see
+// Source.Rollback. Irreversible migrations are refused.
+func Rollback(db *sql.DB, target int) error {
+	return Tenant.Rollback(db, target, false)
+}
+
+// Require checks that a tenant database is up to date; see Source.Require.
+func Require(db *sql.DB) error {
+	return Tenant.Require(db)
+}
+
+type migration struct {
+	version int
+	name    string
+	down    string // down script, "" if none
+}
+
+// list returns the migrations sorted by version number, with their down
+// scripts.
+func (s *Source) list() ([]migration, error) {
+	entries, err := fs.ReadDir(s.fsys, s.dir)
 	if err != nil {
-		return fmt.Errorf("read embedded sql dir: %w", err)
+		return nil, fmt.Errorf("read migrations dir: %w", err)
 	}
 
-	// Parse and sort migration files by version number
-	type migration struct {
-		version int
-		name    string
+	downs := make(map[string]bool)
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".down.sql") {
+			downs[e.Name()] = true
+		}
 	}
+
 	var migrations []migration
 	for _, e := range entries {
 		name := e.Name()
-		if !strings.HasSuffix(name, ".sql") {
+		if e.IsDir() || !strings.HasSuffix(name, ".sql") || downs[name] {
 			continue
 		}
 		// Expected format: 001_description.sql
@@ -41,26 +117,160 @@ func Run(db *sql.DB) error {
 		if err != nil {
 			continue
 		}
-		migrations = append(migrations, migration{version: ver, name: name})
+		m := migration{version: ver, name: name}
+		if down := strings.TrimSuffix(name, ".sql") + ".down.sql"; downs[down] {
+			m.down = down
+		}
+		migrations = append(migrations, m)
 	}
 	sort.Slice(migrations, func(i, j int) bool {
 		return migrations[i].version < migrations[j].version
 	})
+	return migrations, nil
+}
+
+func (s *Source) read(name string) ([]byte, error) {
+	content, err := fs.ReadFile(s.fsys, path.Join(s.dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("read migration %s: %w", name, err)
+	}
+	return content, nil
+}
+
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Applied is a migration recorded in a database's schema_migrations table.
+type Applied struct {
+	Version   int    `json:"version"`
+	Filename  string `json:"filename"`
+	SHA256    string `json:"sha256"`
+	AppliedAt string `json:"applied_at"`
+}
+
+// Drift is an applied migration that no longer matches the embedded files.
+type Drift struct {
+	Applied
+	Problem string `json:"problem"` // edited, renamed to ..., or missing
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s (version %d) %s since it was applied", d.Filename, d.Version, d.Problem)
+}
+
+const historySchema = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		filename   TEXT NOT NULL,
+		sha256     TEXT NOT NULL,
+		applied_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+	)`
+
+// History returns the migrations recorded in db, oldest first.
+func History(db *sql.DB) ([]Applied, error) {
+	var exists int
+	if err := db.QueryRow(
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'",
+	).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, nil
+	}
+	rows, err := db.Query("SELECT version, filename, sha256, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var history []Applied
+	for rows.Next() {
+		var a Applied
+		if err := rows.Scan(&a.Version, &a.Filename, &a.SHA256, &a.AppliedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, a)
+	}
+	return history, rows.Err()
+}
+
+// Verify compares the migrations recorded in db with the files. Migrations
+// newer than the latest file are not drift: they come from a newer build.
+func (s *Source) Verify(db *sql.DB) ([]Drift, error) {
+	migrations, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]migration, len(migrations))
+	latest := 0
+	for _, m := range migrations {
+		byVersion[m.version] = m
+		latest = max(latest, m.version)
+	}
+	history, err := History(db)
+	if err != nil {
+		return nil, fmt.Errorf("read schema_migrations: %w", err)
+	}
+
+	var drift []Drift
+	for _, a := range history {
+		m, ok := byVersion[a.Version]
+		if !ok && a.Version > latest {
+			continue
+		}
+		if !ok {
+			drift = append(drift, Drift{Applied: a, Problem: "missing"})
+			continue
+		}
+		content, err := s.read(m.name)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case checksum(content) != a.SHA256:
+			drift = append(drift, Drift{Applied: a, Problem: "edited"})
+		case m.name != a.Filename:
+			drift = append(drift, Drift{Applied: a, Problem: "renamed to " + m.name})
+		}
+	}
+	return drift, nil
+}
+
+// Run applies all pending migrations to the database, each in a transaction
+// with its schema_migrations row. It first checks that the migrations already
+// applied still match their files (see Verify), and records those a database
+// applied before it kept a history.
+func (s *Source) Run(db *sql.DB) error {
+	migrations, err := s.list()
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(historySchema); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
 
 	// Get current schema version
 	var currentVersion int
 	if err := db.QueryRow("PRAGMA user_version").Scan(&currentVersion); err != nil {
 		return fmt.Errorf("read user_version: %w", err)
 	}
+	if err := s.backfill(db, migrations, currentVersion); err != nil {
+		return err
+	}
+
+	if err := s.checkDrift(db); err != nil {
+		return err
+	}
 
 	// Apply pending migrations
 	for _, m := range migrations {
 		if m.version <= currentVersion {
 			continue
 		}
-		content, err := sqlFiles.ReadFile("sql/" + m.name)
+		content, err := s.read(m.name)
 		if err != nil {
-			return fmt.Errorf("read migration %s: %w", m.name, err)
+			return err
 		}
 
 		log.Printf("[migrations] applying %s (version %d -> %d)", m.name, currentVersion, m.version)
@@ -73,6 +283,13 @@ func Run(db *sql.DB) error {
 			tx.Rollback()
 			return fmt.Errorf("exec migration %s: %w", m.name, err)
 		}
+		if _, err := tx.Exec(
+			"INSERT INTO schema_migrations (version, filename, sha256) VALUES (?, ?, ?)",
+			m.version, m.name, checksum(content),
+		); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("record migration %s: %w", m.name, err)
+		}
 		// SQLite doesn't support PRAGMA in transactions via parameter binding,
 		// so we use Sprintf safely (version is an int, not user input).
 		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.version)); err != nil {
@@ -88,3 +305,223 @@ func Run(db *sql.DB) error {
 	log.Printf("[migrations] schema at version %d", currentVersion)
 	return nil
 }
+
+// Pending returns the schema version of db and the files of the migrations
+// Run would apply to it.
+func (s *Source) Pending(db *sql.DB) (version int, pending []string, err error) {
+	migrations, err := s.list()
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, nil, fmt.Errorf("read user_version: %w", err)
+	}
+	for _, m := range migrations {
+		if m.version > version {
+			pending = append(pending, m.name)
+		}
+	}
+	return version, pending, nil
+}
+
+// rollbackStep undoes one migration, leaving the database at version prev.
+type rollbackStep struct {
+	migration
+	content []byte // of the down script
+	prev    int
+}
+
+// rollbackSteps returns the migrations to undo, newest first, to take a
+// database from version down to target. It fails unless each has a down
+// script, and, if one is declared irreversible, force is set.
+func (s *Source) rollbackSteps(version, target int, force bool) ([]rollbackStep, error) {
+	if target < 0 {
+		return nil, fmt.Errorf("invalid target version %d", target)
+	}
+	migrations, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	if len(migrations) > 0 && version > migrations[len(migrations)-1].version {
+		return nil, fmt.Errorf("database at version %d is ahead of this build", version)
+	}
+	var steps []rollbackStep
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.version > version {
+			continue
+		}
+		if m.version <= target {
+			break
+		}
+		step := rollbackStep{migration: m}
+		if i > 0 {
+			step.prev = migrations[i-1].version
+		}
+		if m.down == "" {
+			return nil, fmt.Errorf("%w: %s has no down script", ErrIrreversible, m.name)
+		}
+		if step.content, err = s.read(m.down); err != nil {
+			return nil, err
+		}
+		first, _, _ := strings.Cut(string(step.content), "\n")
+		if strings.HasPrefix(strings.TrimSpace(first), "-- irreversible") && !force {
+			return nil, fmt.Errorf("%w: %s is declared irreversible (force to roll it back anyway)", ErrIrreversible, m.name)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// RollbackPlan returns the schema version of db and the down scripts
+// Rollback would run to take it to target, newest first, or the error it
+// would refuse with.
+func (s *Source) RollbackPlan(db *sql.DB, target int, force bool) (version int, scripts []string, err error) {
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, nil, fmt.Errorf("read user_version: %w", err)
+	}
+	steps, err := s.rollbackSteps(version, target, force)
+	if err != nil {
+		return version, nil, err
+	}
+	for _, step := range steps {
+		scripts = append(scripts, step.down)
+	}
+	return version, scripts, nil
+}
+
+// Rollback reverts db to the target version by running down scripts, newest
+// first, each in a transaction with the removal of its schema_migrations row.
+// Nothing is run unless every migration to undo has a down script, and, if
+// one is declared irreversible, force is set.
+func (s *Source) Rollback(db *sql.DB, target int, force bool) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("read user_version: %w", err)
+	}
+	steps, err := s.rollbackSteps(version, target, force)
+	if err != nil {
+		return err
+	}
+	if err := s.checkDrift(db); err != nil {
+		return err
+	}
+
+	for _, step := range steps {
+		log.Printf("[migrations] rolling back %s (version %d -> %d)", step.name, version, step.prev)
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("begin tx for %s: %w", step.down, err)
+		}
+		if _, err := tx.Exec(string(step.content)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("exec %s: %w", step.down, err)
+		}
+		if _, err := tx.Exec("DELETE FROM schema_migrations WHERE version = ?", step.version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("unrecord migration %s: %w", step.name, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", step.prev)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("set user_version for %s: %w", step.down, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit %s: %w", step.down, err)
+		}
+		version = step.prev
+	}
+
+	log.Printf("[migrations] schema at version %d", version)
+	return nil
+}
+
+// Require is Run for servers that leave migrating to an operator: it fails
+// with ErrBehind instead of applying pending migrations. New (empty)
+// databases are still migrated, so they can be created on demand.
+func (s *Source) Require(db *sql.DB) error {
+	version, pending, err := s.Pending(db)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		return s.Run(db)
+	}
+	if err := s.checkDrift(db); err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: at version %d, %s pending", ErrBehind, version, strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// checkDrift verifies db and, depending on the policy, logs the drift or
+// fails with ErrDrift.
+func (s *Source) checkDrift(db *sql.DB) error {
+	drift, err := s.Verify(db)
+	if err != nil {
+		return err
+	}
+	if len(drift) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(drift))
+	for i, d := range drift {
+		msgs[i] = d.String()
+	}
+	if s.Drift == DriftRefuse {
+		return fmt.Errorf("%w: %s", ErrDrift, strings.Join(msgs, "; "))
+	}
+	for _, msg := range msgs {
+		log.Printf("[migrations] warning: %s", msg)
+	}
+	return nil
+}
+
+// backfill records the migrations up to version that db applied before it
+// kept a history, trusting that they match today's files.
+func (s *Source) backfill(db *sql.DB, migrations []migration, version int) error {
+	history, err := History(db)
+	if err != nil {
+		return fmt.Errorf("read schema_migrations: %w", err)
+	}
+	recorded := make(map[int]bool, len(history))
+	for _, a := range history {
+		recorded[a.Version] = true
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	n := 0
+	for _, m := range migrations {
+		if m.version > version {
+			break
+		}
+		if recorded[m.version] {
+			continue
+		}
+		content, err := s.read(m.name)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			"INSERT INTO schema_migrations (version, filename, sha256) VALUES (?, ?, ?)",
+			m.version, m.name, checksum(content),
+		); err != nil {
+			return fmt.Errorf("record migration %s: %w", m.name, err)
+		}
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("[migrations] recorded %d migration(s) applied before history was kept", n)
+	return nil
+}
@@ -0,0 +1,329 @@
+package migrations
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func testFiles() fstest.MapFS {
+	return fstest.MapFS{
+		"m/001_a.sql": {Data: []byte("CREATE TABLE a (id INTEGER PRIMARY KEY);")},
+		"m/002_b.sql": {Data: []byte("CREATE TABLE b (id INTEGER PRIMARY KEY);")},
+	}
+}
+
+func userVersion(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var v int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestRunRecordsChecksums(t *testing.T) {
+	files := testFiles()
+	db := openTestDB(t)
+	if err := NewSource(files, "m").Run(db); err != nil {
+		t.Fatal(err)
+	}
+	if v := userVersion(t, db); v != 2 {
+		t.Errorf("user_version = %d, want 2", v)
+	}
+	history, err := History(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("history = %+v", history)
+	}
+	for i, name := range []string{"001_a.sql", "002_b.sql"} {
+		a := history[i]
+		if a.Version != i+1 || a.Filename != name || a.SHA256 != checksum(files["m/"+name].Data) || a.AppliedAt == "" {
+			t.Errorf("history[%d] = %+v", i, a)
+		}
+	}
+
+	// A second run has nothing to do
+	if err := NewSource(files, "m").Run(db); err != nil {
+		t.Errorf("second run: %v", err)
+	}
+}
+
+func TestRunBackfillsHistory(t *testing.T) {
+	db := openTestDB(t)
+	// A database migrated to version 1 before schema_migrations existed
+	if _, err := db.Exec("CREATE TABLE a (id INTEGER PRIMARY KEY); PRAGMA user_version = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewSource(testFiles(), "m").Run(db); err != nil {
+		t.Fatal(err)
+	}
+	history, err := History(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Filename != "001_a.sql" {
+		t.Errorf("history = %+v", history)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(fstest.MapFS)
+		want   string // the problem reported for 001_a.sql, "" for none
+	}{
+		{"unchanged", func(fstest.MapFS) {}, ""},
+		{"edited", func(f fstest.MapFS) {
+			f["m/001_a.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE a (id INTEGER PRIMARY KEY, x TEXT);")}
+		}, "edited"},
+		{"renamed", func(f fstest.MapFS) {
+			f["m/001_first.sql"] = f["m/001_a.sql"]
+			delete(f, "m/001_a.sql")
+		}, "renamed to 001_first.sql"},
+		{"missing", func(f fstest.MapFS) { delete(f, "m/001_a.sql") }, "missing"},
+		{"only newer files", func(f fstest.MapFS) {
+			f["m/003_c.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE c (id INTEGER PRIMARY KEY);")}
+		}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			if err := NewSource(testFiles(), "m").Run(db); err != nil {
+				t.Fatal(err)
+			}
+			files := testFiles()
+			tt.change(files)
+			src := NewSource(files, "m")
+			drift, err := src.Verify(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == "" {
+				if len(drift) != 0 {
+					t.Errorf("drift = %v", drift)
+				}
+				return
+			}
+			if len(drift) != 1 || drift[0].Version != 1 || drift[0].Problem != tt.want {
+				t.Fatalf("drift = %v, want 001_a.sql %s", drift, tt.want)
+			}
+
+			// Warned about by default, refused on request
+			if err := src.Run(db); err != nil {
+				t.Errorf("run with DriftWarn: %v", err)
+			}
+			src.Drift = DriftRefuse
+			if err := src.Run(db); !errors.Is(err, ErrDrift) || !strings.Contains(err.Error(), "001_a.sql") {
+				t.Errorf("run with DriftRefuse: err = %v", err)
+			}
+		})
+	}
+}
+
+// TestVerifyNewerBuild checks that migrations applied by a newer build are not
+// mistaken for deleted files.
+func TestVerifyNewerBuild(t *testing.T) {
+	db := openTestDB(t)
+	newer := testFiles()
+	newer["m/003_c.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE c (id INTEGER PRIMARY KEY);")}
+	if err := NewSource(newer, "m").Run(db); err != nil {
+		t.Fatal(err)
+	}
+	if drift, err := NewSource(testFiles(), "m").Verify(db); err != nil || len(drift) != 0 {
+		t.Errorf("drift = %v, err = %v", drift, err)
+	}
+}
+
+// TestTenantMigrations runs the embedded tenant migrations on a new database.
+func TestTenantMigrations(t *testing.T) {
+	db := openTestDB(t)
+	if err := Run(db); err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := Tenant.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := userVersion(t, db); v != migrations[len(migrations)-1].version {
+		t.Errorf("user_version = %d after %d migrations", v, len(migrations))
+	}
+	if drift, err := Tenant.Verify(db); err != nil || len(drift) != 0 {
+		t.Errorf("drift = %v, err = %v", drift, err)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	db := openTestDB(t)
+	// A new database is migrated on demand
+	if err := NewSource(testFiles(), "m").Require(db); err != nil {
+		t.Fatal(err)
+	}
+	if v := userVersion(t, db); v != 2 {
+		t.Fatalf("new database at version %d, want 2", v)
+	}
+
+	files := testFiles()
+	files["m/003_c.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE c (id INTEGER PRIMARY KEY);")}
+	src := NewSource(files, "m")
+	err := src.Require(db)
+	if !errors.Is(err, ErrBehind) || !strings.Contains(err.Error(), "003_c.sql") {
+		t.Fatalf("behind: err = %v", err)
+	}
+	if v := userVersion(t, db); v != 2 {
+		t.Errorf("Require migrated an existing database to version %d", v)
+	}
+
+	if err := src.Run(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Require(db); err != nil {
+		t.Errorf("up to date: err = %v", err)
+	}
+
+	files["m/001_a.sql"] = &fstest.MapFile{Data: []byte("-- edited\nCREATE TABLE a (id INTEGER PRIMARY KEY);")}
+	src.Drift = DriftRefuse
+	if err := src.Require(db); !errors.Is(err, ErrDrift) {
+		t.Errorf("drift: err = %v", err)
+	}
+}
+
+func rollbackFiles() fstest.MapFS {
+	files := testFiles()
+	files["m/002_b.down.sql"] = &fstest.MapFile{Data: []byte("DROP TABLE b;")}
+	files["m/003_c.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE c (id INTEGER PRIMARY KEY);")}
+	files["m/003_c.down.sql"] = &fstest.MapFile{Data: []byte("-- irreversible: c held data\nDROP TABLE c;")}
+	return files
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n == 1
+}
+
+func TestRollback(t *testing.T) {
+	db := openTestDB(t)
+	src := NewSource(rollbackFiles(), "m")
+	if err := src.Run(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Refusals leave the database untouched
+	tests := []struct {
+		name   string
+		target int
+		force  bool
+	}{
+		{"irreversible without force", 2, false},
+		{"past a missing down script", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := src.Rollback(db, tt.target, tt.force); !errors.Is(err, ErrIrreversible) {
+				t.Errorf("err = %v, want ErrIrreversible", err)
+			}
+			if v := userVersion(t, db); v != 3 || !tableExists(t, db, "c") {
+				t.Errorf("refused rollback left version %d", v)
+			}
+		})
+	}
+	if err := src.Rollback(db, -1, true); err == nil {
+		t.Error("rollback to a negative version succeeded")
+	}
+
+	_, scripts, err := src.RollbackPlan(db, 1, true)
+	if err != nil || strings.Join(scripts, ",") != "003_c.down.sql,002_b.down.sql" {
+		t.Errorf("plan = %v, err = %v", scripts, err)
+	}
+	if err := src.Rollback(db, 1, true); err != nil {
+		t.Fatal(err)
+	}
+	if v := userVersion(t, db); v != 1 || tableExists(t, db, "b") || tableExists(t, db, "c") || !tableExists(t, db, "a") {
+		t.Errorf("after rollback: version %d", v)
+	}
+	history, err := History(db)
+	if err != nil || len(history) != 1 {
+		t.Errorf("history = %+v, err = %v", history, err)
+	}
+
+	// Migrating again reapplies what was rolled back
+	if err := src.Run(db); err != nil {
+		t.Fatal(err)
+	}
+	if v := userVersion(t, db); v != 3 || !tableExists(t, db, "c") {
+		t.Errorf("after rerun: version %d", v)
+	}
+}
+
+// TestRollbackAhead checks that a build does not roll back a database
+// migrated by a newer one, whose down scripts it does not have.
+func TestRollbackAhead(t *testing.T) {
+	db := openTestDB(t)
+	if err := NewSource(rollbackFiles(), "m").Run(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewSource(testFiles(), "m").Rollback(db, 1, true); err == nil || !strings.Contains(err.Error(), "ahead") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// TestTenantRollback rolls the embedded tenant migrations back as far as their
+// down scripts go, and forward again.
+func TestTenantRollback(t *testing.T) {
+	db := openTestDB(t)
+	if err := Run(db); err != nil {
+		t.Fatal(err)
+	}
+	latest := userVersion(t, db)
+	migrations, err := Tenant.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The oldest migration of the reversible tail, all with plain down scripts
+	target := latest
+	for i := len(migrations) - 1; i > 0 && migrations[i].down != ""; i-- {
+		content, err := Tenant.read(migrations[i].down)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(string(content), "-- irreversible") {
+			break
+		}
+		target = migrations[i-1].version
+	}
+	if target == latest {
+		t.Skip("the latest tenant migration has no down script")
+	}
+
+	if err := Rollback(db, target); err != nil {
+		t.Fatal(err)
+	}
+	if v := userVersion(t, db); v != target {
+		t.Errorf("after rollback: version %d, want %d", v, target)
+	}
+	if err := Run(db); err != nil {
+		t.Fatal(err)
+	}
+	if v := userVersion(t, db); v != latest {
+		t.Errorf("after rerun: version %d, want %d", v, latest)
+	}
+}
@@ -0,0 +1,2 @@
+-- irreversible: before this migration os_orders.project_id referenced
+-- kanban_cards, which was wrong. Forcing a rollback keeps the corrected tables.
@@ -0,0 +1,33 @@
+-- Timestamp sync_log entries for retention-based compaction, track the compaction floor
+
+-- 1. Backup data
+CREATE TABLE sync_log_backup AS SELECT id, table_name, entity_id, operation, payload, version FROM sync_log;
+
+-- 2. Recreate with created_at (ALTER TABLE cannot add a column with a non-constant default)
+DROP TABLE sync_log;
+
+CREATE TABLE sync_log (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    table_name TEXT    NOT NULL,
+    entity_id  TEXT    NOT NULL,
+    operation  TEXT    NOT NULL CHECK(operation IN ('INSERT','UPDATE','DELETE','PATCH')),
+    payload    TEXT    NOT NULL DEFAULT '{}',
+    version    INTEGER NOT NULL,
+    created_at TEXT    NOT NULL DEFAULT (datetime('now'))
+);
+
+-- 3. Restore data
+INSERT INTO sync_log (id, table_name, entity_id, operation, payload, version)
+    SELECT id, table_name, entity_id, operation, payload, version FROM sync_log_backup;
+
+-- 4. Cleanup
+DROP TABLE sync_log_backup;
+
+CREATE INDEX IF NOT EXISTS idx_sync_log_version ON sync_log(version);
+CREATE INDEX IF NOT EXISTS idx_sync_log_entity ON sync_log(table_name, entity_id);
+
+-- Key/value sync bookkeeping (e.g. compacted_version = oldest "since" still served)
+CREATE TABLE IF NOT EXISTS sync_state (
+    key   TEXT PRIMARY KEY,
+    value INTEGER NOT NULL
+);
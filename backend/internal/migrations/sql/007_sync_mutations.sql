@@ -0,0 +1,9 @@
+-- Client mutation IDs accepted via POST /api/sync/push, so re-pushes are idempotent
+
+CREATE TABLE IF NOT EXISTS sync_mutations (
+    id         TEXT PRIMARY KEY,
+    table_name TEXT    NOT NULL,
+    entity_id  TEXT    NOT NULL,
+    version    INTEGER NOT NULL,
+    created_at TEXT    NOT NULL DEFAULT (datetime('now'))
+);
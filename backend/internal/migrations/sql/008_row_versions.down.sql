@@ -0,0 +1,24 @@
+-- Drop per-row versions. They are derived from sync_log, which the up
+-- migration backfills them from again.
+
+DROP TRIGGER IF EXISTS sync_log_row_version_projects;
+DROP TRIGGER IF EXISTS sync_log_row_version_kanban_cards;
+DROP TRIGGER IF EXISTS sync_log_row_version_kanban_columns;
+DROP TRIGGER IF EXISTS sync_log_row_version_products;
+DROP TRIGGER IF EXISTS sync_log_row_version_os_orders;
+DROP TRIGGER IF EXISTS sync_log_row_version_os_items;
+DROP TRIGGER IF EXISTS sync_log_row_version_card_tags;
+DROP TRIGGER IF EXISTS sync_log_row_version_card_assigned_users;
+DROP TRIGGER IF EXISTS sync_log_row_version_card_approvers;
+DROP TRIGGER IF EXISTS sync_log_row_version_card_sessions;
+
+ALTER TABLE projects DROP COLUMN row_version;
+ALTER TABLE kanban_cards DROP COLUMN row_version;
+ALTER TABLE kanban_columns DROP COLUMN row_version;
+ALTER TABLE products DROP COLUMN row_version;
+ALTER TABLE os_orders DROP COLUMN row_version;
+ALTER TABLE os_items DROP COLUMN row_version;
+ALTER TABLE card_tags DROP COLUMN row_version;
+ALTER TABLE card_assigned_users DROP COLUMN row_version;
+ALTER TABLE card_approvers DROP COLUMN row_version;
+ALTER TABLE card_sessions DROP COLUMN row_version;
@@ -0,0 +1,86 @@
+-- Per-row versions: every synced row carries the sync_log version of its last write
+-- so update endpoints can detect stale writes (If-Match / base_version).
+
+ALTER TABLE projects ADD COLUMN row_version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE kanban_cards ADD COLUMN row_version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE kanban_columns ADD COLUMN row_version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN row_version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE os_orders ADD COLUMN row_version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE os_items ADD COLUMN row_version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE card_tags ADD COLUMN row_version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE card_assigned_users ADD COLUMN row_version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE card_approvers ADD COLUMN row_version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE card_sessions ADD COLUMN row_version INTEGER NOT NULL DEFAULT 0;
+
+-- Backfill from the existing log
+UPDATE projects SET row_version = COALESCE((SELECT MAX(version) FROM sync_log WHERE table_name = 'projects' AND entity_id = projects.id), 0);
+UPDATE kanban_cards SET row_version = COALESCE((SELECT MAX(version) FROM sync_log WHERE table_name = 'kanban_cards' AND entity_id = kanban_cards.id), 0);
+UPDATE kanban_columns SET row_version = COALESCE((SELECT MAX(version) FROM sync_log WHERE table_name = 'kanban_columns' AND entity_id = kanban_columns.id), 0);
+UPDATE products SET row_version = COALESCE((SELECT MAX(version) FROM sync_log WHERE table_name = 'products' AND entity_id = products.id), 0);
+UPDATE os_orders SET row_version = COALESCE((SELECT MAX(version) FROM sync_log WHERE table_name = 'os_orders' AND entity_id = os_orders.uuid), 0);
+UPDATE os_items SET row_version = COALESCE((SELECT MAX(version) FROM sync_log WHERE table_name = 'os_items' AND entity_id = os_items.id), 0);
+UPDATE card_tags SET row_version = COALESCE((SELECT MAX(version) FROM sync_log WHERE table_name = 'card_tags' AND entity_id = card_tags.id), 0);
+UPDATE card_assigned_users SET row_version = COALESCE((SELECT MAX(version) FROM sync_log WHERE table_name = 'card_assigned_users' AND entity_id = card_assigned_users.id), 0);
+UPDATE card_approvers SET row_version = COALESCE((SELECT MAX(version) FROM sync_log WHERE table_name = 'card_approvers' AND entity_id = card_approvers.id), 0);
+UPDATE card_sessions SET row_version = COALESCE((SELECT MAX(version) FROM sync_log WHERE table_name = 'card_sessions' AND entity_id = card_sessions.id), 0);
+
+-- Keep row_version in step with every sync_log write, whichever code path produced it
+CREATE TRIGGER IF NOT EXISTS sync_log_row_version_projects AFTER INSERT ON sync_log
+WHEN NEW.table_name = 'projects'
+BEGIN
+    UPDATE projects SET row_version = NEW.version WHERE id = NEW.entity_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_log_row_version_kanban_cards AFTER INSERT ON sync_log
+WHEN NEW.table_name = 'kanban_cards'
+BEGIN
+    UPDATE kanban_cards SET row_version = NEW.version WHERE id = NEW.entity_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_log_row_version_kanban_columns AFTER INSERT ON sync_log
+WHEN NEW.table_name = 'kanban_columns'
+BEGIN
+    UPDATE kanban_columns SET row_version = NEW.version WHERE id = NEW.entity_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_log_row_version_products AFTER INSERT ON sync_log
+WHEN NEW.table_name = 'products'
+BEGIN
+    UPDATE products SET row_version = NEW.version WHERE id = NEW.entity_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_log_row_version_os_orders AFTER INSERT ON sync_log
+WHEN NEW.table_name = 'os_orders'
+BEGIN
+    UPDATE os_orders SET row_version = NEW.version WHERE uuid = NEW.entity_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_log_row_version_os_items AFTER INSERT ON sync_log
+WHEN NEW.table_name = 'os_items'
+BEGIN
+    UPDATE os_items SET row_version = NEW.version WHERE id = NEW.entity_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_log_row_version_card_tags AFTER INSERT ON sync_log
+WHEN NEW.table_name = 'card_tags'
+BEGIN
+    UPDATE card_tags SET row_version = NEW.version WHERE id = NEW.entity_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_log_row_version_card_assigned_users AFTER INSERT ON sync_log
+WHEN NEW.table_name = 'card_assigned_users'
+BEGIN
+    UPDATE card_assigned_users SET row_version = NEW.version WHERE id = NEW.entity_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_log_row_version_card_approvers AFTER INSERT ON sync_log
+WHEN NEW.table_name = 'card_approvers'
+BEGIN
+    UPDATE card_approvers SET row_version = NEW.version WHERE id = NEW.entity_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS sync_log_row_version_card_sessions AFTER INSERT ON sync_log
+WHEN NEW.table_name = 'card_sessions'
+BEGIN
+    UPDATE card_sessions SET row_version = NEW.version WHERE id = NEW.entity_id;
+END;
@@ -0,0 +1,4 @@
+-- Stop tracking the version high-water mark. The sync_state row stays: the up
+-- migration only ever raises it.
+
+DROP TRIGGER IF EXISTS sync_log_version_high_water;
@@ -0,0 +1,15 @@
+-- sync_state('version') holds the tenant version counter. Seed it from the
+-- existing log and keep it at or above any version written to sync_log
+-- directly (e.g. by cmd/seed), so allocated versions never collide.
+
+INSERT INTO sync_state (key, value)
+SELECT 'version', MAX(COALESCE((SELECT MAX(version) FROM sync_log), 0),
+                      COALESCE((SELECT value FROM sync_state WHERE key = 'compacted_version'), 0))
+WHERE true
+ON CONFLICT(key) DO UPDATE SET value = MAX(value, excluded.value);
+
+CREATE TRIGGER IF NOT EXISTS sync_log_version_high_water AFTER INSERT ON sync_log
+BEGIN
+    INSERT INTO sync_state (key, value) VALUES ('version', NEW.version)
+    ON CONFLICT(key) DO UPDATE SET value = MAX(value, excluded.value);
+END;
@@ -0,0 +1,5 @@
+-- Untag sync_log entries. The tags are derived, so the up migration restores them.
+
+DROP TRIGGER IF EXISTS sync_log_project;
+DROP INDEX IF EXISTS idx_sync_log_project;
+ALTER TABLE sync_log DROP COLUMN project_id;
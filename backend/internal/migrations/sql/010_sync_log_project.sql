@@ -0,0 +1,60 @@
+-- Tag sync_log entries with the project they belong to, so clients can
+-- subscribe to a single project. Card children follow their card, order items
+-- their order; entries without a payload (DELETE, most PATCH) inherit the tag
+-- of the entity's previous entry. Tenant-wide tables (products, users) stay NULL.
+
+ALTER TABLE sync_log ADD COLUMN project_id TEXT;
+
+UPDATE sync_log SET project_id = CASE table_name
+    WHEN 'projects' THEN entity_id
+    WHEN 'kanban_columns' THEN json_extract(payload, '$.project_id')
+    WHEN 'kanban_cards' THEN json_extract(payload, '$.project_id')
+    WHEN 'os_orders' THEN COALESCE(json_extract(payload, '$.project_id'),
+                                   (SELECT project_id FROM kanban_cards WHERE id = json_extract(sync_log.payload, '$.card_id')))
+    WHEN 'card_tags' THEN (SELECT project_id FROM kanban_cards WHERE id = json_extract(sync_log.payload, '$.card_id'))
+    WHEN 'card_assigned_users' THEN (SELECT project_id FROM kanban_cards WHERE id = json_extract(sync_log.payload, '$.card_id'))
+    WHEN 'card_approvers' THEN (SELECT project_id FROM kanban_cards WHERE id = json_extract(sync_log.payload, '$.card_id'))
+    WHEN 'card_sessions' THEN (SELECT project_id FROM kanban_cards WHERE id = json_extract(sync_log.payload, '$.card_id'))
+END;
+
+UPDATE sync_log SET project_id = (
+    SELECT prev.project_id FROM sync_log prev
+    WHERE prev.table_name = sync_log.table_name AND prev.entity_id = sync_log.entity_id
+      AND prev.project_id IS NOT NULL
+    ORDER BY prev.id DESC LIMIT 1
+) WHERE project_id IS NULL AND table_name NOT IN ('products', 'users');
+
+UPDATE sync_log SET project_id = (
+    SELECT o.project_id FROM sync_log o
+    WHERE o.table_name = 'os_orders' AND o.entity_id = json_extract(sync_log.payload, '$.order_id')
+    ORDER BY o.id DESC LIMIT 1
+) WHERE table_name = 'os_items' AND project_id IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_sync_log_project ON sync_log(project_id, version);
+
+CREATE TRIGGER IF NOT EXISTS sync_log_project AFTER INSERT ON sync_log
+WHEN NEW.project_id IS NULL AND NEW.table_name NOT IN ('products', 'users')
+BEGIN
+    UPDATE sync_log SET project_id = COALESCE(
+        CASE NEW.table_name
+            WHEN 'projects' THEN NEW.entity_id
+            WHEN 'kanban_columns' THEN json_extract(NEW.payload, '$.project_id')
+            WHEN 'kanban_cards' THEN COALESCE(json_extract(NEW.payload, '$.project_id'),
+                                              json_extract(NEW.payload, '$.changes.project_id.new'))
+            WHEN 'os_orders' THEN COALESCE(json_extract(NEW.payload, '$.project_id'),
+                                           (SELECT project_id FROM kanban_cards WHERE id = json_extract(NEW.payload, '$.card_id')))
+            WHEN 'card_tags' THEN (SELECT project_id FROM kanban_cards WHERE id = json_extract(NEW.payload, '$.card_id'))
+            WHEN 'card_assigned_users' THEN (SELECT project_id FROM kanban_cards WHERE id = json_extract(NEW.payload, '$.card_id'))
+            WHEN 'card_approvers' THEN (SELECT project_id FROM kanban_cards WHERE id = json_extract(NEW.payload, '$.card_id'))
+            WHEN 'card_sessions' THEN (SELECT project_id FROM kanban_cards WHERE id = json_extract(NEW.payload, '$.card_id'))
+            WHEN 'os_items' THEN (SELECT o.project_id FROM sync_log o
+                                  WHERE o.table_name = 'os_orders' AND o.entity_id = json_extract(NEW.payload, '$.order_id')
+                                  ORDER BY o.id DESC LIMIT 1)
+        END,
+        (SELECT prev.project_id FROM sync_log prev
+         WHERE prev.table_name = NEW.table_name AND prev.entity_id = NEW.entity_id
+           AND prev.id < NEW.id AND prev.project_id IS NOT NULL
+         ORDER BY prev.id DESC LIMIT 1)
+    )
+    WHERE id = NEW.id;
+END;
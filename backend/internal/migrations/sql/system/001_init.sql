@@ -0,0 +1,27 @@
+-- Users and the tenant registry. system.db files created before versioned
+-- migrations already have these tables, so everything here must stay
+-- idempotent.
+
+CREATE TABLE IF NOT EXISTS users (
+    id            TEXT PRIMARY KEY,
+    email         TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    tenant_id     TEXT NOT NULL,
+    created_at    TEXT NOT NULL DEFAULT (datetime('now'))
+);
+CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
+CREATE INDEX IF NOT EXISTS idx_users_tenant ON users(tenant_id);
+
+CREATE TABLE IF NOT EXISTS tenants (
+    id         TEXT PRIMARY KEY,
+    status     TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'suspended', 'deleted')),
+    plan       TEXT NOT NULL DEFAULT 'free',
+    reason     TEXT NOT NULL DEFAULT '',
+    created_by TEXT NOT NULL,
+    created_at TEXT NOT NULL DEFAULT (datetime('now')),
+    updated_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
+
+-- Tenants predating the registry existed only through their users
+INSERT OR IGNORE INTO tenants (id, created_by, created_at)
+    SELECT tenant_id, 'backfill', MIN(created_at) FROM users GROUP BY tenant_id;
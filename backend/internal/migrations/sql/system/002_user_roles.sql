@@ -0,0 +1,8 @@
+-- Roles within a tenant. Until now every user could do everything; the first
+-- user of each tenant (the one who signed it up, as IDs are UUIDv7) becomes its
+-- owner and the others members.
+
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'member'
+    CHECK (role IN ('owner', 'admin', 'member', 'cashier', 'viewer'));
+
+UPDATE users SET role = 'owner' WHERE id IN (SELECT MIN(id) FROM users GROUP BY tenant_id);
@@ -0,0 +1,208 @@
+// Package provision administers the tenant lifecycle: tenants are registered
+// in system.db (which tenant.Manager consults before opening a database) and
+// their databases created, suspended, exported and deleted.
+package provision
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ouroboros/backend/internal/auth"
+	"github.com/ouroboros/backend/internal/backup"
+	"github.com/ouroboros/backend/internal/replica"
+	"github.com/ouroboros/backend/internal/sync"
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+// Service carries out lifecycle changes on the registry and the tenant's files.
+type Service struct {
+	sdb      *auth.SystemDB
+	tm       *tenant.Manager
+	hub      *sync.Hub           // nil outside the API server
+	backups  *backup.Store       // nil to leave snapshots alone
+	replicas *replica.Replicator // nil to leave WAL replicas alone
+}
+
+// NewService returns a Service. hub, backups and replicas may be nil; with a
+// hub, suspending or deleting a tenant disconnects its streams, and with a
+// backup store or replicator, deleting a tenant deletes its snapshots or its
+// replica too.
+func NewService(sdb *auth.SystemDB, tm *tenant.Manager, hub *sync.Hub, backups *backup.Store, replicas *replica.Replicator) *Service {
+	return &Service{sdb: sdb, tm: tm, hub: hub, backups: backups, replicas: replicas}
+}
+
+// Create registers a tenant and creates its database, or adopts the one an
+// unregistered tenant left in the data directory.
+func (s *Service) Create(id, plan, createdBy string) (*auth.Tenant, error) {
+	if !tenant.ValidID(id) {
+		return nil, tenant.ErrInvalidID
+	}
+	t, err := s.sdb.CreateTenant(id, plan, createdBy)
+	if err != nil {
+		return nil, err
+	}
+	_, release, err := s.tm.Acquire(id)
+	if err != nil {
+		return nil, fmt.Errorf("create database: %w", err)
+	}
+	release()
+	log.Printf("[tenant] provisioned tenant %s (plan %s)", id, t.Plan)
+	return t, nil
+}
+
+// Suspend refuses the tenant's logins and requests from now on, and closes
+// its streams. Its data is kept.
+func (s *Service) Suspend(id, reason string) (*auth.Tenant, error) {
+	t, err := s.sdb.SetTenantStatus(id, auth.TenantSuspended, reason)
+	if err != nil {
+		return nil, err
+	}
+	if s.hub != nil {
+		s.hub.Disconnect(id)
+	}
+	log.Printf("[tenant] suspended tenant %s: %s", id, reason)
+	return t, nil
+}
+
+// Resume reactivates a suspended tenant.
+func (s *Service) Resume(id string) (*auth.Tenant, error) {
+	t, err := s.sdb.SetTenantStatus(id, auth.TenantActive, "")
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[tenant] resumed tenant %s", id)
+	return t, nil
+}
+
+// Delete removes a tenant's users, database, snapshots and replica for good.
+// The registry entry stays, marked deleted, so the ID cannot come back to
+// life. Deleting an already deleted tenant finishes a removal that failed
+// midway.
+func (s *Service) Delete(ctx context.Context, id string) error {
+	if err := s.sdb.DeleteTenant(id); err != nil {
+		return err
+	}
+	// Requests were refused from the line above on; wait out those in flight
+	var drain func()
+	if s.hub != nil {
+		drain = func() { s.hub.Disconnect(id) }
+	}
+	err := s.tm.Exclusive(ctx, id, drain, func(path string) error {
+		for _, p := range []string{path, path + "-wal", path + "-shm"} {
+			if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if s.backups != nil {
+		if err := s.backups.DeleteAll(id); err != nil {
+			return fmt.Errorf("delete snapshots: %w", err)
+		}
+	}
+	if s.replicas != nil {
+		if err := s.replicas.Purge(ctx, id); err != nil {
+			return fmt.Errorf("delete replica: %w", err)
+		}
+	}
+	log.Printf("[tenant] deleted tenant %s", id)
+	return nil
+}
+
+// Export is a copy of a tenant's data, taken by Service.Export. Close it
+// once written.
+type Export struct {
+	Tenant     *auth.Tenant
+	Users      []auth.User
+	ExportedAt time.Time
+
+	dir    string
+	dbPath string // "" if the tenant has no database yet
+}
+
+// Export copies a tenant's database (suspended tenants included) and
+// registry entry for WriteZip.
+func (s *Service) Export(ctx context.Context, id string) (*Export, error) {
+	t, err := s.sdb.GetTenant(id)
+	if err != nil {
+		return nil, err
+	}
+	if t.Status == auth.TenantDeleted {
+		return nil, auth.ErrTenantDeleted
+	}
+	users, err := s.sdb.ListByTenant(id)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "tenant-export-")
+	if err != nil {
+		return nil, err
+	}
+	e := &Export{Tenant: t, Users: users, ExportedAt: time.Now().UTC(), dir: dir}
+
+	// A lease would be refused for a suspended tenant; the copy is a single
+	// read transaction, so it needs none to be consistent.
+	src, err := s.tm.Path(id)
+	if err != nil {
+		e.Close()
+		return nil, err
+	}
+	if _, err := os.Stat(src); err == nil {
+		e.dbPath = filepath.Join(dir, "tenant.db")
+		if err := backup.Copy(ctx, src, e.dbPath); err != nil {
+			e.Close()
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// WriteZip writes the export as a zip archive holding tenant.json (the
+// registry entry and users) and tenant.db.
+func (e *Export) WriteZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	meta, err := zw.CreateHeader(&zip.FileHeader{Name: "tenant.json", Method: zip.Deflate, Modified: e.ExportedAt})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(meta)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(map[string]any{
+		"tenant":      e.Tenant,
+		"users":       e.Users,
+		"exported_at": e.ExportedAt,
+	}); err != nil {
+		return err
+	}
+	if e.dbPath != "" {
+		f, err := os.Open(e.dbPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		dst, err := zw.CreateHeader(&zip.FileHeader{Name: "tenant.db", Method: zip.Deflate, Modified: e.ExportedAt})
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(dst, f); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// Close deletes the export's temporary files.
+func (e *Export) Close() error {
+	return os.RemoveAll(e.dir)
+}
@@ -0,0 +1,215 @@
+package provision
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ouroboros/backend/internal/auth"
+	"github.com/ouroboros/backend/internal/backup"
+	"github.com/ouroboros/backend/internal/sync"
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+type testEnv struct {
+	t       *testing.T
+	sdb     *auth.SystemDB
+	tm      *tenant.Manager
+	hub     *sync.Hub
+	backups *backup.Store
+	svc     *Service
+}
+
+// newTestEnv provisions tenant acme with one member and one product.
+func newTestEnv(t *testing.T) *testEnv {
+	sdb, err := auth.OpenSystemDB(filepath.Join(t.TempDir(), "system.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sdb.Close() })
+	tm := tenant.NewManager(t.TempDir(), 4)
+	tm.SetRegistry(sdb)
+	t.Cleanup(tm.CloseAll)
+	hub := sync.NewHub(sync.NewLocalBackend())
+	backups := backup.NewStore(t.TempDir(), tm, hub)
+	e := &testEnv{t: t, sdb: sdb, tm: tm, hub: hub, backups: backups, svc: NewService(sdb, tm, hub, backups, nil)}
+
+	if _, err := e.svc.Create("acme", "", "cli"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sdb.Invite("ana@acme.io", "secret1", "acme", auth.RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	db, release, err := tm.Acquire("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if _, err := db.Exec("INSERT INTO products (id, name, price) VALUES ('x', 'Widget', 2)"); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// usable reports the error Acquire returns for acme.
+func (e *testEnv) usable() error {
+	_, release, err := e.tm.Acquire("acme")
+	if err == nil {
+		release()
+	}
+	return err
+}
+
+func TestSuspendResume(t *testing.T) {
+	e := newTestEnv(t)
+	sub, unsubscribe, err := e.hub.Subscribe("acme", "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	tn, err := e.svc.Suspend("acme", "unpaid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tn.Status != auth.TenantSuspended || tn.Reason != "unpaid" {
+		t.Errorf("suspended tenant = %+v", tn)
+	}
+	if err := e.usable(); !errors.Is(err, tenant.ErrSuspended) {
+		t.Errorf("Acquire of a suspended tenant: %v, want ErrSuspended", err)
+	}
+	if _, ok := <-sub.C; ok {
+		t.Error("stream still open after suspending")
+	}
+
+	if _, err := e.svc.Resume("acme"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.usable(); err != nil {
+		t.Errorf("Acquire after resuming: %v", err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	if _, err := e.backups.Create(ctx, "acme"); err != nil {
+		t.Fatal(err)
+	}
+	path, err := e.tm.Path("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The database is still open, so its WAL files exist too
+	files := []string{path, path + "-wal", path + "-shm"}
+	for _, f := range files {
+		if _, err := os.Stat(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := e.svc.Delete(ctx, "acme"); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if _, err := os.Stat(f); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s left behind: %v", filepath.Base(f), err)
+		}
+	}
+	if snaps, err := e.backups.List("acme"); err != nil || len(snaps) != 0 {
+		t.Errorf("snapshots left: %v, %v", snaps, err)
+	}
+	if users, err := e.sdb.ListByTenant("acme"); err != nil || len(users) != 0 {
+		t.Errorf("users left: %v, %v", users, err)
+	}
+
+	// The ID stays taken and unusable
+	if err := e.usable(); !errors.Is(err, tenant.ErrUnknownTenant) {
+		t.Errorf("Acquire of a deleted tenant: %v, want ErrUnknownTenant", err)
+	}
+	if _, err := e.svc.Create("acme", "", "cli"); !errors.Is(err, auth.ErrTenantExists) {
+		t.Errorf("recreating a deleted tenant: %v, want ErrTenantExists", err)
+	}
+	if _, err := e.svc.Resume("acme"); err == nil {
+		t.Error("a deleted tenant was resumed")
+	}
+	if _, err := e.svc.Export(ctx, "acme"); !errors.Is(err, auth.ErrTenantDeleted) {
+		t.Errorf("exporting a deleted tenant: %v, want ErrTenantDeleted", err)
+	}
+	if err := e.svc.Delete(ctx, "acme"); err != nil {
+		t.Errorf("deleting again: %v", err)
+	}
+}
+
+func TestExportSuspended(t *testing.T) {
+	e := newTestEnv(t)
+	if _, err := e.svc.Suspend("acme", "closing"); err != nil {
+		t.Fatal(err)
+	}
+	exp, err := e.svc.Export(context.Background(), "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := exp.WriteZip(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := exp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(exp.dir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("export directory left behind: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[f.Name], err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(contents) != 2 {
+		t.Fatalf("archive holds %d files, want tenant.json and tenant.db", len(contents))
+	}
+
+	var meta struct {
+		Tenant auth.Tenant `json:"tenant"`
+		Users  []auth.User `json:"users"`
+	}
+	if err := json.Unmarshal(contents["tenant.json"], &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Tenant.ID != "acme" || meta.Tenant.Status != auth.TenantSuspended || len(meta.Users) != 1 || meta.Users[0].Email != "ana@acme.io" {
+		t.Errorf("tenant.json = %s", contents["tenant.json"])
+	}
+
+	dbPath := filepath.Join(t.TempDir(), "tenant.db")
+	if err := os.WriteFile(dbPath, contents["tenant.db"], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var name string
+	if err := db.QueryRow("SELECT name FROM products WHERE id = 'x'").Scan(&name); err != nil || name != "Widget" {
+		t.Errorf("exported product: %q, %v", name, err)
+	}
+}
@@ -0,0 +1,541 @@
+// Package replica continuously ships tenant databases to an object store, in
+// the style of Litestream, so a tenant can be restored as of any point in
+// time rather than its last snapshot.
+//
+// While a tenant database is open, its replica is a series of generations.
+// A generation starts with a copy of the database file and continues with
+// every committed WAL frame, shipped in segments each sync interval. The
+// replicator owns checkpointing: it turns automatic checkpoints off and
+// checkpoints itself only after shipping, so the log never restarts over
+// frames that were not shipped yet. A new generation starts whenever the
+// database is (re)opened or continuity is lost.
+//
+// Objects are laid out per tenant as
+//
+//	<tenant>/<generation>/snapshot.db.gz
+//	<tenant>/<generation>/wal/<cycle>/<offset>-<unix ms>.wal.gz
+//
+// where generation is its UTC start time, cycle counts the WAL restarts
+// within the generation and offset is the segment's position in that cycle's
+// WAL file.
+package replica
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	gosync "sync"
+	"time"
+
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+const (
+	genLayout = "20060102T150405.000Z"
+	// checkpointFrames is the WAL length at which a sync checkpoints,
+	// SQLite's own automatic checkpoint threshold.
+	checkpointFrames = 1000
+	// maxPendingBytes bounds what a replica buffers while the store is
+	// unreachable; past it the buffer is dropped and a new generation started.
+	maxPendingBytes = 64 << 20
+)
+
+var errWALGap = errors.New("wal restarted past unshipped frames")
+
+// Replicator replicates every tenant database opened by a tenant.Manager; it
+// is registered as the Manager's Lifecycle.
+type Replicator struct {
+	store     Store
+	interval  time.Duration
+	retention time.Duration
+
+	mu       gosync.Mutex
+	replicas map[*sql.DB]*replica // by write handle
+	latest   map[string]*replica  // by tenant, until it and those before it are shipped
+	wg       gosync.WaitGroup
+}
+
+// New returns a Replicator shipping to store every interval and keeping the
+// generations needed to restore any point in the last retention.
+func New(store Store, interval, retention time.Duration) *Replicator {
+	return &Replicator{
+		store:     store,
+		interval:  interval,
+		retention: retention,
+		replicas:  make(map[*sql.DB]*replica),
+		latest:    make(map[string]*replica),
+	}
+}
+
+// Opened starts replicating a tenant database. Besides turning automatic
+// checkpoints off, it only opens the connection that keeps the WAL around
+// after the Manager closes the database; see Closing.
+func (rp *Replicator) Opened(tenantID, path string, db *sql.DB) {
+	r := &replica{rp: rp, tenantID: tenantID, path: path, db: db,
+		stop: make(chan struct{}), done: make(chan struct{}), shipped: make(chan struct{})}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "PRAGMA wal_autocheckpoint = 0"); err != nil {
+		log.Printf("[replica] tenant %s: %v", tenantID, err)
+	}
+	pin, err := sql.Open("sqlite3", path+"?_busy_timeout=5000")
+	if err == nil {
+		pin.SetMaxOpenConns(1)
+		if _, err = pin.ExecContext(ctx, "PRAGMA user_version"); err != nil {
+			pin.Close()
+		}
+	}
+	if err != nil {
+		log.Printf("[replica] tenant %s: pin wal: %v", tenantID, err)
+	} else {
+		r.pin = pin
+	}
+
+	rp.mu.Lock()
+	rp.replicas[db] = r
+	if prev := rp.latest[tenantID]; prev != nil {
+		r.prevDone, r.prevShipped = prev.done, prev.shipped
+	}
+	rp.latest[tenantID] = r
+	rp.mu.Unlock()
+	rp.wg.Add(1)
+	go r.run()
+}
+
+// Closing stops replicating a tenant database. The frames committed since the
+// last sync are shipped from the replica's goroutine: its own connection to
+// the database keeps SQLite from checkpointing and deleting the WAL when the
+// Manager closes it. The returned channel is closed once the frames are
+// captured and that connection closed too; uploading them may take longer.
+// See Close.
+func (rp *Replicator) Closing(tenantID string, db *sql.DB) <-chan struct{} {
+	rp.mu.Lock()
+	r := rp.replicas[db]
+	delete(rp.replicas, db)
+	rp.mu.Unlock()
+	if r == nil {
+		return nil
+	}
+	close(r.stop)
+	return r.done
+}
+
+// Close waits until the replicas of closed databases are shipped. Call it
+// after tenant.Manager.CloseAll.
+func (rp *Replicator) Close(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		rp.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("replica: shipping unfinished: %w", ctx.Err())
+	}
+}
+
+// Hooks returns hooks with the checkpoint hook replaced by one that ships the
+// WAL before truncating it.
+func (rp *Replicator) Hooks(hooks []tenant.Hook) []tenant.Hook {
+	out := make([]tenant.Hook, len(hooks))
+	for i, h := range hooks {
+		if h.Name == "checkpoint" {
+			h.Run = rp.checkpoint
+		}
+		out[i] = h
+	}
+	return out
+}
+
+func (rp *Replicator) checkpoint(ctx context.Context, db *sql.DB) error {
+	rp.mu.Lock()
+	r := rp.replicas[db]
+	rp.mu.Unlock()
+	if r == nil {
+		return fmt.Errorf("database is not replicated")
+	}
+	if !r.started() {
+		return fmt.Errorf("previous replica still shipping")
+	}
+	return r.sync(ctx, "TRUNCATE")
+}
+
+// object is a captured object waiting to be uploaded.
+type object struct {
+	key      string
+	data     []byte
+	snapshot bool
+}
+
+// replica ships one open tenant database.
+type replica struct {
+	rp       *Replicator
+	tenantID string
+	path     string
+	db       *sql.DB
+	pin      *sql.DB // own connection, keeping the WAL until shipped
+	stop     chan struct{}
+	done     chan struct{} // closed once the files are no longer read
+	shipped  chan struct{} // closed once this and earlier replicas of the tenant are uploaded
+
+	// Of the previous replica of the tenant: shipping starts once it is done
+	prevDone, prevShipped <-chan struct{}
+
+	mu      gosync.Mutex // guards the fields below
+	gen     string       // current generation; "" to start one
+	cycle   int          // WAL restarts within the generation
+	hdr     walHeader    // of the WAL cycle being shipped; zero until one is seen
+	offset  int64        // bytes of the cycle shipped
+	cksum   [2]uint32    // running WAL checksum at offset
+	pending []object
+	size    int // bytes in pending
+	failing bool
+}
+
+func (r *replica) run() {
+	defer r.rp.wg.Done()
+	defer func() {
+		if r.prevShipped != nil {
+			<-r.prevShipped
+		}
+		r.rp.mu.Lock()
+		if r.rp.latest[r.tenantID] == r {
+			delete(r.rp.latest, r.tenantID)
+		}
+		r.rp.mu.Unlock()
+		close(r.shipped)
+	}()
+	if r.prevDone != nil {
+		// The previous replica of this tenant may still be reading the files
+		<-r.prevDone
+	}
+	ticker := time.NewTicker(r.rp.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			if err := r.finish(); err != nil {
+				log.Printf("[replica] tenant %s: final sync: %v", r.tenantID, err)
+			}
+			r.detach()
+			r.upload(context.Background())
+			return
+		case <-ticker.C:
+			// A deadline, so a long transaction cannot hold up the replica
+			ctx, cancel := context.WithTimeout(context.Background(), r.rp.interval)
+			if err := r.sync(ctx, ""); err != nil {
+				log.Printf("[replica] tenant %s: %v", r.tenantID, err)
+			}
+			cancel()
+			r.upload(context.Background())
+		}
+	}
+}
+
+// started reports whether the previous replica of the tenant is done, so
+// this one may touch the files.
+func (r *replica) started() bool {
+	if r.prevDone == nil {
+		return true
+	}
+	select {
+	case <-r.prevDone:
+		return true
+	default:
+		return false
+	}
+}
+
+// detach closes the pin, letting SQLite checkpoint and delete the WAL if the
+// database is not open elsewhere.
+func (r *replica) detach() {
+	if r.pin != nil {
+		if err := r.pin.Close(); err != nil {
+			log.Printf("[replica] tenant %s: close: %v", r.tenantID, err)
+		}
+	}
+	close(r.done)
+}
+
+// sync captures the frames committed since the last sync, starting a
+// generation first if needed, and checkpoints when the WAL has grown long
+// (or always, in the given mode).
+func (r *replica) sync(ctx context.Context, checkpointMode string) error {
+	// The write handle has a single connection: nothing commits while it is held
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Re-applied every time in case database/sql replaced the connection
+	if _, err := conn.ExecContext(ctx, "PRAGMA wal_autocheckpoint = 0"); err != nil {
+		return err
+	}
+	if err := r.capture(); err != nil {
+		return err
+	}
+
+	frames := (r.offset - walHeaderSize) / int64(walFrameHeaderSize+max(r.hdr.pageSize, 1))
+	if checkpointMode == "" && frames < checkpointFrames {
+		return nil
+	}
+	if checkpointMode == "" {
+		checkpointMode = "PASSIVE"
+	}
+	if info, err := os.Stat(r.path + "-wal"); err != nil || info.Size() == 0 {
+		return nil // nothing to checkpoint; a TRUNCATE would still restart the log
+	}
+	var busy, logFrames, checkpointed int
+	if err := conn.QueryRowContext(ctx, "PRAGMA wal_checkpoint("+checkpointMode+")").Scan(&busy, &logFrames, &checkpointed); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	if busy != 0 && checkpointMode != "PASSIVE" {
+		return fmt.Errorf("checkpoint blocked (%d of %d frames copied)", checkpointed, logFrames)
+	}
+	return nil
+}
+
+// finish captures the frames committed since the last sync as the database
+// is closed, from the WAL the pin kept in place.
+func (r *replica) finish() error {
+	if r.pin == nil {
+		return errors.New("wal not pinned; frames since the last sync ship with the next generation")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.capture()
+}
+
+// capture queues the frames committed since the last sync, starting a
+// generation first if needed. Expects r.mu to be held, and the write
+// connection or a closed database.
+func (r *replica) capture() error {
+	if r.size > maxPendingBytes {
+		log.Printf("[replica] tenant %s: store unreachable, dropping %d buffered bytes", r.tenantID, r.size)
+		r.pending, r.size, r.gen = nil, 0, ""
+	}
+	if r.gen == "" {
+		if err := r.snapshot(); err != nil {
+			return err
+		}
+	}
+	err := r.captureWAL()
+	if errors.Is(err, errWALGap) {
+		log.Printf("[replica] tenant %s: %v, starting a new generation", r.tenantID, err)
+		if err := r.snapshot(); err != nil {
+			return err
+		}
+		err = r.captureWAL()
+	}
+	return err
+}
+
+// snapshot starts a generation with a copy of the database file. The WAL
+// cycle in progress is then shipped from its start, so the frames not yet
+// checkpointed into the file are replayed on restore. Expects r.mu to be
+// held, and the write connection.
+func (r *replica) snapshot() error {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+	gz, err := gzipBytes(data)
+	if err != nil {
+		return err
+	}
+	r.gen = time.Now().UTC().Format(genLayout)
+	r.cycle, r.hdr, r.offset, r.cksum = 0, walHeader{}, 0, [2]uint32{}
+	r.queue(object{key: r.tenantID + "/" + r.gen + "/snapshot.db.gz", data: gz, snapshot: true})
+	log.Printf("[replica] tenant %s: started generation %s (%d bytes)", r.tenantID, r.gen, len(data))
+	return nil
+}
+
+// captureWAL queues the committed frames past the shipped position. Expects
+// r.mu to be held, and the write connection.
+func (r *replica) captureWAL() error {
+	f, err := os.Open(r.path + "-wal")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	head := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(f, head); err != nil {
+		return nil // truncated by a checkpoint; the next write starts a new cycle
+	}
+	h, err := parseWALHeader(head)
+	if errors.Is(err, errNoWAL) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if h.salt != r.hdr.salt {
+		if r.hdr.pageSize != 0 {
+			if h.ckptSeq != r.hdr.ckptSeq+1 {
+				return fmt.Errorf("%w (checkpoint %d after %d)", errWALGap, h.ckptSeq, r.hdr.ckptSeq)
+			}
+			r.cycle++
+		}
+		r.hdr, r.offset, r.cksum = h, 0, h.cksum
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	start := max(r.offset, walHeaderSize)
+	if info.Size() <= start {
+		return nil
+	}
+	data := make([]byte, info.Size()-start)
+	if _, err := f.ReadAt(data, start); err != nil && err != io.EOF {
+		return err
+	}
+	n, cksum := h.scanFrames(data, r.cksum)
+	if n == 0 {
+		return nil
+	}
+	seg := data[:n]
+	if r.offset == 0 { // a cycle's first segment carries its header
+		seg = append(head, seg...)
+	}
+	gz, err := gzipBytes(seg)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s/%s/wal/%08x/%016x-%d.wal.gz", r.tenantID, r.gen, r.cycle, r.offset, time.Now().UnixMilli())
+	r.queue(object{key: key, data: gz})
+	r.offset, r.cksum = start+int64(n), cksum
+	return nil
+}
+
+// queue adds an object to upload. Expects r.mu to be held.
+func (r *replica) queue(obj object) {
+	r.pending = append(r.pending, obj)
+	r.size += len(obj.data)
+}
+
+// upload ships the pending objects in order, stopping at the first failure
+// (the rest is retried next sync).
+func (r *replica) upload(ctx context.Context) {
+	for {
+		r.mu.Lock()
+		if len(r.pending) == 0 {
+			r.mu.Unlock()
+			return
+		}
+		obj := r.pending[0]
+		r.mu.Unlock()
+
+		err := r.rp.store.Put(ctx, obj.key, obj.data)
+		r.mu.Lock()
+		if err != nil {
+			if !r.failing {
+				log.Printf("[replica] tenant %s: upload failed, retrying: %v", r.tenantID, err)
+			}
+			r.failing = true
+			r.mu.Unlock()
+			return
+		}
+		if r.failing {
+			log.Printf("[replica] tenant %s: uploads resumed", r.tenantID)
+		}
+		r.failing = false
+		if len(r.pending) > 0 && r.pending[0].key == obj.key { // not dropped meanwhile
+			r.pending = r.pending[1:]
+			r.size -= len(obj.data)
+		}
+		r.mu.Unlock()
+
+		if obj.snapshot {
+			if err := r.rp.prune(ctx, r.tenantID); err != nil {
+				log.Printf("[replica] tenant %s: prune: %v", r.tenantID, err)
+			}
+		}
+	}
+}
+
+// prune deletes the generations no longer needed to restore a point within
+// the retention: those followed by a generation that started before it.
+func (rp *Replicator) prune(ctx context.Context, tenantID string) error {
+	gens, err := Generations(ctx, rp.store, tenantID)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-rp.retention)
+	for i := 0; i+1 < len(gens); i++ {
+		if gens[i+1].Start.After(cutoff) {
+			break
+		}
+		for _, key := range gens[i].keys {
+			if err := rp.store.Delete(ctx, key); err != nil {
+				return err
+			}
+		}
+		log.Printf("[replica] tenant %s: pruned generation %s", tenantID, gens[i].ID)
+	}
+	return nil
+}
+
+// Purge deletes a tenant's replica once the replicas of its closed databases
+// are shipped. Call it for a deleted tenant, after closing its database.
+func (rp *Replicator) Purge(ctx context.Context, tenantID string) error {
+	rp.mu.Lock()
+	r := rp.latest[tenantID]
+	rp.mu.Unlock()
+	if r != nil {
+		select {
+		case <-r.shipped:
+		case <-ctx.Done():
+			return fmt.Errorf("replica: shipping unfinished: %w", ctx.Err())
+		}
+	}
+	return Purge(ctx, rp.store, tenantID)
+}
+
+// Purge deletes every generation of a tenant from store.
+func Purge(ctx context.Context, store Store, tenantID string) error {
+	if !tenant.ValidID(tenantID) {
+		return tenant.ErrInvalidID
+	}
+	keys, err := store.List(ctx, tenantID+"/")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := store.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	if len(keys) > 0 {
+		log.Printf("[replica] tenant %s: purged %d objects", tenantID, len(keys))
+	}
+	return nil
+}
+
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,77 @@
+package replica
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+// TestReplicateOnClose writes to a tenant database that is closed before the
+// first sync is due, so everything is shipped by the closing replica.
+func TestReplicateOnClose(t *testing.T) {
+	dir := t.TempDir()
+	dataDir := filepath.Join(dir, "data")
+	if err := os.Mkdir(dataDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	store := NewFileStore(filepath.Join(dir, "replica"))
+	rp := New(store, time.Hour, time.Hour)
+	tm := tenant.NewManager(dataDir, 2)
+	tm.SetLifecycle(rp)
+	ctx := context.Background()
+
+	insert := func(n int) {
+		t.Helper()
+		db, release, err := tm.Acquire("acme")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+		for i := 0; i < n; i++ {
+			if _, err := db.Exec("INSERT INTO projects (id, name) VALUES (lower(hex(randomblob(16))), 'p')"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	insert(10)
+	tm.CloseAll()
+	insert(15) // reopened: a new generation once the previous one is shipped
+	tm.CloseAll()
+	if err := rp.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := tm.Path("acme")
+	if _, err := os.Stat(path + "-wal"); !os.IsNotExist(err) {
+		t.Errorf("wal left behind after the last close: %v", err)
+	}
+
+	out := filepath.Join(dir, "restored.db")
+	if _, err := Restore(ctx, store, "acme", time.Now(), out); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM projects").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 25 {
+		t.Errorf("restored %d projects, want 25", n)
+	}
+
+	if err := rp.Purge(ctx, "acme"); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := store.List(ctx, "acme/"); err != nil || len(keys) != 0 {
+		t.Errorf("after purge: %d objects left, err = %v", len(keys), err)
+	}
+}
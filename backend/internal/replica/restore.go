@@ -0,0 +1,239 @@
+package replica
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+// Generation is a database snapshot and the WAL shipped after it.
+type Generation struct {
+	ID       string
+	Start    time.Time
+	End      time.Time // of the last segment, Start if none
+	Segments int
+
+	keys     []string
+	snapshot string
+	segments []segment
+}
+
+// segment is one shipped piece of a WAL cycle.
+type segment struct {
+	key    string
+	cycle  int
+	offset int64
+	at     time.Time
+}
+
+// Generations lists a tenant's generations, oldest first. Generations still
+// missing their snapshot (or left incomplete by pruning) are skipped.
+func Generations(ctx context.Context, store Store, tenantID string) ([]Generation, error) {
+	if !tenant.ValidID(tenantID) {
+		return nil, tenant.ErrInvalidID
+	}
+	prefix := tenantID + "/"
+	keys, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*Generation)
+	var gens []*Generation
+	for _, key := range keys {
+		id, rest, ok := strings.Cut(strings.TrimPrefix(key, prefix), "/")
+		if !ok {
+			continue
+		}
+		g := byID[id]
+		if g == nil {
+			start, err := time.Parse(genLayout, id)
+			if err != nil {
+				continue
+			}
+			g = &Generation{ID: id, Start: start, End: start}
+			byID[id] = g
+			gens = append(gens, g)
+		}
+		g.keys = append(g.keys, key)
+		if rest == "snapshot.db.gz" {
+			g.snapshot = key
+			continue
+		}
+		seg, ok := parseSegment(key, rest)
+		if !ok {
+			continue
+		}
+		g.segments = append(g.segments, seg)
+		g.Segments++
+		if seg.at.After(g.End) {
+			g.End = seg.at
+		}
+	}
+
+	out := make([]Generation, 0, len(gens))
+	for _, g := range gens {
+		if g.snapshot == "" {
+			continue
+		}
+		sort.Slice(g.segments, func(i, j int) bool {
+			a, b := g.segments[i], g.segments[j]
+			if a.cycle != b.cycle {
+				return a.cycle < b.cycle
+			}
+			return a.offset < b.offset
+		})
+		out = append(out, *g)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out, nil
+}
+
+// parseSegment parses "wal/<cycle>/<offset>-<unix ms>.wal.gz".
+func parseSegment(key, rest string) (segment, bool) {
+	parts := strings.Split(rest, "/")
+	if len(parts) != 3 || parts[0] != "wal" {
+		return segment{}, false
+	}
+	offset, ms, ok := strings.Cut(strings.TrimSuffix(parts[2], ".wal.gz"), "-")
+	if !ok {
+		return segment{}, false
+	}
+	cycle, err1 := strconv.ParseInt(parts[1], 16, 64)
+	off, err2 := strconv.ParseInt(offset, 16, 64)
+	unix, err3 := strconv.ParseInt(ms, 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return segment{}, false
+	}
+	return segment{key: key, cycle: int(cycle), offset: off, at: time.UnixMilli(unix)}, true
+}
+
+// Restore rebuilds a tenant database as of at into a new file at out: the
+// latest generation started by then, with its WAL replayed up to the last
+// segment shipped by then. It returns the time of the state restored.
+func Restore(ctx context.Context, store Store, tenantID string, at time.Time, out string) (_ time.Time, err error) {
+	if _, err := os.Stat(out); err == nil {
+		return time.Time{}, fmt.Errorf("%s already exists", out)
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(out)
+			os.Remove(out + "-wal")
+			os.Remove(out + "-shm")
+		}
+	}()
+	gens, err := Generations(ctx, store, tenantID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var gen *Generation
+	for i := range gens {
+		if !gens[i].Start.After(at) {
+			gen = &gens[i]
+		}
+	}
+	if gen == nil {
+		return time.Time{}, fmt.Errorf("no generation of tenant %s before %s", tenantID, at.Format(time.RFC3339))
+	}
+
+	if err := fetch(ctx, store, gen.snapshot, out, false); err != nil {
+		return time.Time{}, fmt.Errorf("snapshot: %w", err)
+	}
+	restored := gen.Start
+	segs := gen.segments
+	for len(segs) > 0 {
+		cycle := segs[0].cycle
+		var n int64
+		wal := out + "-wal"
+		for len(segs) > 0 && segs[0].cycle == cycle && !segs[0].at.After(at) {
+			if segs[0].offset != n {
+				return time.Time{}, fmt.Errorf("cycle %d: missing wal at offset %d", cycle, n)
+			}
+			if err := fetch(ctx, store, segs[0].key, wal, n > 0); err != nil {
+				return time.Time{}, fmt.Errorf("%s: %w", segs[0].key, err)
+			}
+			info, err := os.Stat(wal)
+			if err != nil {
+				return time.Time{}, err
+			}
+			n = info.Size()
+			restored = segs[0].at
+			segs = segs[1:]
+		}
+		if n > 0 {
+			if err := replay(out); err != nil {
+				return time.Time{}, fmt.Errorf("replay cycle %d: %w", cycle, err)
+			}
+		}
+		if len(segs) > 0 && segs[0].cycle == cycle {
+			break // the rest was shipped after at
+		}
+	}
+	return restored, nil
+}
+
+// fetch gunzips an object into path, replacing it unless asked to append.
+func fetch(ctx context.Context, store Store, key, path string, appendTo bool) error {
+	rc, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	zr, err := gzip.NewReader(rc)
+	if err != nil {
+		return err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendTo {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, zr); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// replay checkpoints the WAL next to path into it. Opening the database
+// recovers the WAL, which keeps only its valid committed frames; a WAL
+// shorter after recovery than on disk means a corrupt segment.
+func replay(path string) error {
+	info, err := os.Stat(path + "-wal")
+	if err != nil {
+		return err
+	}
+	os.Remove(path + "-shm")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	var pageSize int64
+	if err := db.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+		return err
+	}
+	// PASSIVE reports the frames found; TRUNCATE then empties the log, which
+	// the next cycle's file replaces
+	var busy, logFrames, checkpointed int64
+	if err := db.QueryRow("PRAGMA wal_checkpoint(PASSIVE)").Scan(&busy, &logFrames, &checkpointed); err != nil {
+		return err
+	}
+	if want := (info.Size() - walHeaderSize) / (walFrameHeaderSize + pageSize); logFrames != want || checkpointed != want {
+		return fmt.Errorf("%d of %d frames valid", logFrames, want)
+	}
+	_, err = db.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+	return err
+}
@@ -0,0 +1,127 @@
+package replica
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Store is an object store replicas are shipped to. Keys use / as separator.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	// Get fails with an error wrapping fs.ErrNotExist for missing keys.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// List returns the keys starting with prefix, sorted.
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// OpenStore opens the store a URL points at:
+//
+//	file:///var/lib/ouroboros/replica (or a plain path)
+//	s3://bucket/prefix?region=us-east-1&endpoint=http://localhost:9000
+//
+// S3 credentials come from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY. With
+// an endpoint (MinIO and other S3-compatible stores), requests use path-style
+// addressing.
+func OpenStore(rawURL string) (Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "", "file":
+		return NewFileStore(u.Path), nil
+	case "s3":
+		q := u.Query()
+		return NewS3Store(S3Config{
+			Bucket:    u.Host,
+			Prefix:    strings.TrimPrefix(u.Path, "/"),
+			Region:    q.Get("region"),
+			Endpoint:  q.Get("endpoint"),
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		})
+	default:
+		return nil, fmt.Errorf("unsupported replica store %q", u.Scheme)
+	}
+}
+
+// FileStore keeps objects as files under a directory, e.g. a mounted volume.
+type FileStore struct {
+	root string
+}
+
+func NewFileStore(root string) *FileStore {
+	return &FileStore{root: filepath.Clean(root)}
+}
+
+func (s *FileStore) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+func (s *FileStore) Put(ctx context.Context, key string, data []byte) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *FileStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(s.path(key))
+}
+
+func (s *FileStore) List(ctx context.Context, prefix string) ([]string, error) {
+	dir := s.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = s.path(prefix[:i])
+	}
+	var keys []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir && os.IsNotExist(err) {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return keys, err
+}
+
+func (s *FileStore) Delete(ctx context.Context, key string) error {
+	path := s.path(key)
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Drop directories left empty; Remove fails on the first that is not
+	for dir := filepath.Dir(path); dir != s.root && os.Remove(dir) == nil; dir = filepath.Dir(dir) {
+	}
+	return nil
+}
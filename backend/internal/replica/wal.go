@@ -0,0 +1,90 @@
+package replica
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// SQLite WAL file format, see https://www.sqlite.org/fileformat.html#the_write_ahead_log
+const (
+	walHeaderSize      = 32
+	walFrameHeaderSize = 24
+)
+
+var errNoWAL = errors.New("no valid wal header")
+
+// walHeader is the header of one WAL cycle; a checkpoint that restarts the
+// log writes a new one with fresh salts and the next checkpoint sequence.
+type walHeader struct {
+	bigEndian bool // checksum word order
+	pageSize  int
+	ckptSeq   uint32
+	salt      [2]uint32
+	cksum     [2]uint32
+}
+
+func parseWALHeader(b []byte) (walHeader, error) {
+	var h walHeader
+	if len(b) < walHeaderSize {
+		return h, errNoWAL
+	}
+	switch binary.BigEndian.Uint32(b[0:4]) {
+	case 0x377f0682:
+	case 0x377f0683:
+		h.bigEndian = true
+	default:
+		return h, errNoWAL
+	}
+	h.pageSize = int(binary.BigEndian.Uint32(b[8:12]))
+	if h.pageSize == 1 {
+		h.pageSize = 65536
+	}
+	h.ckptSeq = binary.BigEndian.Uint32(b[12:16])
+	h.salt = [2]uint32{binary.BigEndian.Uint32(b[16:20]), binary.BigEndian.Uint32(b[20:24])}
+	h.cksum = [2]uint32{binary.BigEndian.Uint32(b[24:28]), binary.BigEndian.Uint32(b[28:32])}
+	if h.pageSize < 512 || h.pageSize&(h.pageSize-1) != 0 {
+		return h, fmt.Errorf("invalid wal page size %d", h.pageSize)
+	}
+	if walChecksum(h.bigEndian, [2]uint32{}, b[:24]) != h.cksum {
+		return h, errNoWAL
+	}
+	return h, nil
+}
+
+// scanFrames walks the frames in b, which starts at a frame boundary where
+// the running checksum is cksum, and returns the length of the valid frames
+// up to and including the last commit frame, with the checksum there.
+func (h walHeader) scanFrames(b []byte, cksum [2]uint32) (int, [2]uint32) {
+	frameSize := walFrameHeaderSize + h.pageSize
+	end, endCksum := 0, cksum
+	for off := 0; off+frameSize <= len(b); off += frameSize {
+		f := b[off : off+frameSize]
+		salt := [2]uint32{binary.BigEndian.Uint32(f[8:12]), binary.BigEndian.Uint32(f[12:16])}
+		if salt != h.salt {
+			break
+		}
+		cksum = walChecksum(h.bigEndian, cksum, f[:8])
+		cksum = walChecksum(h.bigEndian, cksum, f[walFrameHeaderSize:])
+		if cksum != [2]uint32{binary.BigEndian.Uint32(f[16:20]), binary.BigEndian.Uint32(f[20:24])} {
+			break
+		}
+		if binary.BigEndian.Uint32(f[4:8]) != 0 { // commit frame: database size after the transaction
+			end, endCksum = off+frameSize, cksum
+		}
+	}
+	return end, endCksum
+}
+
+// walChecksum continues SQLite's WAL checksum over b (a multiple of 8 bytes).
+func walChecksum(bigEndian bool, s [2]uint32, b []byte) [2]uint32 {
+	order := binary.ByteOrder(binary.LittleEndian)
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	for i := 0; i+8 <= len(b); i += 8 {
+		s[0] += order.Uint32(b[i:]) + s[1]
+		s[1] += order.Uint32(b[i+4:]) + s[0]
+	}
+	return s
+}
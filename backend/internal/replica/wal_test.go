@@ -0,0 +1,258 @@
+package replica
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testPageSize = 512
+
+// testWAL builds WAL files the way SQLite writes them.
+type testWAL struct {
+	bigEndian bool
+	ckptSeq   uint32
+	salt      [2]uint32
+	cksum     [2]uint32 // running checksum after the last frame
+	buf       bytes.Buffer
+}
+
+func newTestWAL(bigEndian bool, ckptSeq uint32, salt [2]uint32) *testWAL {
+	w := &testWAL{bigEndian: bigEndian, ckptSeq: ckptSeq, salt: salt}
+	w.buf.Write(w.header())
+	return w
+}
+
+func (w *testWAL) header() []byte {
+	h := make([]byte, walHeaderSize)
+	magic := uint32(0x377f0682)
+	if w.bigEndian {
+		magic++
+	}
+	binary.BigEndian.PutUint32(h[0:], magic)
+	binary.BigEndian.PutUint32(h[4:], 3007000)
+	binary.BigEndian.PutUint32(h[8:], testPageSize)
+	binary.BigEndian.PutUint32(h[12:], w.ckptSeq)
+	binary.BigEndian.PutUint32(h[16:], w.salt[0])
+	binary.BigEndian.PutUint32(h[20:], w.salt[1])
+	w.cksum = walChecksum(w.bigEndian, [2]uint32{}, h[:24])
+	binary.BigEndian.PutUint32(h[24:], w.cksum[0])
+	binary.BigEndian.PutUint32(h[28:], w.cksum[1])
+	return h
+}
+
+// frame appends a frame for page pgno filled with fill; a non-zero dbSize
+// makes it a commit frame.
+func (w *testWAL) frame(pgno, dbSize uint32, fill byte) *testWAL {
+	f := make([]byte, walFrameHeaderSize+testPageSize)
+	binary.BigEndian.PutUint32(f[0:], pgno)
+	binary.BigEndian.PutUint32(f[4:], dbSize)
+	binary.BigEndian.PutUint32(f[8:], w.salt[0])
+	binary.BigEndian.PutUint32(f[12:], w.salt[1])
+	for i := walFrameHeaderSize; i < len(f); i++ {
+		f[i] = fill
+	}
+	w.cksum = walChecksum(w.bigEndian, w.cksum, f[:8])
+	w.cksum = walChecksum(w.bigEndian, w.cksum, f[walFrameHeaderSize:])
+	binary.BigEndian.PutUint32(f[16:], w.cksum[0])
+	binary.BigEndian.PutUint32(f[20:], w.cksum[1])
+	w.buf.Write(f)
+	return w
+}
+
+func (w *testWAL) bytes() []byte { return bytes.Clone(w.buf.Bytes()) }
+
+const frameSize = walFrameHeaderSize + testPageSize
+
+func TestParseWALHeader(t *testing.T) {
+	valid := newTestWAL(false, 7, [2]uint32{1, 2}).bytes()[:walHeaderSize]
+	validBE := newTestWAL(true, 7, [2]uint32{1, 2}).bytes()[:walHeaderSize]
+
+	badPageSize := bytes.Clone(valid)
+	binary.BigEndian.PutUint32(badPageSize[8:], 1000)
+	cksum := walChecksum(false, [2]uint32{}, badPageSize[:24])
+	binary.BigEndian.PutUint32(badPageSize[24:], cksum[0])
+	binary.BigEndian.PutUint32(badPageSize[28:], cksum[1])
+
+	badMagic := bytes.Clone(valid)
+	badMagic[3] = 0x80
+	badCksum := bytes.Clone(valid)
+	badCksum[31] ^= 1
+
+	tests := []struct {
+		name      string
+		b         []byte
+		wantErr   error // errNoWAL, or nil
+		anyErr    bool
+		bigEndian bool
+	}{
+		{name: "little endian checksums", b: valid},
+		{name: "big endian checksums", b: validBE, bigEndian: true},
+		{name: "short", b: valid[:20], wantErr: errNoWAL},
+		{name: "zeroed", b: make([]byte, walHeaderSize), wantErr: errNoWAL},
+		{name: "bad magic", b: badMagic, wantErr: errNoWAL},
+		{name: "bad checksum", b: badCksum, wantErr: errNoWAL},
+		{name: "bad page size", b: badPageSize, anyErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := parseWALHeader(tt.b)
+			switch {
+			case tt.anyErr:
+				if err == nil || errors.Is(err, errNoWAL) {
+					t.Fatalf("err = %v, want a page size error", err)
+				}
+				return
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+			if h.bigEndian != tt.bigEndian || h.pageSize != testPageSize || h.ckptSeq != 7 || h.salt != [2]uint32{1, 2} {
+				t.Errorf("header = %+v", h)
+			}
+		})
+	}
+
+	t.Run("64k pages", func(t *testing.T) {
+		b := bytes.Clone(valid)
+		binary.BigEndian.PutUint32(b[8:], 1)
+		cksum := walChecksum(false, [2]uint32{}, b[:24])
+		binary.BigEndian.PutUint32(b[24:], cksum[0])
+		binary.BigEndian.PutUint32(b[28:], cksum[1])
+		h, err := parseWALHeader(b)
+		if err != nil || h.pageSize != 65536 {
+			t.Fatalf("pageSize = %d, err = %v", h.pageSize, err)
+		}
+	})
+}
+
+func TestScanFrames(t *testing.T) {
+	salt := [2]uint32{0xdeadbeef, 42}
+	// Two transactions: frames 1-2 (committed by 2), frame 3 (committed)
+	committed := newTestWAL(false, 0, salt).frame(1, 0, 'a').frame(2, 2, 'b').frame(3, 3, 'c')
+
+	// Frames of the previous cycle left behind after a restart: same layout,
+	// different salt
+	old := newTestWAL(false, 0, [2]uint32{1, 1}).frame(4, 0, 'x').frame(5, 0, 'y').frame(6, 6, 'z')
+
+	// Frames whose checksum chain is broken: copy a valid frame, flip a byte
+	corrupt := newTestWAL(false, 0, salt).frame(1, 0, 'a').frame(2, 2, 'b').frame(3, 3, 'c').bytes()
+	corrupt[walHeaderSize+2*frameSize+walFrameHeaderSize+10] ^= 0xff
+
+	garbage := bytes.Repeat([]byte{0x5a}, frameSize)
+
+	tests := []struct {
+		name string
+		wal  []byte
+		want int // valid bytes after the header
+	}{
+		{"empty", committed.bytes()[:walHeaderSize], 0},
+		{"committed frames", committed.bytes(), 3 * frameSize},
+		{"uncommitted tail", newTestWAL(false, 0, salt).frame(1, 1, 'a').frame(2, 0, 'b').bytes(), frameSize},
+		{"torn frame", committed.bytes()[:walHeaderSize+3*frameSize-100], 2 * frameSize},
+		{"torn header", committed.bytes()[:walHeaderSize+2*frameSize+10], 2 * frameSize},
+		{"garbage tail", append(committed.bytes(), garbage...), 3 * frameSize},
+		{"corrupt frame", corrupt, 2 * frameSize},
+		{"stale salt", append(committed.bytes(), old.bytes()[walHeaderSize:]...), 3 * frameSize},
+		{"big endian", newTestWAL(true, 0, salt).frame(1, 0, 'a').frame(2, 2, 'b').bytes(), 2 * frameSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := parseWALHeader(tt.wal)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, _ := h.scanFrames(tt.wal[walHeaderSize:], h.cksum)
+			if n != tt.want {
+				t.Errorf("scanned %d bytes, want %d", n, tt.want)
+			}
+		})
+	}
+
+	t.Run("resumes at a frame boundary", func(t *testing.T) {
+		wal := committed.bytes()
+		h, _ := parseWALHeader(wal)
+		n, cksum := h.scanFrames(wal[walHeaderSize:walHeaderSize+2*frameSize], h.cksum)
+		if n != 2*frameSize {
+			t.Fatalf("first scan: %d bytes", n)
+		}
+		n, cksum = h.scanFrames(wal[walHeaderSize+n:], cksum)
+		if n != frameSize || cksum != committed.cksum {
+			t.Errorf("second scan: %d bytes, checksum %x, want %d, %x", n, cksum, frameSize, committed.cksum)
+		}
+	})
+}
+
+// TestCaptureWAL follows a replica across WAL cycles: a restart by the
+// replica's own checkpoint continues the generation, one it did not see is a
+// gap.
+func TestCaptureWAL(t *testing.T) {
+	dir := t.TempDir()
+	r := &replica{tenantID: "acme", path: filepath.Join(dir, "tenant_acme.db"), gen: "g"}
+	write := func(b []byte) {
+		t.Helper()
+		if err := os.WriteFile(r.path+"-wal", b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// No WAL, or one truncated by a checkpoint: nothing to ship
+	if err := r.captureWAL(); err != nil || len(r.pending) != 0 {
+		t.Fatalf("no wal: err = %v, %d pending", err, len(r.pending))
+	}
+	write(nil)
+	if err := r.captureWAL(); err != nil || len(r.pending) != 0 {
+		t.Fatalf("empty wal: err = %v, %d pending", err, len(r.pending))
+	}
+
+	w := newTestWAL(false, 3, [2]uint32{10, 20}).frame(1, 1, 'a')
+	write(w.bytes())
+	if err := r.captureWAL(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.pending) != 1 || r.offset != walHeaderSize+frameSize || r.cycle != 0 {
+		t.Fatalf("first segment: %d pending, offset %d, cycle %d", len(r.pending), r.offset, r.cycle)
+	}
+
+	// Nothing new committed
+	w.frame(2, 0, 'b')
+	write(w.bytes())
+	if err := r.captureWAL(); err != nil || len(r.pending) != 1 {
+		t.Fatalf("uncommitted: err = %v, %d pending", err, len(r.pending))
+	}
+	w.frame(3, 3, 'c')
+	write(w.bytes())
+	if err := r.captureWAL(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.pending) != 2 || r.offset != walHeaderSize+3*frameSize {
+		t.Fatalf("second segment: %d pending, offset %d", len(r.pending), r.offset)
+	}
+
+	// Restart after a checkpoint: new salts, next sequence, old frames behind
+	restarted := newTestWAL(false, 4, [2]uint32{11, 21}).frame(1, 1, 'd').bytes()
+	write(append(restarted, w.bytes()[len(restarted):]...))
+	if err := r.captureWAL(); err != nil {
+		t.Fatal(err)
+	}
+	if r.cycle != 1 || r.offset != walHeaderSize+frameSize || len(r.pending) != 3 {
+		t.Fatalf("after restart: cycle %d, offset %d, %d pending", r.cycle, r.offset, len(r.pending))
+	}
+	if key := r.pending[2].key; !strings.HasPrefix(key, "acme/g/wal/00000001/0000000000000000-") {
+		t.Errorf("segment key %q", key)
+	}
+
+	// A checkpoint sequence skipped: frames were lost
+	write(newTestWAL(false, 6, [2]uint32{12, 22}).frame(1, 1, 'e').bytes())
+	if err := r.captureWAL(); !errors.Is(err, errWALGap) {
+		t.Fatalf("err = %v, want errWALGap", err)
+	}
+}
@@ -0,0 +1,152 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+// CompactResult summarises one compaction pass over a tenant's sync_log.
+type CompactResult struct {
+	Floor      int64 `json:"floor"`
+	Superseded int64 `json:"superseded"`
+	Tombstones int64 `json:"tombstones"`
+}
+
+// Querier is satisfied by both *sql.DB and *sql.Tx.
+type Querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Floor returns the compaction floor of a tenant: clients whose "since" is
+// below it may have missed collapsed entries and must resnapshot.
+func Floor(ctx context.Context, q Querier) (int64, error) {
+	var floor int64
+	err := q.QueryRowContext(ctx,
+		"SELECT COALESCE((SELECT value FROM sync_state WHERE key = 'compacted_version'), 0)",
+	).Scan(&floor)
+	return floor, err
+}
+
+// Compact collapses sync_log entries older than retention. For every
+// (table_name, entity_id) only the latest entry is kept, and entities whose
+// latest entry is a DELETE are dropped entirely. The compaction floor is
+// raised to the newest version that fell behind the retention horizon.
+func Compact(ctx context.Context, db *sql.DB, retention time.Duration) (CompactResult, error) {
+	var res CompactResult
+	cutoff := time.Now().UTC().Add(-retention).Format("2006-01-02 15:04:05")
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return res, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if res.Floor, err = Floor(ctx, tx); err != nil {
+		return res, fmt.Errorf("read floor: %w", err)
+	}
+
+	// Horizon: the newest version that is entirely older than the cutoff.
+	var horizon int64
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(version), 0) FROM sync_log
+		 WHERE created_at <= ?
+		   AND version < COALESCE((SELECT MIN(version) FROM sync_log WHERE created_at > ?), 9223372036854775807)`,
+		cutoff, cutoff,
+	).Scan(&horizon); err != nil {
+		return res, fmt.Errorf("read horizon: %w", err)
+	}
+	if horizon <= res.Floor {
+		return res, nil
+	}
+
+	result, err := tx.ExecContext(ctx,
+		`DELETE FROM sync_log
+		 WHERE version <= ?
+		   AND id NOT IN (SELECT MAX(id) FROM sync_log GROUP BY table_name, entity_id)`,
+		horizon,
+	)
+	if err != nil {
+		return res, fmt.Errorf("collapse superseded: %w", err)
+	}
+	res.Superseded, _ = result.RowsAffected()
+
+	// After collapsing, a DELETE at or below the horizon is the entity's only entry.
+	result, err = tx.ExecContext(ctx,
+		"DELETE FROM sync_log WHERE version <= ? AND operation = 'DELETE'", horizon,
+	)
+	if err != nil {
+		return res, fmt.Errorf("drop tombstones: %w", err)
+	}
+	res.Tombstones, _ = result.RowsAffected()
+
+	// Mutation IDs only need to outlive the window in which a client may re-push them.
+	if _, err := tx.ExecContext(ctx, "DELETE FROM sync_mutations WHERE created_at <= ?", cutoff); err != nil {
+		return res, fmt.Errorf("prune mutations: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO sync_state (key, value) VALUES ('compacted_version', ?)
+		 ON CONFLICT(key) DO UPDATE SET value = excluded.value`,
+		horizon,
+	); err != nil {
+		return res, fmt.Errorf("write floor: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return res, fmt.Errorf("commit: %w", err)
+	}
+	res.Floor = horizon
+	return res, nil
+}
+
+// CompactAll runs Compact for every tenant known to the manager.
+// A failing tenant is logged and skipped so one bad database does not stall the rest.
+func CompactAll(ctx context.Context, tm *tenant.Manager, retention time.Duration) error {
+	ids, err := tm.Tenants()
+	if err != nil {
+		return fmt.Errorf("list tenants: %w", err)
+	}
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		db, release, err := tm.Acquire(id)
+		if err != nil {
+			log.Printf("[compact] tenant %s: %v", id, err)
+			continue
+		}
+		res, err := Compact(ctx, db, retention)
+		release()
+		if err != nil {
+			log.Printf("[compact] tenant %s: %v", id, err)
+			continue
+		}
+		if res.Superseded > 0 || res.Tombstones > 0 {
+			log.Printf("[compact] tenant %s: floor=%d superseded=%d tombstones=%d",
+				id, res.Floor, res.Superseded, res.Tombstones)
+		}
+	}
+	return nil
+}
+
+// RunCompactor compacts all tenants every interval until ctx is cancelled.
+func RunCompactor(ctx context.Context, tm *tenant.Manager, interval, retention time.Duration) {
+	log.Printf("[compact] compacting sync_log every %s (retention %s)", interval, retention)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := CompactAll(ctx, tm, retention); err != nil {
+				log.Printf("[compact] %v", err)
+			}
+		}
+	}
+}
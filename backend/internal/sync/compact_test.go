@@ -0,0 +1,73 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+func TestCompact(t *testing.T) {
+	tm := tenant.NewManager(t.TempDir(), 1)
+	defer tm.CloseAll()
+	db, release, err := tm.Acquire("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	ctx := context.Background()
+
+	old := time.Now().UTC().Add(-2 * time.Hour).Format("2006-01-02 15:04:05")
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	for _, e := range []struct {
+		entity, op string
+		version    int64
+		at         string
+	}{
+		{"a", "INSERT", 1, old},
+		{"a", "UPDATE", 2, old},
+		{"b", "INSERT", 3, old},
+		{"b", "DELETE", 4, old},
+		{"a", "UPDATE", 5, now},
+		{"c", "INSERT", 6, old}, // old, but behind a version within the retention
+	} {
+		if _, err := db.Exec(
+			`INSERT INTO sync_log (table_name, entity_id, operation, payload, version, created_at)
+			 VALUES ('products', ?, ?, '{}', ?, ?)`, e.entity, e.op, e.version, e.at,
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := Compact(ctx, db, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (CompactResult{Floor: 4, Superseded: 3, Tombstones: 1}) {
+		t.Errorf("result = %+v", res)
+	}
+	if floor, err := Floor(ctx, db); err != nil || floor != 4 {
+		t.Errorf("floor = %d, err = %v", floor, err)
+	}
+	var versions []int64
+	rows, err := db.Query("SELECT version FROM sync_log ORDER BY version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v int64
+		rows.Scan(&v)
+		versions = append(versions, v)
+	}
+	if fmt.Sprint(versions) != "[5 6]" {
+		t.Errorf("versions left = %v, want [5 6]", versions)
+	}
+
+	// Nothing new behind the horizon: the floor stays
+	if res, err := Compact(ctx, db, time.Hour); err != nil || res != (CompactResult{Floor: 4}) {
+		t.Errorf("second pass: %+v, %v", res, err)
+	}
+}
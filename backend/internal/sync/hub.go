@@ -2,133 +2,185 @@ package sync
 
 import (
 	"context"
+	"errors"
 	"log"
 	"sync"
+	"sync/atomic"
+)
+
+// Backend carries version notifications to every API process. Versions are
+// allocated in the tenant database (see NextVersion); the backend only keeps
+// a copy of the latest one. RedisBackend shares it across nodes; LocalBackend
+// keeps it in-process for single-node deployments.
+type Backend interface {
+	// GetVersion returns the latest version announced for a tenant.
+	GetVersion(ctx context.Context, tenantID string) (int64, error)
+	// SetVersion overwrites the recorded tenant version (see Reconcile).
+	SetVersion(ctx context.Context, tenantID string, version int64) error
+	// Publish announces that a tenant reached version.
+	Publish(ctx context.Context, tenantID string, version int64) error
+	// Listen calls fn for every published version until ctx is done.
+	Listen(ctx context.Context, fn func(tenantID string, version int64)) error
+}
 
-	"github.com/redis/go-redis/v9"
+var (
+	// ErrTooManyStreams is returned by Subscribe when the tenant or user
+	// already has the maximum number of open streams.
+	ErrTooManyStreams = errors.New("too many open streams")
+	// ErrShuttingDown is returned by Subscribe once Shutdown has been called.
+	ErrShuttingDown = errors.New("hub is shutting down")
 )
 
-// Hub manages SSE clients and broadcasts version updates via Redis Pub/Sub.
+// Hub manages SSE clients and broadcasts version updates from its Backend.
 type Hub struct {
-	rdb     *redis.Client
+	backend Backend
 	mu      sync.RWMutex
-	clients map[string]map[chan int64]struct{} // tenant_id -> set of channels
+	clients map[string]map[*Subscription]struct{} // tenant_id -> set of subscriptions
+	users   map[string]int                        // tenant_id/user_id -> open streams
+	closed  bool
+
+	maxPerTenant int
+	maxPerUser   int
 }
 
-func NewHub(rdb *redis.Client) *Hub {
+func NewHub(backend Backend) *Hub {
 	return &Hub{
-		rdb:     rdb,
-		clients: make(map[string]map[chan int64]struct{}),
+		backend: backend,
+		clients: make(map[string]map[*Subscription]struct{}),
+		users:   make(map[string]int),
 	}
 }
 
-// Subscribe registers an SSE client for a tenant. Returns a channel that
-// receives version numbers and an unsubscribe function.
-func (h *Hub) Subscribe(tenantID string) (<-chan int64, func()) {
+// SetLimits caps concurrent streams per tenant and per user. Zero means no limit.
+func (h *Hub) SetLimits(perTenant, perUser int) {
+	h.mu.Lock()
+	h.maxPerTenant, h.maxPerUser = perTenant, perUser
+	h.mu.Unlock()
+}
+
+// Subscription is one SSE client's feed of tenant versions. C is closed when
+// the hub shuts down; the client should then be told to reconnect.
+type Subscription struct {
+	C      <-chan int64
+	ch     chan int64
+	user   string
+	lagged atomic.Bool
+}
+
+// Lagged reports whether versions were dropped because the client did not
+// drain C fast enough, and clears the flag.
+func (s *Subscription) Lagged() bool {
+	return s.lagged.Swap(false)
+}
+
+// Subscribe registers a stream of userID for a tenant. Returns the
+// subscription and an unsubscribe function, or ErrTooManyStreams /
+// ErrShuttingDown.
+func (h *Hub) Subscribe(tenantID, userID string) (*Subscription, func(), error) {
 	ch := make(chan int64, 16)
+	sub := &Subscription{C: ch, ch: ch, user: tenantID + "/" + userID}
 	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, nil, ErrShuttingDown
+	}
+	if (h.maxPerTenant > 0 && len(h.clients[tenantID]) >= h.maxPerTenant) ||
+		(h.maxPerUser > 0 && h.users[sub.user] >= h.maxPerUser) {
+		return nil, nil, ErrTooManyStreams
+	}
 	if h.clients[tenantID] == nil {
-		h.clients[tenantID] = make(map[chan int64]struct{})
+		h.clients[tenantID] = make(map[*Subscription]struct{})
 	}
-	h.clients[tenantID][ch] = struct{}{}
-	h.mu.Unlock()
+	h.clients[tenantID][sub] = struct{}{}
+	h.users[sub.user]++
 
 	unsub := func() {
 		h.mu.Lock()
-		delete(h.clients[tenantID], ch)
-		if len(h.clients[tenantID]) == 0 {
-			delete(h.clients, tenantID)
+		defer h.mu.Unlock()
+		if _, ok := h.clients[tenantID][sub]; !ok {
+			return // already closed by Shutdown
 		}
-		h.mu.Unlock()
-		close(ch)
+		h.remove(tenantID, sub)
 	}
-	return ch, unsub
+	return sub, unsub, nil
 }
 
-// Publish increments the tenant version in Redis and broadcasts to Pub/Sub.
-// DEPRECATED: Use NextVersion + Notify to avoid the race where SSE fires before tx.Commit.
-func (h *Hub) Publish(ctx context.Context, tenantID string) (int64, error) {
-	newVersion, err := h.rdb.HIncrBy(ctx, "tenant:"+tenantID+":version", "v", 1).Result()
-	if err != nil {
-		return 0, err
+// remove drops a subscription and closes its channel. h.mu must be held.
+func (h *Hub) remove(tenantID string, sub *Subscription) {
+	delete(h.clients[tenantID], sub)
+	if len(h.clients[tenantID]) == 0 {
+		delete(h.clients, tenantID)
 	}
-	h.rdb.Publish(ctx, "sync:"+tenantID, newVersion)
-	return newVersion, nil
+	if h.users[sub.user]--; h.users[sub.user] <= 0 {
+		delete(h.users, sub.user)
+	}
+	close(sub.ch)
 }
 
-// NextVersion increments the tenant version in Redis and returns it.
-// Call BEFORE tx.Commit() to get the version for sync_log.
-func (h *Hub) NextVersion(ctx context.Context, tenantID string) (int64, error) {
-	return h.rdb.HIncrBy(ctx, "tenant:"+tenantID+":version", "v", 1).Result()
+// Shutdown closes every subscription so streaming handlers can tell their
+// clients to reconnect (to another node, or to this one once it is back)
+// and return. Later Subscribe calls fail with ErrShuttingDown.
+func (h *Hub) Shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	n := 0
+	for tenantID, subs := range h.clients {
+		for sub := range subs {
+			h.remove(tenantID, sub)
+			n++
+		}
+	}
+	if n > 0 {
+		log.Printf("[hub] closed %d stream(s) for shutdown", n)
+	}
+}
+
+// Disconnect closes a tenant's subscriptions, telling its clients to
+// reconnect, and reports how many there were. Used to release a tenant's
+// database, e.g. for a restore.
+func (h *Hub) Disconnect(tenantID string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for sub := range h.clients[tenantID] {
+		h.remove(tenantID, sub)
+		n++
+	}
+	if n > 0 {
+		log.Printf("[hub] closed %d stream(s) of tenant %s", n, tenantID)
+	}
+	return n
 }
 
-// Notify broadcasts a version update to SSE clients via Redis Pub/Sub.
+// Notify broadcasts a version update to SSE clients.
 // Call AFTER tx.Commit() so data is available when clients fetch deltas.
 func (h *Hub) Notify(ctx context.Context, tenantID string, version int64) {
-	h.rdb.Publish(ctx, "sync:"+tenantID, version)
+	h.backend.Publish(ctx, tenantID, version)
 }
 
-// GetVersion returns the current version for a tenant from Redis.
+// GetVersion returns the latest version announced for a tenant.
 func (h *Hub) GetVersion(ctx context.Context, tenantID string) (int64, error) {
-	v, err := h.rdb.HGet(ctx, "tenant:"+tenantID+":version", "v").Int64()
-	if err == redis.Nil {
-		return 0, nil
-	}
-	return v, err
+	return h.backend.GetVersion(ctx, tenantID)
 }
 
-// Run subscribes to all tenant sync channels via Redis Pub/Sub pattern
-// and fans out version updates to connected SSE clients.
+// Run listens for version notifications from the backend
+// and fans them out to connected SSE clients.
 func (h *Hub) Run(ctx context.Context) {
-	pubsub := h.rdb.PSubscribe(ctx, "sync:*")
-	defer pubsub.Close()
-
-	log.Println("[hub] listening for sync events on Redis Pub/Sub")
-
-	ch := pubsub.Channel()
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case msg, ok := <-ch:
-			if !ok {
-				return
-			}
-			// Channel name is "sync:{tenant_id}"
-			tenantID := msg.Channel[len("sync:"):]
-			var version int64
-			// Parse version from message payload
-			if n, err := parseInt64(msg.Payload); err == nil {
-				version = n
-			} else {
-				continue
-			}
-
-			h.mu.RLock()
-			clients := h.clients[tenantID]
-			for ch := range clients {
-				select {
-				case ch <- version:
-				default:
-					// drop if client is too slow
-				}
-			}
-			h.mu.RUnlock()
-		}
+	if err := h.backend.Listen(ctx, h.broadcast); err != nil && ctx.Err() == nil {
+		log.Printf("[hub] listen failed: %v", err)
 	}
 }
 
-func parseInt64(s string) (int64, error) {
-	var n int64
-	for _, c := range s {
-		if c < '0' || c > '9' {
-			return 0, &parseError{s}
+func (h *Hub) broadcast(tenantID string, version int64) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.clients[tenantID] {
+		select {
+		case sub.ch <- version:
+		default:
+			// client is too slow: drop, and let it know it has to resync
+			sub.lagged.Store(true)
 		}
-		n = n*10 + int64(c-'0')
 	}
-	return n, nil
 }
-
-type parseError struct{ s string }
-
-func (e *parseError) Error() string { return "invalid int: " + e.s }
@@ -0,0 +1,68 @@
+package sync
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// LocalBackend runs the hub without Redis: notifications are delivered
+// in-process, so it only suits a single API node.
+type LocalBackend struct {
+	mu        sync.Mutex
+	versions  map[string]int64
+	listeners map[int]func(tenantID string, version int64)
+	nextID    int
+}
+
+func NewLocalBackend() *LocalBackend {
+	return &LocalBackend{
+		versions:  make(map[string]int64),
+		listeners: make(map[int]func(string, int64)),
+	}
+}
+
+func (b *LocalBackend) GetVersion(ctx context.Context, tenantID string) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.versions[tenantID], nil
+}
+
+func (b *LocalBackend) SetVersion(ctx context.Context, tenantID string, version int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.versions[tenantID] = version
+	return nil
+}
+
+func (b *LocalBackend) Publish(ctx context.Context, tenantID string, version int64) error {
+	b.mu.Lock()
+	if version > b.versions[tenantID] {
+		b.versions[tenantID] = version
+	}
+	fns := make([]func(string, int64), 0, len(b.listeners))
+	for _, fn := range b.listeners {
+		fns = append(fns, fn)
+	}
+	b.mu.Unlock()
+	for _, fn := range fns {
+		fn(tenantID, version)
+	}
+	return nil
+}
+
+func (b *LocalBackend) Listen(ctx context.Context, fn func(tenantID string, version int64)) error {
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.listeners[id] = fn
+	b.mu.Unlock()
+
+	log.Println("[hub] listening for sync events in-process")
+	<-ctx.Done()
+
+	b.mu.Lock()
+	delete(b.listeners, id)
+	b.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,88 @@
+package sync
+
+import (
+	"context"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisBackend fans out over Redis Pub/Sub, so several API nodes can share
+// one tenant, and mirrors the latest version into a Redis hash.
+type RedisBackend struct {
+	rdb *redis.Client
+}
+
+func NewRedisBackend(rdb *redis.Client) *RedisBackend {
+	return &RedisBackend{rdb: rdb}
+}
+
+// publishScript raises the mirrored version (never lowers it, since
+// notifications from different nodes may arrive out of order) and publishes.
+var publishScript = redis.NewScript(`
+local cur = tonumber(redis.call('HGET', KEYS[1], 'v') or '0')
+if tonumber(ARGV[1]) > cur then
+	redis.call('HSET', KEYS[1], 'v', ARGV[1])
+end
+return redis.call('PUBLISH', KEYS[2], ARGV[1])
+`)
+
+func (b *RedisBackend) GetVersion(ctx context.Context, tenantID string) (int64, error) {
+	v, err := b.rdb.HGet(ctx, "tenant:"+tenantID+":version", "v").Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return v, err
+}
+
+func (b *RedisBackend) SetVersion(ctx context.Context, tenantID string, version int64) error {
+	return b.rdb.HSet(ctx, "tenant:"+tenantID+":version", "v", version).Err()
+}
+
+func (b *RedisBackend) Publish(ctx context.Context, tenantID string, version int64) error {
+	return publishScript.Run(ctx, b.rdb,
+		[]string{"tenant:" + tenantID + ":version", "sync:" + tenantID}, version,
+	).Err()
+}
+
+// Listen subscribes to all tenant sync channels via a Pub/Sub pattern.
+func (b *RedisBackend) Listen(ctx context.Context, fn func(tenantID string, version int64)) error {
+	pubsub := b.rdb.PSubscribe(ctx, "sync:*")
+	defer pubsub.Close()
+
+	log.Println("[hub] listening for sync events on Redis Pub/Sub")
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			// Channel name is "sync:{tenant_id}"
+			tenantID := msg.Channel[len("sync:"):]
+			version, err := parseInt64(msg.Payload)
+			if err != nil {
+				continue
+			}
+			fn(tenantID, version)
+		}
+	}
+}
+
+func parseInt64(s string) (int64, error) {
+	var n int64
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, &parseError{s}
+		}
+		n = n*10 + int64(c-'0')
+	}
+	return n, nil
+}
+
+type parseError struct{ s string }
+
+func (e *parseError) Error() string { return "invalid int: " + e.s }
@@ -0,0 +1,86 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+// NextVersion allocates the next tenant version inside tx. The counter lives
+// in sync_state next to the data it versions, so it commits or rolls back with
+// the write and SQLite's single writer lock orders versions by commit.
+func NextVersion(ctx context.Context, tx *sql.Tx) (int64, error) {
+	var v int64
+	err := tx.QueryRowContext(ctx,
+		`INSERT INTO sync_state (key, value) VALUES ('version', 1)
+		 ON CONFLICT(key) DO UPDATE SET value = value + 1
+		 RETURNING value`,
+	).Scan(&v)
+	return v, err
+}
+
+// CurrentVersion returns the latest version allocated for a tenant.
+func CurrentVersion(ctx context.Context, q Querier) (int64, error) {
+	var v int64
+	err := q.QueryRowContext(ctx,
+		"SELECT COALESCE((SELECT value FROM sync_state WHERE key = 'version'), 0)",
+	).Scan(&v)
+	return v, err
+}
+
+// Reconcile overwrites the version recorded by the hub backend with the
+// authoritative value from each tenant database, so a flushed or restored
+// Redis does not report stale versions. The databases are read through
+// OpenReadOnly, so nothing is migrated, replicated or cached on their
+// account. It has nothing to do for a LocalBackend, which starts empty.
+// Servers run it in the background at startup; a version announced while it
+// runs may be overwritten by the one it read, until the next notification.
+func Reconcile(ctx context.Context, tm *tenant.Manager, backend Backend) error {
+	if _, ok := backend.(*LocalBackend); ok {
+		return nil
+	}
+	ids, err := tm.Tenants()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		v, err := peekTenant(ctx, tm, id)
+		if err != nil {
+			log.Printf("[hub] reconcile tenant %s: %v", id, err)
+			continue
+		}
+		if err := backend.SetVersion(ctx, id, v); err != nil {
+			return err
+		}
+	}
+	log.Printf("[hub] reconciled versions for %d tenant(s)", len(ids))
+	return nil
+}
+
+// peekTenant reads a tenant's current version without opening its database
+// through tm.
+func peekTenant(ctx context.Context, tm *tenant.Manager, tenantID string) (int64, error) {
+	db, err := tm.OpenReadOnly(tenantID)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	return PeekVersion(ctx, db)
+}
+
+// PeekVersion is CurrentVersion for a database that may not be migrated: one
+// without a sync_state table yet is at version 0.
+func PeekVersion(ctx context.Context, db Querier) (int64, error) {
+	var n int
+	if err := db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'sync_state'",
+	).Scan(&n); err != nil || n == 0 {
+		return 0, err
+	}
+	return CurrentVersion(ctx, db)
+}
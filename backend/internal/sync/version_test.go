@@ -0,0 +1,59 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/ouroboros/backend/internal/tenant"
+)
+
+// sharedBackend stands in for Redis: a backend Reconcile does not skip.
+type sharedBackend struct{ *LocalBackend }
+
+func TestReconcile(t *testing.T) {
+	dir := t.TempDir()
+	seed := tenant.NewManager(dir, 2)
+	db, release, err := seed.Acquire("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := NextVersion(context.Background(), tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	release()
+	seed.CloseAll()
+	// A database nothing has migrated yet
+	path, _ := seed.Path("fresh")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tm := tenant.NewManager(dir, 2)
+	defer tm.CloseAll()
+	backend := sharedBackend{NewLocalBackend()}
+	backend.SetVersion(context.Background(), "fresh", 9)
+	if err := Reconcile(context.Background(), tm, backend); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]int64{"acme": 3, "fresh": 0} {
+		if v, _ := backend.GetVersion(context.Background(), id); v != want {
+			t.Errorf("%s at version %d, want %d", id, v, want)
+		}
+	}
+	if s := tm.Statuses(); len(s) != 0 {
+		t.Errorf("Reconcile opened tenant databases: %+v", s)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != 0 {
+		t.Errorf("Reconcile migrated an unmigrated database")
+	}
+}
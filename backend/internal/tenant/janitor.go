@@ -0,0 +1,229 @@
+package tenant
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// Hook is a maintenance task run on a tenant database while it is idle.
+type Hook struct {
+	Name  string
+	Every time.Duration // minimum time between runs on the same tenant
+	Run   func(ctx context.Context, db *sql.DB) error
+}
+
+// DefaultHooks keep idle tenant databases small and their query plans fresh.
+var DefaultHooks = []Hook{
+	{Name: "checkpoint", Every: 10 * time.Minute, Run: checkpoint},
+	{Name: "optimize", Every: 6 * time.Hour, Run: optimize},
+	{Name: "incremental_vacuum", Every: 24 * time.Hour, Run: incrementalVacuum},
+}
+
+// HookRun records the last run of a hook on a tenant.
+type HookRun struct {
+	At    time.Time     `json:"at"`
+	Took  time.Duration `json:"took_ns"`
+	Error string        `json:"error,omitempty"`
+}
+
+// Status describes a tenant database as seen by the Manager.
+type Status struct {
+	TenantID    string             `json:"tenant_id"`
+	Open        bool               `json:"open"`
+	Leases      int                `json:"leases"`
+	LastUsed    time.Time          `json:"last_used,omitzero"`
+	Maintenance map[string]HookRun `json:"maintenance"`
+}
+
+// SetHooks replaces the maintenance hooks run by the janitor.
+func (m *Manager) SetHooks(hooks ...Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = hooks
+}
+
+// Status reports on one tenant, open or not.
+func (m *Manager) Status(tenantID string) Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := Status{TenantID: tenantID, Maintenance: map[string]HookRun{}}
+	e := m.draining[tenantID]
+	if el, ok := m.cache[tenantID]; ok {
+		e = el.Value.(*entry)
+	}
+	if e != nil {
+		s.Open, s.Leases, s.LastUsed = true, e.refs, e.lastUsed
+	}
+	for name, run := range m.maint[tenantID] {
+		s.Maintenance[name] = run
+	}
+	return s
+}
+
+// Statuses reports on every open tenant and every tenant maintained since
+// startup, sorted by tenant ID.
+func (m *Manager) Statuses() []Status {
+	m.mu.Lock()
+	ids := make(map[string]bool, len(m.cache)+len(m.maint))
+	for id := range m.cache {
+		ids[id] = true
+	}
+	for id := range m.draining {
+		ids[id] = true
+	}
+	for id := range m.maint {
+		ids[id] = true
+	}
+	m.mu.Unlock()
+
+	out := make([]Status, 0, len(ids))
+	for id := range ids {
+		out = append(out, m.Status(id))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].TenantID < out[j].TenantID })
+	return out
+}
+
+// RunJanitor sweeps the open tenants every interval until ctx is cancelled.
+// Tenants unused for a whole interval get their due maintenance hooks run;
+// tenants unused for idleTimeout (0 disables) are closed, after their hooks.
+func (m *Manager) RunJanitor(ctx context.Context, interval, idleTimeout time.Duration) {
+	log.Printf("[tenant] janitor sweeping every %s (idle timeout %s)", interval, idleTimeout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.sweep(ctx, interval, idleTimeout)
+		}
+	}
+}
+
+func (m *Manager) sweep(ctx context.Context, quiet, idleTimeout time.Duration) {
+	now := time.Now()
+	var due []*entry
+	m.mu.Lock()
+	for el := m.order.Back(); el != nil; {
+		prev := el.Prev()
+		e := el.Value.(*entry)
+		idle := now.Sub(e.lastUsed)
+		switch {
+		case e.refs > 0 || idle < quiet:
+		case len(m.dueHooks(e.tenantID, now)) > 0:
+			e.refs++ // hold it open while the hooks run
+			due = append(due, e)
+		case idleTimeout > 0 && idle >= idleTimeout:
+			delete(m.cache, e.tenantID)
+			m.order.Remove(el)
+			m.close(e)
+			log.Printf("[tenant] closed idle db for tenant %s (unused for %s)", e.tenantID, idle.Round(time.Second))
+		}
+		el = prev
+	}
+	m.mu.Unlock()
+
+	for _, e := range due {
+		m.maintain(ctx, e, now)
+		m.release(e, false)
+	}
+}
+
+// dueHooks lists the hooks whose interval has passed on a tenant. Expects
+// m.mu to be held.
+func (m *Manager) dueHooks(tenantID string, now time.Time) []Hook {
+	var due []Hook
+	for _, h := range m.hooks {
+		if run, ok := m.maint[tenantID][h.Name]; !ok || now.Sub(run.At) >= h.Every {
+			due = append(due, h)
+		}
+	}
+	return due
+}
+
+func (m *Manager) maintain(ctx context.Context, e *entry, now time.Time) {
+	m.mu.Lock()
+	hooks := m.dueHooks(e.tenantID, now)
+	m.mu.Unlock()
+
+	for _, h := range hooks {
+		if ctx.Err() != nil {
+			return
+		}
+		start := time.Now()
+		err := h.Run(ctx, e.db)
+		run := HookRun{At: start, Took: time.Since(start)}
+		if err != nil {
+			run.Error = err.Error()
+			log.Printf("[tenant] %s on tenant %s: %v", h.Name, e.tenantID, err)
+		}
+		m.mu.Lock()
+		if m.maint[e.tenantID] == nil {
+			m.maint[e.tenantID] = map[string]HookRun{}
+		}
+		m.maint[e.tenantID][h.Name] = run
+		m.mu.Unlock()
+	}
+}
+
+// checkpoint copies the WAL into the database and truncates it.
+func checkpoint(ctx context.Context, db *sql.DB) error {
+	var busy, logFrames, checkpointed int
+	if err := db.QueryRowContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &logFrames, &checkpointed); err != nil {
+		return err
+	}
+	if busy != 0 {
+		return fmt.Errorf("checkpoint blocked (%d of %d frames copied)", checkpointed, logFrames)
+	}
+	return nil
+}
+
+// optimize refreshes the query planner statistics that need it.
+func optimize(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "PRAGMA optimize")
+	return err
+}
+
+// vacuumPages is how many free pages one incrementalVacuum run returns to the
+// filesystem, so a run holds the write lock only briefly.
+const vacuumPages = 2048
+
+// incrementalVacuum returns up to vacuumPages free pages to the filesystem.
+// Databases created before auto_vacuum was enabled need one full Vacuum first;
+// the hook reports them instead of rewriting the whole file itself.
+func incrementalVacuum(ctx context.Context, db *sql.DB) error {
+	var mode int
+	if err := db.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return err
+	}
+	if mode != 2 { // INCREMENTAL
+		return errors.New("auto_vacuum is not incremental; run a full vacuum to convert the database")
+	}
+	// The pragma frees one page per step, so it is run as a query and drained
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA incremental_vacuum(%d)", vacuumPages))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+// Vacuum rebuilds a tenant database with a full VACUUM, returning every free
+// page and switching it to incremental auto_vacuum if it was created without.
+// It rewrites the whole file under the write lock, so it is an explicit
+// maintenance operation rather than a hook.
+func Vacuum(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, "VACUUM")
+	return err
+}
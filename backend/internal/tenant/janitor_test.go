@@ -0,0 +1,115 @@
+package tenant
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSweep(t *testing.T) {
+	m := newTestManager(t, 4)
+	var runs []string
+	m.SetHooks(
+		Hook{Name: "count", Every: time.Hour, Run: func(ctx context.Context, db *sql.DB) error {
+			runs = append(runs, "count")
+			return nil
+		}},
+		Hook{Name: "fail", Every: time.Hour, Run: func(ctx context.Context, db *sql.DB) error {
+			return errors.New("boom")
+		}},
+	)
+	_, releaseBusy, err := m.Acquire("busy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseBusy()
+	_, release, err := m.Acquire("idle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	// Quiet for a whole interval: hooks run, but the database stays open
+	m.sweep(context.Background(), 0, time.Hour)
+	if len(runs) != 1 {
+		t.Fatalf("count ran %d times, want once", len(runs))
+	}
+	s := m.Status("idle")
+	if !s.Open || s.Leases != 0 || s.Maintenance["count"].At.IsZero() || s.Maintenance["fail"].Error != "boom" {
+		t.Errorf("idle after the first sweep: %+v", s)
+	}
+	if s := m.Status("busy"); len(s.Maintenance) != 0 {
+		t.Errorf("hooks ran on a leased database: %+v", s)
+	}
+
+	// Hooks not due yet are not rerun; the idle timeout then closes it
+	m.sweep(context.Background(), 0, time.Nanosecond)
+	if len(runs) != 1 {
+		t.Errorf("count ran again within its interval")
+	}
+	if s := m.Status("idle"); s.Open || s.Maintenance["count"].At.IsZero() {
+		t.Errorf("idle after the timeout: %+v", s)
+	}
+	if s := m.Status("busy"); !s.Open {
+		t.Error("the janitor closed a leased database")
+	}
+	if got := len(m.Statuses()); got != 2 {
+		t.Errorf("%d statuses, want the open tenant and the maintained one", got)
+	}
+}
+
+func TestIncrementalVacuum(t *testing.T) {
+	m := newTestManager(t, 2)
+	db, release, err := m.Acquire("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if _, err := db.Exec("CREATE TABLE blob (b BLOB); WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i+1 FROM n WHERE i < 200) INSERT INTO blob SELECT randomblob(4096) FROM n; DELETE FROM blob"); err != nil {
+		t.Fatal(err)
+	}
+	before := freePages(t, db)
+	if before == 0 {
+		t.Fatal("no free pages to vacuum")
+	}
+	if err := incrementalVacuum(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	if after := freePages(t, db); after != before-min(before, vacuumPages) {
+		t.Errorf("free pages %d -> %d, want %d", before, after, before-min(before, vacuumPages))
+	}
+}
+
+// TestIncrementalVacuumLegacy checks that a database created without
+// auto_vacuum is reported by the hook, and converted by Vacuum.
+func TestIncrementalVacuumLegacy(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "legacy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE t (x)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := incrementalVacuum(context.Background(), db); err == nil {
+		t.Error("the hook did not report a database without auto_vacuum")
+	}
+	if err := Vacuum(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	if err := incrementalVacuum(context.Background(), db); err != nil {
+		t.Errorf("after Vacuum: %v", err)
+	}
+}
+
+func freePages(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("PRAGMA freelist_count").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
@@ -2,100 +2,491 @@ package tenant
 
 import (
 	"container/list"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
+	"strings"
 	gosync "sync"
+	"time"
 
 	"github.com/ouroboros/backend/internal/migrations"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// Manager is an LRU cache of SQLite connections, one per tenant.
+// ErrAtCapacity is returned by Acquire when the hard ceiling of open databases
+// is reached and every open one is leased.
+var ErrAtCapacity = errors.New("too many open tenant databases")
+
+// ErrLocked is returned by Acquire while a tenant's database is taken out of
+// service by Exclusive.
+var ErrLocked = errors.New("tenant database is locked for maintenance")
+
+// ErrUnknownTenant and ErrSuspended are returned by Acquire for tenants the
+// Registry does not allow.
+var (
+	ErrUnknownTenant = errors.New("unknown tenant")
+	ErrSuspended     = errors.New("tenant suspended")
+)
+
+// ErrInvalidID is returned for tenant IDs ValidID refuses.
+var ErrInvalidID = errors.New("invalid tenant id")
+
+// ValidID accepts IDs of up to 64 letters, digits, '.', '_' and '-', not
+// starting with punctuation, as they end up in file and object names.
+func ValidID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for i, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case i > 0 && (c == '.' || c == '_' || c == '-'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Manager is an LRU cache of SQLite connections, one per tenant. Each tenant
+// gets a write handle limited to a single connection, as SQLite allows one
+// writer at a time, and a read-only pool that WAL lets run alongside it.
+//
+// Databases are handed out as leases. Eviction only closes a database nobody
+// holds; a leased one is dropped from the cache and closed when its last lease
+// is released. Those draining databases still count towards maxOpen, the hard
+// ceiling on open databases. RunJanitor additionally closes databases left
+// idle and runs maintenance hooks on them.
 type Manager struct {
-	dataDir  string
-	maxConns int
+	dataDir   string
+	maxConns  int // databases kept open while idle
+	maxOpen   int // hard ceiling, draining databases included
+	readConns int // connections in each tenant's read-only pool
+
+	hooks     []Hook
+	lifecycle Lifecycle
+	registry  Registry
+	migrate   func(*sql.DB) error
 
-	mu    gosync.Mutex
-	cache map[string]*list.Element
-	order *list.List // front = most recently used
+	mu        gosync.Mutex
+	cache     map[string]*list.Element
+	order     *list.List                    // front = most recently used
+	draining  map[string]*entry             // evicted but still leased
+	locked    map[string]bool               // held by Exclusive
+	maint     map[string]map[string]HookRun // tenant -> hook -> last run, kept across closes
+	detaching map[string]<-chan struct{}    // Lifecycle.Closing work of closed databases
 }
 
 type entry struct {
 	tenantID string
-	db       *sql.DB
+	db       *sql.DB // writer
+	ro       *sql.DB // readers
+	refs     int     // outstanding leases
+	evicted  bool    // out of the cache; close on last release
+	closed   chan struct{}
+	lastUsed time.Time
 }
 
 func NewManager(dataDir string, maxConns int) *Manager {
 	return &Manager{
-		dataDir:  dataDir,
-		maxConns: maxConns,
-		cache:    make(map[string]*list.Element),
-		order:    list.New(),
+		dataDir:   dataDir,
+		maxConns:  maxConns,
+		maxOpen:   2 * maxConns,
+		readConns: 4,
+		hooks:     DefaultHooks,
+		migrate:   migrations.Run,
+		cache:     make(map[string]*list.Element),
+		order:     list.New(),
+		draining:  make(map[string]*entry),
+		locked:    make(map[string]bool),
+		maint:     make(map[string]map[string]HookRun),
+		detaching: make(map[string]<-chan struct{}),
 	}
 }
 
-// DB returns a ready-to-use *sql.DB for the given tenant.
-// It opens and migrates the database lazily on first access,
-// and evicts the least-recently-used connection when the cache is full.
-func (m *Manager) DB(tenantID string) (*sql.DB, error) {
+// SetMaxOpen sets the hard ceiling on open databases, leased or not. It is
+// raised to the cache size if lower.
+func (m *Manager) SetMaxOpen(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maxOpen = max(n, m.maxConns)
+}
+
+// Lifecycle is told when tenant databases are opened (db is the write handle)
+// and before they are closed, e.g. to replicate them. Both are called with
+// the Manager locked and must return quickly. Closing may leave work that
+// still reads the database files running in the background; it then returns a
+// channel closed once that is done, which Exclusive waits for before handing
+// the files out.
+type Lifecycle interface {
+	Opened(tenantID, path string, db *sql.DB)
+	Closing(tenantID string, db *sql.DB) (done <-chan struct{})
+}
+
+// SetLifecycle registers l for databases opened from now on.
+func (m *Manager) SetLifecycle(l Lifecycle) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.lifecycle = l
+}
+
+// Registry knows which tenants exist. CheckTenant returns nil for a tenant
+// whose database may be used, ErrUnknownTenant or ErrSuspended otherwise.
+type Registry interface {
+	CheckTenant(tenantID string) error
+}
+
+// SetRegistry makes Acquire and Tenants consult r. Without a registry every
+// tenant ID is accepted, and its database created on first use.
+func (m *Manager) SetRegistry(r Registry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.registry = r
+}
+
+// SetMigrate replaces what is done to databases as they are opened, which is
+// migrations.Run by default. Servers whose tenants are migrated by the migrate
+// command pass migrations.Require, so Acquire fails with migrations.ErrBehind
+// for a tenant not migrated yet.
+func (m *Manager) SetMigrate(fn func(*sql.DB) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.migrate = fn
+}
+
+// SetReadConns sets the size of the read-only pool of tenants opened from
+// now on.
+func (m *Manager) SetReadConns(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.readConns = max(n, 1)
+}
+
+// Acquire leases the write handle of the given tenant's database. The
+// database stays open until release is called, which must happen exactly once
+// (later calls are no-ops); do not use the *sql.DB afterwards.
+// It opens and migrates the database lazily on first access (see SetMigrate),
+// and evicts the least-recently-used idle connection when the cache is full.
+// Returns
+// ErrAtCapacity when the hard ceiling is reached and nothing can be evicted,
+// ErrInvalidID for IDs ValidID refuses, and the Registry's error for tenants
+// it does not allow.
+func (m *Manager) Acquire(tenantID string) (db *sql.DB, release func(), err error) {
+	e, release, err := m.acquire(tenantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return e.db, release, nil
+}
+
+// AcquireRead is Acquire for the read-only pool. Handlers that only query use
+// it so they do not queue behind writes.
+func (m *Manager) AcquireRead(tenantID string) (db *sql.DB, release func(), err error) {
+	e, release, err := m.acquire(tenantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return e.ro, release, nil
+}
+
+func (m *Manager) acquire(tenantID string) (*entry, func(), error) {
+	dbPath, err := m.Path(tenantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := m.check(tenantID); err != nil {
+		return nil, nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.locked[tenantID] {
+		return nil, nil, ErrLocked
+	}
 
 	// Cache hit: move to front
 	if el, ok := m.cache[tenantID]; ok {
 		m.order.MoveToFront(el)
-		return el.Value.(*entry).db, nil
+		return m.lease(el.Value.(*entry))
+	}
+
+	// Evicted while leased: take it back instead of opening a second handle
+	if e, ok := m.draining[tenantID]; ok {
+		delete(m.draining, tenantID)
+		e.evicted = false
+		m.makeRoom()
+		m.cache[tenantID] = m.order.PushFront(e)
+		return m.lease(e)
+	}
+
+	if m.order.Len()+len(m.draining) >= m.maxOpen && !m.evictIdle() {
+		return nil, nil, ErrAtCapacity
 	}
+	m.makeRoom()
 
 	// Cache miss: open new connection
-	dbPath := filepath.Join(m.dataDir, fmt.Sprintf("tenant_%s.db", tenantID))
-	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on")
+	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on&_auto_vacuum=incremental")
 	if err != nil {
-		return nil, fmt.Errorf("open db for tenant %s: %w", tenantID, err)
+		return nil, nil, fmt.Errorf("open db for tenant %s: %w", tenantID, err)
 	}
 	db.SetMaxOpenConns(1) // SQLite performs best with a single writer
 
-	// Run lazy migrations
-	if err := migrations.Run(db); err != nil {
+	// Run lazy migrations (or check the schema, see SetMigrate)
+	if err := m.migrate(db); err != nil {
 		db.Close()
-		return nil, fmt.Errorf("migrate tenant %s: %w", tenantID, err)
+		return nil, nil, fmt.Errorf("migrate tenant %s: %w", tenantID, err)
 	}
 
-	// Evict LRU if at capacity
-	if m.order.Len() >= m.maxConns {
-		m.evictLRU()
+	// Readers open once the writer has created the file and its WAL
+	ro, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro&_busy_timeout=5000&_query_only=true")
+	if err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("open read pool for tenant %s: %w", tenantID, err)
 	}
+	ro.SetMaxOpenConns(m.readConns)
+	ro.SetMaxIdleConns(m.readConns)
 
-	e := &entry{tenantID: tenantID, db: db}
-	el := m.order.PushFront(e)
-	m.cache[tenantID] = el
+	e := &entry{tenantID: tenantID, db: db, ro: ro, closed: make(chan struct{})}
+	m.cache[tenantID] = m.order.PushFront(e)
 
 	log.Printf("[tenant] opened db for tenant %s", tenantID)
-	return db, nil
+	if m.lifecycle != nil {
+		m.lifecycle.Opened(tenantID, dbPath, db)
+	}
+	return m.lease(e)
 }
 
-func (m *Manager) evictLRU() {
-	back := m.order.Back()
-	if back == nil {
-		return
+// lease takes a reference on e. Expects m.mu to be held.
+func (m *Manager) lease(e *entry) (*entry, func(), error) {
+	e.refs++
+	e.lastUsed = time.Now()
+	var once gosync.Once
+	return e, func() { once.Do(func() { m.release(e, true) }) }, nil
+}
+
+// release drops a reference on e; touch marks the tenant as used.
+func (m *Manager) release(e *entry, touch bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e.refs--
+	if touch {
+		e.lastUsed = time.Now()
+	}
+	if e.refs == 0 && e.evicted && m.draining[e.tenantID] == e { // not already closed by CloseAll
+		delete(m.draining, e.tenantID)
+		m.close(e)
+		log.Printf("[tenant] closed drained db for tenant %s", e.tenantID)
 	}
-	e := back.Value.(*entry)
+}
+
+// close closes e's handles. Expects m.mu to be held.
+func (m *Manager) close(e *entry) {
+	if m.lifecycle != nil {
+		if done := m.lifecycle.Closing(e.tenantID, e.db); done != nil {
+			m.detaching[e.tenantID] = done
+			go m.forgetDetaching(e.tenantID, done)
+		}
+	}
+	e.ro.Close()
 	e.db.Close()
+	close(e.closed)
+}
+
+// forgetDetaching drops a tenant's Closing work from detaching once it is
+// done, unless a later close replaced it.
+func (m *Manager) forgetDetaching(tenantID string, done <-chan struct{}) {
+	<-done
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.detaching[tenantID] == done {
+		delete(m.detaching, tenantID)
+	}
+}
+
+// makeRoom evicts the least-recently-used entry if the cache is full,
+// preferring idle ones. Expects m.mu to be held.
+func (m *Manager) makeRoom() {
+	if m.order.Len() < m.maxConns {
+		return
+	}
+	if !m.evictIdle() {
+		m.evict(m.order.Back())
+	}
+}
+
+// evictIdle evicts the least-recently-used entry without leases and reports
+// whether there was one. Expects m.mu to be held.
+func (m *Manager) evictIdle() bool {
+	for el := m.order.Back(); el != nil; el = el.Prev() {
+		if el.Value.(*entry).refs == 0 {
+			m.evict(el)
+			return true
+		}
+	}
+	return false
+}
+
+// evict drops el from the cache, closing its database now if idle or on its
+// last release otherwise. Expects m.mu to be held.
+func (m *Manager) evict(el *list.Element) {
+	if el == nil {
+		return
+	}
+	e := el.Value.(*entry)
 	delete(m.cache, e.tenantID)
-	m.order.Remove(back)
+	m.order.Remove(el)
+	if e.refs > 0 {
+		e.evicted = true
+		m.draining[e.tenantID] = e
+		log.Printf("[tenant] evicted db for tenant %s (closing after %d lease(s))", e.tenantID, e.refs)
+		return
+	}
+	m.close(e)
 	log.Printf("[tenant] evicted db for tenant %s", e.tenantID)
 }
 
-// CloseAll closes all open database connections.
+// Path returns the database file of a tenant, or ErrInvalidID for an ID that
+// could point outside the data directory.
+func (m *Manager) Path(tenantID string) (string, error) {
+	if !ValidID(tenantID) {
+		return "", ErrInvalidID
+	}
+	return filepath.Join(m.dataDir, fmt.Sprintf("tenant_%s.db", tenantID)), nil
+}
+
+// OpenReadOnly opens a tenant's database file read-only, outside the cache:
+// it is not migrated, replicated or leased, and the Registry is not asked.
+// It is for peeking at state, such as the sync version, of a database that
+// may not be migrated yet or may be damaged. The caller closes it.
+func (m *Manager) OpenReadOnly(tenantID string) (*sql.DB, error) {
+	path, err := m.Path(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro&_busy_timeout=5000&_query_only=true")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// Exclusive takes a tenant's database out of service and runs fn with its
+// path, for work that replaces the file. New leases fail with ErrLocked from
+// the start; drain (if not nil) is then called to make holders such as
+// streams let go, and fn runs once the last outstanding lease is released and
+// the database closed. The next Acquire reopens (and migrates) the file.
+func (m *Manager) Exclusive(ctx context.Context, tenantID string, drain func(), fn func(path string) error) error {
+	path, err := m.Path(tenantID)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	if m.locked[tenantID] {
+		m.mu.Unlock()
+		return ErrLocked
+	}
+	m.locked[tenantID] = true
+	var open *entry
+	if el, ok := m.cache[tenantID]; ok {
+		open = el.Value.(*entry)
+		m.evict(el)
+	}
+	if e, ok := m.draining[tenantID]; ok {
+		open = e
+	}
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.locked, tenantID)
+		m.mu.Unlock()
+	}()
+
+	if drain != nil {
+		drain()
+	}
+	if open != nil {
+		select {
+		case <-open.closed:
+		case <-ctx.Done():
+			return fmt.Errorf("wait for tenant %s to be released: %w", tenantID, ctx.Err())
+		}
+	}
+	m.mu.Lock()
+	detaching := m.detaching[tenantID]
+	m.mu.Unlock()
+	if detaching != nil {
+		select {
+		case <-detaching:
+		case <-ctx.Done():
+			return fmt.Errorf("wait for tenant %s to be released: %w", tenantID, ctx.Err())
+		}
+	}
+	return fn(path)
+}
+
+// Tenants lists the IDs of every tenant database in the data directory,
+// whether or not it is currently open, leaving out those of tenants the
+// Registry does not allow so background jobs pass over them.
+func (m *Manager) Tenants() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(m.dataDir, "tenant_*.db"))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(matches))
+	for _, path := range matches {
+		name := filepath.Base(path)
+		id := strings.TrimSuffix(strings.TrimPrefix(name, "tenant_"), ".db")
+		if m.check(id) != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Check reports whether a tenant may be used, as Acquire does, for handlers
+// that serve tenant data without opening its database.
+func (m *Manager) Check(tenantID string) error {
+	if !ValidID(tenantID) {
+		return ErrInvalidID
+	}
+	return m.check(tenantID)
+}
+
+// check asks the registry, if any, whether tenantID may be used. The registry
+// is queried without holding m.mu.
+func (m *Manager) check(tenantID string) error {
+	m.mu.Lock()
+	r := m.registry
+	m.mu.Unlock()
+	if r == nil {
+		return nil
+	}
+	return r.CheckTenant(tenantID)
+}
+
+// CloseAll closes all open database connections, leased or not. Call it at
+// shutdown once no handler can use them any more.
 func (m *Manager) CloseAll() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	for _, el := range m.cache {
-		el.Value.(*entry).db.Close()
+		m.close(el.Value.(*entry))
+	}
+	for _, e := range m.draining {
+		m.close(e)
 	}
 	m.cache = make(map[string]*list.Element)
 	m.order.Init()
+	m.draining = make(map[string]*entry)
 	log.Println("[tenant] closed all connections")
 }
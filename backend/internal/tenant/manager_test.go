@@ -0,0 +1,247 @@
+package tenant
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestManager(t *testing.T, maxConns int) *Manager {
+	t.Helper()
+	m := NewManager(t.TempDir(), maxConns)
+	t.Cleanup(m.CloseAll)
+	return m
+}
+
+func TestValidID(t *testing.T) {
+	for id, want := range map[string]bool{
+		"acme": true, "Acme-2.eu_west": true, "a": true,
+		"": false, ".acme": false, "-acme": false, "../acme": false, "ac/me": false, "acme corp": false,
+		"a234567890123456789012345678901234567890123456789012345678901234":  true,
+		"a2345678901234567890123456789012345678901234567890123456789012345": false,
+	} {
+		if ValidID(id) != want {
+			t.Errorf("ValidID(%q) = %v", id, !want)
+		}
+	}
+}
+
+// TestLeases checks that eviction never closes a database in use: a leased
+// database is drained instead, counts towards the hard ceiling, and is closed
+// on its last release.
+func TestLeases(t *testing.T) {
+	m := newTestManager(t, 1) // maxOpen 2
+
+	dbA, releaseA, err := m.Acquire("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbB, releaseB, err := m.Acquire("b") // evicts a, which is leased
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dbA.Exec("CREATE TABLE t (x)"); err != nil {
+		t.Fatalf("a drained database is unusable: %v", err)
+	}
+	if s := m.Status("a"); !s.Open || s.Leases != 1 {
+		t.Errorf("drained a: %+v", s)
+	}
+
+	if _, _, err := m.Acquire("c"); !errors.Is(err, ErrAtCapacity) {
+		t.Errorf("third tenant with two leased: err = %v, want ErrAtCapacity", err)
+	}
+
+	// Acquiring a draining tenant takes the same handle back
+	again, releaseAgain, err := m.Acquire("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != dbA {
+		t.Error("a draining database was opened a second time")
+	}
+	releaseAgain()
+	releaseAgain() // later calls are no-ops
+	if err := dbA.Ping(); err != nil {
+		t.Errorf("a released twice by one lease: %v", err)
+	}
+
+	releaseA()
+	releaseB()
+	if _, release, err := m.Acquire("c"); err != nil { // evicts idle b
+		t.Fatal(err)
+	} else {
+		release()
+	}
+	if err := dbB.Ping(); err == nil {
+		t.Error("an evicted idle database is still open")
+	}
+	if s := m.Status("b"); s.Open {
+		t.Errorf("evicted b: %+v", s)
+	}
+}
+
+func TestDrainedCloseOnRelease(t *testing.T) {
+	m := newTestManager(t, 1)
+	dbA, releaseA, err := m.Acquire("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, releaseB, err := m.Acquire("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseB()
+	releaseA()
+	if err := dbA.Ping(); err == nil {
+		t.Error("a drained database is still open after its last release")
+	}
+	if s := m.Status("a"); s.Open {
+		t.Errorf("a: %+v", s)
+	}
+}
+
+func TestExclusive(t *testing.T) {
+	m := newTestManager(t, 2)
+	db, release, err := m.Acquire("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE t (x)"); err != nil {
+		t.Fatal(err)
+	}
+
+	drained := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Exclusive(context.Background(), "a", func() { close(drained) }, func(path string) error {
+			if err := db.Ping(); err == nil {
+				t.Error("fn runs with the database still open")
+			}
+			if want, _ := m.Path("a"); path != want {
+				t.Errorf("path = %s, want %s", path, want)
+			}
+			return nil
+		})
+	}()
+	<-drained
+	if _, _, err := m.Acquire("a"); !errors.Is(err, ErrLocked) {
+		t.Errorf("Acquire during Exclusive: err = %v, want ErrLocked", err)
+	}
+	if err := m.Exclusive(context.Background(), "a", nil, func(string) error { return nil }); !errors.Is(err, ErrLocked) {
+		t.Errorf("a second Exclusive: err = %v, want ErrLocked", err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Exclusive returned with a lease outstanding: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, release, err := m.Acquire("a"); err != nil {
+		t.Errorf("Acquire after Exclusive: %v", err)
+	} else {
+		release()
+	}
+}
+
+func TestExclusiveCancelled(t *testing.T) {
+	m := newTestManager(t, 2)
+	_, release, err := m.Acquire("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	ran := false
+	if err := m.Exclusive(ctx, "a", nil, func(string) error { ran = true; return nil }); !errors.Is(err, context.DeadlineExceeded) || ran {
+		t.Errorf("err = %v, ran = %v", err, ran)
+	}
+	// The lock is lifted again
+	if _, release, err := m.Acquire("a"); err != nil {
+		t.Errorf("Acquire after a cancelled Exclusive: %v", err)
+	} else {
+		release()
+	}
+}
+
+type fakeRegistry map[string]error
+
+func (f fakeRegistry) CheckTenant(tenantID string) error { return f[tenantID] }
+
+func TestRegistry(t *testing.T) {
+	m := newTestManager(t, 2)
+	m.SetRegistry(fakeRegistry{"gone": ErrUnknownTenant, "late": ErrSuspended})
+	for id, want := range map[string]error{"acme": nil, "gone": ErrUnknownTenant, "late": ErrSuspended, "../x": ErrInvalidID} {
+		if err := m.Check(id); !errors.Is(err, want) {
+			t.Errorf("Check(%q) = %v, want %v", id, err, want)
+		}
+		_, release, err := m.Acquire(id)
+		if !errors.Is(err, want) {
+			t.Errorf("Acquire(%q) = %v, want %v", id, err, want)
+		}
+		if err == nil {
+			release()
+		}
+	}
+	// Tenants leaves out the files of tenants the registry refuses
+	path, _ := m.Path("late")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := m.Tenants(); err != nil || len(ids) != 1 || ids[0] != "acme" {
+		t.Errorf("Tenants() = %v, %v", ids, err)
+	}
+}
+
+// detachLifecycle hands out a Closing channel the test closes.
+type detachLifecycle struct{ done chan struct{} }
+
+func (l detachLifecycle) Opened(tenantID, path string, db *sql.DB) {}
+func (l detachLifecycle) Closing(tenantID string, db *sql.DB) <-chan struct{} {
+	return l.done
+}
+
+func TestDetachingForgotten(t *testing.T) {
+	m := newTestManager(t, 2)
+	l := detachLifecycle{done: make(chan struct{})}
+	m.SetLifecycle(l)
+	_, release, err := m.Acquire("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	exclusive := make(chan error, 1)
+	go func() {
+		exclusive <- m.Exclusive(context.Background(), "a", nil, func(string) error { return nil })
+	}()
+	select {
+	case err := <-exclusive:
+		t.Fatalf("Exclusive returned before Closing was done: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(l.done)
+	if err := <-exclusive; err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		m.mu.Lock()
+		n := len(m.detaching)
+		m.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d detaching entries left after Closing was done", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -10,6 +10,7 @@ import {
   Loader2,
   LogOut,
   Users,
+  RefreshCw,
 } from 'lucide-react';
 
 export default function App() {
@@ -110,6 +111,17 @@ function StatusBadge({ status }) {
       </span>
     );
   }
+  if (status === 'upgrade-required') {
+    return (
+      <button
+        onClick={() => window.location.reload()}
+        className="flex items-center gap-1.5 rounded-full bg-orange-500/10 px-2.5 py-0.5 text-xs font-medium text-orange-400"
+      >
+        <RefreshCw size={12} />
+        Update required
+      </button>
+    );
+  }
   if (status === 'offline') {
     return (
       <span className="flex items-center gap-1.5 rounded-full bg-red-500/10 px-2.5 py-0.5 text-xs font-medium text-red-400">
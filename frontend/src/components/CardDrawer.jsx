@@ -131,10 +131,17 @@ export default function CardDrawer({ card, onClose }) {
   // ─── Card field update (debounced save on blur) ───
   async function updateField(field, value) {
     try {
-      const updated = await api.updateCard(card.id, { [field]: value });
+      const updated = await api.updateCard(card.id, { [field]: value, base_version: cardData.row_version });
       optimisticWrite('kanban_cards', card.id, updated);
       setCardData(updated);
     } catch (err) {
+      if (err.status === 409 && err.body?.current) {
+        // Someone else saved first — show their version instead of overwriting it
+        optimisticWrite('kanban_cards', card.id, err.body.current);
+        setCardData(err.body.current);
+        alert('This card was changed by someone else. Your edit was not saved.');
+        return;
+      }
       alert('Failed: ' + err.message);
     }
   }
@@ -12,7 +12,7 @@ import {
 } from 'lucide-react';
 
 export default function POSModule() {
-  const { query, optimisticWrite, onSync, ready } = useWorker();
+  const { query, optimisticWrite, pushMutations, onSync, ready } = useWorker();
   const [products, setProducts] = useState([]);
   const [orders, setOrders] = useState([]);
   const [cart, setCart] = useState([]);
@@ -86,8 +86,15 @@ export default function POSModule() {
     setPlacing(true);
     try {
       const items = cart.map((i) => ({ product_id: i.product.id, qty: i.qty }));
-      const order = await api.createOrder({ items });
-      optimisticWrite('os_orders', order.uuid, order);
+      // Goes over the sync WebSocket; the new order arrives back as a delta.
+      const { results } = await pushMutations([{
+        id: crypto.randomUUID(),
+        table_name: 'os_orders',
+        entity_id: '',
+        operation: 'INSERT',
+        payload: { items },
+      }]);
+      if (results[0].status !== 'accepted') throw new Error(results[0].error);
       setCart([]);
     } catch (err) {
       console.error('Failed to place order:', err);
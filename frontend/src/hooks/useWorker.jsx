@@ -9,6 +9,7 @@ export function WorkerProvider({ children }) {
   const callbacksRef = useRef({});
   const listenersRef = useRef(new Set());
   const queryIdRef = useRef(0);
+  const pushesRef = useRef({});
   const [syncStatus, setSyncStatus] = useState('connecting');
   const [version, setVersion] = useState(0);
   const [ready, setReady] = useState(false);
@@ -48,6 +49,15 @@ export function WorkerProvider({ children }) {
           }
           break;
         }
+        case 'push-result': {
+          const p = pushesRef.current[msg.id];
+          if (p) {
+            if (msg.error) p.reject(new Error(msg.error));
+            else p.resolve(msg.result);
+            delete pushesRef.current[msg.id];
+          }
+          break;
+        }
       }
     };
 
@@ -75,10 +85,25 @@ export function WorkerProvider({ children }) {
     });
   }, []);
 
+  // Sends [{ id, table_name, entity_id, operation, payload }] through the
+  // worker's WebSocket (HTTP when it is down). Resolves to { version, results }.
+  const pushMutations = useCallback((mutations) => {
+    return new Promise((resolve, reject) => {
+      const id = ++queryIdRef.current;
+      pushesRef.current[id] = { resolve, reject };
+      workerRef.current?.postMessage({ type: 'push', id, mutations });
+    });
+  }, []);
+
   const forceSync = useCallback(() => {
     workerRef.current?.postMessage({ type: 'force-sync' });
   }, []);
 
+  // Compares the local replica with the server's checksums and reloads drifted tables
+  const verifyReplica = useCallback(() => {
+    workerRef.current?.postMessage({ type: 'verify' });
+  }, []);
+
   const onSync = useCallback((fn) => {
     listenersRef.current.add(fn);
     return () => listenersRef.current.delete(fn);
@@ -87,7 +112,9 @@ export function WorkerProvider({ children }) {
   const value = {
     query,
     optimisticWrite,
+    pushMutations,
     forceSync,
+    verifyReplica,
     onSync,
     syncStatus,
     version,
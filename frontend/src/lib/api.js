@@ -20,8 +20,11 @@ async function request(method, path, body) {
     body: body ? JSON.stringify(body) : undefined,
   });
   if (!res.ok) {
-    const err = await res.json().catch(() => ({ error: 'request failed' }));
-    throw new Error(err.error || 'request failed');
+    const body = await res.json().catch(() => ({ error: 'request failed' }));
+    const err = new Error(body.error || 'request failed');
+    err.status = res.status;
+    err.body = body;
+    throw err;
   }
   return res.json();
 }
@@ -31,6 +34,9 @@ export const api = {
   register: (email, password, tenant_id) => request('POST', '/api/auth/register', { email, password, tenant_id }),
   login: (email, password) => request('POST', '/api/auth/login', { email, password }),
 
+  // Offline outbox replay: [{ id, table_name, entity_id, operation, payload }]
+  pushMutations: (mutations) => request('POST', '/api/sync/push', { mutations }),
+
   // Domain (token required)
   createProject: (name) => request('POST', '/api/projects', { name }),
   deleteProject: (id) => request('DELETE', `/api/projects/${id}`),
@@ -46,7 +52,8 @@ export const api = {
 
   // Users
   listUsers: () => request('GET', '/api/users'),
-  inviteUser: (email, password) => request('POST', '/api/users', { email, password }),
+  inviteUser: (email, password, role) => request('POST', '/api/users', { email, password, role }),
+  setUserRole: (id, role) => request('PUT', `/api/users/${id}/role`, { role }),
 
   // Card details
   addTag: (cardId, name) => request('POST', `/api/kanban/cards/${cardId}/tags`, { name }),
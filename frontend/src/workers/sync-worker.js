@@ -12,6 +12,36 @@ let db = null;
 let localVersion = 0;
 let token = null;
 let apiBase = '';
+// Selective sync: "&tables=...&project_id=..." appended to every sync request,
+// built from the init message's filter ({ tables: [...], projectId }).
+let filterSpec = null;
+let syncFilter = '';
+
+// Sync protocol spoken and the server schema version (migration number) the
+// local tables below follow. Bump SCHEMA_VERSION with the local schema; the
+// server then asks for a fresh snapshot if the replica predates new columns.
+const SYNC_PROTOCOL = 2;
+const SCHEMA_VERSION = 10;
+// Schema version the replica's rows were last snapshotted under.
+let replicaSchema = SCHEMA_VERSION;
+
+// Tables whose local rows track the server row_version
+const VERSIONED_TABLES = new Set(['kanban_cards', 'kanban_columns']);
+
+// The columns of every synced table, in the order GET /api/sync/checksum
+// hashes them (checksumColumns on the server).
+const CHECKSUM_COLUMNS = {
+    projects: ['id', 'name'],
+    kanban_columns: ['id', 'project_id', 'name', 'color', 'position', 'row_version'],
+    kanban_cards: ['id', 'project_id', 'column_name', 'title', 'position', 'approval_status', 'assigned_approver_id', 'due_date', 'client', 'priority', 'notes', 'row_version'],
+    card_tags: ['id', 'card_id', 'name'],
+    card_assigned_users: ['id', 'card_id', 'user_id', 'user_email'],
+    card_approvers: ['id', 'card_id', 'user_id', 'user_email', 'status', 'decided_at'],
+    card_sessions: ['id', 'card_id', 'name', 'position'],
+    products: ['id', 'name', 'price'],
+    os_orders: ['uuid', 'short_id', 'card_id', 'project_id', 'total'],
+    os_items: ['id', 'order_id', 'product_id', 'qty'],
+};
 
 // ── SQLite OPFS Initialization ─────────────────────────────────────────────
 async function initDB() {
@@ -44,7 +74,7 @@ async function initDB() {
             assigned_approver_id TEXT,
             due_date TEXT, client TEXT,
             priority TEXT NOT NULL DEFAULT 'normal',
-            notes TEXT
+            notes TEXT, row_version INTEGER NOT NULL DEFAULT 0
         );
         CREATE TABLE IF NOT EXISTS products (
             id TEXT PRIMARY KEY, name TEXT NOT NULL, price REAL NOT NULL DEFAULT 0
@@ -60,7 +90,8 @@ async function initDB() {
         CREATE TABLE IF NOT EXISTS kanban_columns (
             id TEXT PRIMARY KEY, project_id TEXT NOT NULL,
             name TEXT NOT NULL, color TEXT NOT NULL DEFAULT 'bg-gray-500',
-            position INTEGER NOT NULL DEFAULT 0
+            position INTEGER NOT NULL DEFAULT 0,
+            row_version INTEGER NOT NULL DEFAULT 0
         );
         CREATE TABLE IF NOT EXISTS card_tags (
             id TEXT PRIMARY KEY, card_id TEXT NOT NULL, name TEXT NOT NULL
@@ -81,16 +112,35 @@ async function initDB() {
         CREATE TABLE IF NOT EXISTS _meta (key TEXT PRIMARY KEY, value TEXT);
     `);
 
+    // OPFS databases created before row versions existed lack the column
+    for (const table of VERSIONED_TABLES) {
+        const cols = db.exec({ sql: `PRAGMA table_info(${table})`, returnValue: 'resultRows' });
+        if (!cols.some(c => c[1] === 'row_version')) {
+            db.exec(`ALTER TABLE ${table} ADD COLUMN row_version INTEGER NOT NULL DEFAULT 0`);
+        }
+    }
+
     // Restore persisted version
     const rows = db.exec({ sql: "SELECT value FROM _meta WHERE key = 'version'", returnValue: 'resultRows' });
     if (rows.length > 0) {
         localVersion = parseInt(rows[0][0], 10) || 0;
     }
+    const schemaRows = db.exec({ sql: "SELECT value FROM _meta WHERE key = 'schema'", returnValue: 'resultRows' });
+    if (schemaRows.length > 0) {
+        replicaSchema = parseInt(schemaRows[0][0], 10) || 0;
+    }
 
     console.log('[worker] local DB ready, version:', localVersion);
     postMessage({ type: 'db-ready' });
 }
 
+// The filter the replica was last snapshotted under. Rows outside a narrower
+// filter were never synced, so a changed filter needs a fresh snapshot.
+function storedFilter() {
+    const rows = db.exec({ sql: "SELECT value FROM _meta WHERE key = 'filter'", returnValue: 'resultRows' });
+    return rows.length > 0 ? rows[0][0] : '';
+}
+
 // ── Delta Application ──────────────────────────────────────────────────────
 function applyDelta(entry) {
     const payload = typeof entry.payload === 'string' ? JSON.parse(entry.payload) : entry.payload;
@@ -100,15 +150,34 @@ function applyDelta(entry) {
     switch (entry.operation) {
         case 'INSERT':
         case 'UPDATE':
-        case 'PATCH':
             upsertRow(table, entityId, payload);
             break;
+        case 'PATCH':
+            patchRow(table, entityId, payload);
+            break;
         case 'DELETE':
             db.exec({ sql: `DELETE FROM ${table} WHERE ${pkCol(table)} = ?`, bind: [entityId] });
             break;
     }
 }
 
+// PATCH payloads carry only the changed columns: { id, row_version, changes: { col: { old, new } } }
+function patchRow(table, id, payload) {
+    const fields = {};
+    for (const [col, change] of Object.entries(payload.changes || {})) {
+        fields[col] = change.new;
+    }
+    if (VERSIONED_TABLES.has(table) && payload.row_version) {
+        fields.row_version = payload.row_version;
+    }
+    const cols = Object.keys(fields);
+    if (cols.length === 0) return;
+    db.exec({
+        sql: `UPDATE ${table} SET ${cols.map(c => `${c} = ?`).join(', ')} WHERE ${pkCol(table)} = ?`,
+        bind: [...cols.map(c => fields[c]), id],
+    });
+}
+
 function pkCol(table) {
     if (table === 'os_orders') return 'uuid';
     return 'id';
@@ -124,13 +193,14 @@ function upsertRow(table, id, payload) {
             break;
         case 'kanban_cards':
             db.exec({
-                sql: `INSERT OR REPLACE INTO kanban_cards (id, project_id, column_name, title, position, approval_status, assigned_approver_id, due_date, client, priority, notes)
-                      VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+                sql: `INSERT OR REPLACE INTO kanban_cards (id, project_id, column_name, title, position, approval_status, assigned_approver_id, due_date, client, priority, notes, row_version)
+                      VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
                 bind: [payload.id || id, payload.project_id, payload.column_name || 'backlog',
                        payload.title, payload.position || 0,
                        payload.approval_status || 'pending', payload.assigned_approver_id || null,
                        payload.due_date || null, payload.client || null,
-                       payload.priority || 'normal', payload.notes || null],
+                       payload.priority || 'normal', payload.notes || null,
+                       payload.row_version || 0],
             });
             break;
         case 'products':
@@ -163,8 +233,9 @@ function upsertRow(table, id, payload) {
             break;
         case 'kanban_columns':
             db.exec({
-                sql: `INSERT OR REPLACE INTO kanban_columns (id, project_id, name, color, position) VALUES (?, ?, ?, ?, ?)`,
-                bind: [payload.id || id, payload.project_id, payload.name, payload.color || 'bg-gray-500', payload.position || 0],
+                sql: `INSERT OR REPLACE INTO kanban_columns (id, project_id, name, color, position, row_version) VALUES (?, ?, ?, ?, ?, ?)`,
+                bind: [payload.id || id, payload.project_id, payload.name, payload.color || 'bg-gray-500', payload.position || 0,
+                       payload.row_version || 0],
             });
             break;
         case 'card_tags':
@@ -195,13 +266,121 @@ function upsertRow(table, id, payload) {
     }
 }
 
+// ── WebSocket Connection ───────────────────────────────────────────────────
+// One connection carries deltas down and mutation batches up. Deltas are
+// acknowledged after they are applied; the server pauses when too many are
+// unacknowledged. Falls back to SSE when the WebSocket cannot be opened.
+let ws = null;
+let wsQueue = Promise.resolve();
+const pendingPushes = new Map(); // request id -> { resolve, reject }
+// Set by a server "reconnect" message (the server is shutting down): how long
+// to wait before reconnecting, instead of the default delay.
+let reconnectAfter = null;
+
+function takeReconnectDelay(fallback) {
+    const delay = reconnectAfter ?? fallback;
+    reconnectAfter = null;
+    return delay;
+}
+
+function startWebSocket() {
+    const base = (apiBase || self.location.origin).replace(/^http/, 'ws');
+    const socket = new WebSocket(`${base}/ws/sync?since=${localVersion}&token=${encodeURIComponent(token)}${syncFilter}${protocolQuery()}`);
+    let opened = false;
+
+    socket.onopen = () => {
+        opened = true;
+        ws = socket;
+        postMessage({ type: 'sync-status', status: 'online' });
+    };
+    socket.onmessage = (e) => {
+        const msg = JSON.parse(e.data);
+        // Serialize handling so a resync catch-up never interleaves with deltas.
+        wsQueue = wsQueue.then(() => handleWSMessage(socket, msg)).catch(err => {
+            console.error('[worker] ws message error:', err);
+        });
+    };
+    socket.onclose = () => {
+        ws = null;
+        for (const { reject } of pendingPushes.values()) reject(new Error('connection closed'));
+        pendingPushes.clear();
+        if (!opened) {
+            startFetchSSE();
+            return;
+        }
+        postMessage({ type: 'sync-status', status: 'offline' });
+        setTimeout(() => startWebSocket(), takeReconnectDelay(1000));
+    };
+}
+
+async function handleWSMessage(socket, msg) {
+    switch (msg.type) {
+        case 'delta': {
+            if (msg.version > localVersion) {
+                const tables = new Set();
+                applyEntries(msg.entries, msg.version, tables);
+                postMessage({ type: 'sync-complete', version: localVersion, tables: [...tables] });
+            }
+            socket.send(JSON.stringify({ type: 'ack', version: msg.version }));
+            break;
+        }
+        case 'resync':
+            await fetchDeltas();
+            break;
+        case 'cursor':
+            advanceCursor(msg.version);
+            break;
+        case 'reconnect':
+            reconnectAfter = msg.retry_ms;
+            break;
+        case 'push_ack':
+        case 'error': {
+            const pending = pendingPushes.get(msg.id);
+            if (!pending) break;
+            pendingPushes.delete(msg.id);
+            if (msg.type === 'error') pending.reject(new Error(msg.error));
+            else pending.resolve({ version: msg.version, results: msg.results });
+            break;
+        }
+    }
+}
+
+// Sends a mutation batch over the WebSocket when connected, else over HTTP.
+async function pushMutations(mutations) {
+    if (ws && ws.readyState === WebSocket.OPEN) {
+        const id = crypto.randomUUID();
+        return new Promise((resolve, reject) => {
+            pendingPushes.set(id, { resolve, reject });
+            ws.send(JSON.stringify({ type: 'push', id, mutations }));
+        });
+    }
+    const res = await fetch(`${apiBase}/api/sync/push`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', ...syncHeaders() },
+        body: JSON.stringify({ mutations }),
+    });
+    const body = await res.json().catch(() => ({ error: 'request failed' }));
+    if (!res.ok) throw new Error(body.error || 'request failed');
+    return body;
+}
+
 // ── SSE Connection ─────────────────────────────────────────────────────────
+// Events carry their version's entries (deltas=1), and the server replays
+// everything after Last-Event-ID, so no separate catch-up fetch is needed.
 async function startFetchSSE() {
-    const url = `${apiBase}/sse/events`;
+    const url = `${apiBase}/sse/events?deltas=1${syncFilter}`;
     try {
         const response = await fetch(url, {
-            headers: { 'Authorization': `Bearer ${token}` },
+            headers: { ...syncHeaders(), 'Last-Event-ID': String(localVersion) },
         });
+        if (response.status === 426) {
+            upgradeRequired();
+            return;
+        }
+        if (response.status === 409 && (await loadSnapshot())) {
+            startFetchSSE();
+            return;
+        }
         if (!response.ok) {
             postMessage({ type: 'sync-status', status: 'offline' });
             setTimeout(() => startFetchSSE(), 5000);
@@ -210,13 +389,10 @@ async function startFetchSSE() {
 
         postMessage({ type: 'sync-status', status: 'online' });
 
-        // Catch-up: fetch any deltas that arrived between the initial
-        // fetchDeltas() and the SSE connection being established.
-        await fetchDeltas();
-
         const reader = response.body.getReader();
         const decoder = new TextDecoder();
         let buffer = '';
+        let event = {};
 
         while (true) {
             const { done, value } = await reader.read();
@@ -225,57 +401,285 @@ async function startFetchSSE() {
             const lines = buffer.split('\n');
             buffer = lines.pop();
             for (const line of lines) {
-                if (line.startsWith('data: ')) {
-                    const version = parseInt(line.slice(6).trim(), 10);
-                    if (!isNaN(version) && version > localVersion) {
-                        await fetchDeltas();
-                    }
+                if (line === '') {
+                    await handleSSEEvent(event);
+                    event = {};
+                } else if (line.startsWith('event: ')) {
+                    event.type = line.slice(7).trim();
+                } else if (line.startsWith('data: ')) {
+                    event.data = line.slice(6);
                 }
             }
         }
+        // Server closed the stream: reconnect and resume from localVersion.
+        setTimeout(() => startFetchSSE(), takeReconnectDelay(1000));
     } catch {
         postMessage({ type: 'sync-status', status: 'offline' });
         setTimeout(() => startFetchSSE(), 5000);
     }
 }
 
+async function handleSSEEvent(event) {
+    if (event.data === undefined) return;
+    switch (event.type) {
+        case 'delta': {
+            const { version, entries } = JSON.parse(event.data);
+            if (version <= localVersion) return;
+            const tables = new Set();
+            applyEntries(entries, version, tables);
+            postMessage({ type: 'sync-complete', version: localVersion, tables: [...tables] });
+            break;
+        }
+        case 'resync':
+            // Events were dropped or we are too far behind: page through /api/sync.
+            await fetchDeltas();
+            break;
+        case 'cursor':
+            advanceCursor(JSON.parse(event.data).version);
+            break;
+        case 'reconnect':
+            // The server is shutting down; the stream ends right after this.
+            reconnectAfter = JSON.parse(event.data).retry_ms;
+            break;
+        default: {
+            const version = parseInt(event.data.trim(), 10);
+            if (!isNaN(version) && version > localVersion) {
+                await fetchDeltas();
+            }
+        }
+    }
+}
+
 // ── Delta Sync ─────────────────────────────────────────────────────────────
+const SYNC_PAGE_LIMIT = 1000;
+
 async function fetchDeltas() {
     try {
-        const res = await fetch(`${apiBase}/api/sync?since=${localVersion}`, {
-            headers: { 'Authorization': `Bearer ${token}` },
+        const tables = new Set();
+        let cursor = null;
+        let hasMore = true;
+
+        // Pull bounded pages until caught up. Each page is applied in its own
+        // transaction together with the version it is safe to resume from, so
+        // an interrupted catch-up never leaves a version half-applied.
+        while (hasMore) {
+            const query = cursor
+                ? `cursor=${encodeURIComponent(cursor)}`
+                : `since=${localVersion}`;
+            const res = await fetch(`${apiBase}/api/sync?${query}&limit=${SYNC_PAGE_LIMIT}${syncFilter}`, {
+                headers: syncHeaders(),
+            });
+            if (res.status === 410 || res.status === 409) {
+                // Our version predates the compaction floor, or our replica
+                // predates columns we now know about: resnapshot and resume.
+                if (!(await loadSnapshot())) break;
+                cursor = null;
+                continue;
+            }
+            if (res.status === 426) {
+                upgradeRequired();
+                break;
+            }
+            if (!res.ok) break;
+
+            const page = await res.json();
+
+            applyEntries(page.entries, page.version, tables);
+            cursor = page.next_cursor;
+            hasMore = page.has_more;
+        }
+
+        if (!tables.size) return;
+
+        postMessage({
+            type: 'sync-complete',
+            version: localVersion,
+            tables: [...tables],
         });
-        if (!res.ok) return;
+    } catch (err) {
+        console.error('[worker] fetchDeltas error:', err);
+    }
+}
 
-        const deltas = await res.json();
-        if (!deltas.length) return;
+// Applies a batch of sync_log entries and persists the version they complete.
+// Wrap in a transaction — without this, each INSERT OR REPLACE
+// triggers a separate journal/fsync, making 30k writes take minutes.
+// With a transaction, the same 30k writes finish in milliseconds.
+function applyEntries(entries, version, tables) {
+    db.exec('BEGIN');
+    try {
+        for (const delta of entries) {
+            applyDelta(delta);
+            tables.add(delta.table_name);
+        }
+        // Persist version to OPFS
+        db.exec({ sql: `INSERT OR REPLACE INTO _meta (key, value) VALUES ('version', ?)`, bind: [String(version)] });
+        db.exec('COMMIT');
+    } catch (e) {
+        db.exec('ROLLBACK');
+        throw e;
+    }
+    localVersion = version;
+}
+
+// A filtered stream skipped versions with nothing for us: persist the position
+// so a reconnect does not scan them again.
+function advanceCursor(version) {
+    if (version > localVersion) applyEntries([], version, new Set());
+}
+
+// Headers of every sync request: credentials plus what we understand, so the
+// server can adapt payloads or ask for a resnapshot.
+function syncHeaders() {
+    return {
+        'Authorization': `Bearer ${token}`,
+        'X-Sync-Protocol': String(SYNC_PROTOCOL),
+        'X-Sync-Schema': String(SCHEMA_VERSION),
+        'X-Sync-Replica': String(replicaSchema),
+    };
+}
 
-        // Wrap in a transaction — without this, each INSERT OR REPLACE
-        // triggers a separate journal/fsync, making 30k writes take minutes.
-        // With a transaction, the same 30k writes finish in milliseconds.
+// The same for the WebSocket, which cannot send headers.
+function protocolQuery() {
+    return `&protocol=${SYNC_PROTOCOL}&schema=${SCHEMA_VERSION}&replica=${replicaSchema}`;
+}
+
+// The server no longer speaks our protocol: stop syncing until the app reloads.
+function upgradeRequired() {
+    console.warn('[worker] sync protocol too old, reload required');
+    postMessage({ type: 'sync-status', status: 'upgrade-required' });
+}
+
+function filterQuery(filter) {
+    if (!filter) return '';
+    let q = '';
+    if (filter.tables?.length) q += `&tables=${filter.tables.map(encodeURIComponent).join(',')}`;
+    if (filter.projectId) q += `&project_id=${encodeURIComponent(filter.projectId)}`;
+    return q;
+}
+
+// ── Snapshot Bootstrap ─────────────────────────────────────────────────────
+// A fresh replica loads the current state in one go instead of replaying the
+// whole sync_log, then continues with deltas from the snapshot version.
+// Returns whether the snapshot was applied.
+async function loadSnapshot() {
+    try {
+        const res = await fetch(`${apiBase}/api/sync/snapshot?${syncFilter.slice(1)}`, {
+            headers: syncHeaders(),
+        });
+        if (!res.ok) return false;
+
+        const snap = await res.json();
         db.exec('BEGIN');
         try {
-            for (const delta of deltas) {
-                applyDelta(delta);
+            // Tables outside the filter are not in the snapshot: drop what an
+            // earlier, wider filter left behind.
+            for (const table of Object.keys(CHECKSUM_COLUMNS)) {
+                if (!snap.tables[table]) db.exec(`DELETE FROM ${table}`);
             }
+            replaceTables(snap.tables);
+            db.exec({ sql: `INSERT OR REPLACE INTO _meta (key, value) VALUES ('version', ?)`, bind: [String(snap.version)] });
+            db.exec({ sql: `INSERT OR REPLACE INTO _meta (key, value) VALUES ('filter', ?)`, bind: [syncFilter] });
+            db.exec({ sql: `INSERT OR REPLACE INTO _meta (key, value) VALUES ('schema', ?)`, bind: [String(snap.schema_version)] });
             db.exec('COMMIT');
         } catch (e) {
             db.exec('ROLLBACK');
             throw e;
         }
-        localVersion = deltas[deltas.length - 1].version;
-
-        // Persist version to OPFS
-        db.exec({ sql: `INSERT OR REPLACE INTO _meta (key, value) VALUES ('version', ?)`, bind: [String(localVersion)] });
+        localVersion = snap.version;
+        replicaSchema = snap.schema_version;
 
         postMessage({
             type: 'sync-complete',
             version: localVersion,
-            tables: [...new Set(deltas.map(d => d.table_name))],
+            tables: Object.keys(snap.tables),
         });
+        return true;
     } catch (err) {
-        console.error('[worker] fetchDeltas error:', err);
+        console.error('[worker] loadSnapshot error:', err);
+        return false;
+    }
+}
+
+function replaceTables(tables) {
+    for (const [table, rows] of Object.entries(tables)) {
+        db.exec(`DELETE FROM ${table}`);
+        for (const row of rows) {
+            upsertRow(table, row[pkCol(table)], row);
+        }
+    }
+}
+
+// ── Replica Verification ───────────────────────────────────────────────────
+// Compares per-table checksums with the server at our version and reloads
+// only the tables that drifted.
+async function verifyReplica() {
+    try {
+        const version = localVersion;
+        const res = await fetch(`${apiBase}/api/sync/checksum?version=${version}${syncFilter}`, {
+            headers: syncHeaders(),
+        });
+        // 409: the server moved on since we caught up; verify another time.
+        if (!res.ok || localVersion !== version) return;
+        const remote = await res.json();
+
+        // Read every table before the first await so no delta lands in between.
+        const local = {};
+        for (const table of Object.keys(remote.tables)) {
+            local[table] = db.exec({
+                sql: `SELECT ${CHECKSUM_COLUMNS[table].join(', ')} FROM ${table}`,
+                returnValue: 'resultRows',
+            });
+        }
+
+        const drifted = [];
+        for (const [table, sum] of Object.entries(remote.tables)) {
+            const mine = await tableChecksum(local[table]);
+            if (mine.count !== sum.count || mine.hash !== sum.hash) drifted.push(table);
+        }
+        if (drifted.length) {
+            console.warn('[worker] replica drifted, reloading:', drifted);
+            await reloadTables(drifted);
+        }
+    } catch (err) {
+        console.error('[worker] verifyReplica error:', err);
+    }
+}
+
+// Same row encoding and sum as GetChecksum on the server.
+async function tableChecksum(rows) {
+    const encoder = new TextEncoder();
+    let total = 0n;
+    for (const row of rows) {
+        const text = row.map(v => (v === null || v === undefined ? '' : String(v))).join('\x1f');
+        const digest = new DataView(await crypto.subtle.digest('SHA-256', encoder.encode(text)));
+        total = (total + digest.getBigUint64(0)) & 0xffffffffffffffffn;
+    }
+    return { count: rows.length, hash: total.toString(16).padStart(16, '0') };
+}
+
+// Replaces a few tables from a filtered snapshot. Deltas applied while it was
+// in flight may be newer than the snapshot, so the version rewinds to the
+// snapshot's when lower and those deltas are replayed on top (they converge).
+async function reloadTables(tables) {
+    const res = await fetch(`${apiBase}/api/sync/snapshot?${filterQuery({ ...filterSpec, tables }).slice(1)}`, {
+        headers: syncHeaders(),
+    });
+    if (!res.ok) return;
+    const snap = await res.json();
+    const version = Math.min(localVersion, snap.version);
+    db.exec('BEGIN');
+    try {
+        replaceTables(snap.tables);
+        db.exec({ sql: `INSERT OR REPLACE INTO _meta (key, value) VALUES ('version', ?)`, bind: [String(version)] });
+        db.exec('COMMIT');
+    } catch (e) {
+        db.exec('ROLLBACK');
+        throw e;
     }
+    localVersion = version;
+    postMessage({ type: 'sync-complete', version: localVersion, tables: Object.keys(snap.tables) });
+    await fetchDeltas();
 }
 
 // ── Query Handler — Real SQL SELECTs ───────────────────────────────────────
@@ -327,9 +731,19 @@ self.onmessage = async function (e) {
         case 'init':
             token = msg.token;
             apiBase = msg.apiBase || '';
+            filterSpec = msg.filter || null;
+            syncFilter = filterQuery(filterSpec);
             await initDB();
+            if (localVersion === 0 || storedFilter() !== syncFilter) await loadSnapshot();
             await fetchDeltas();
-            startFetchSSE();
+            verifyReplica();
+            if (typeof WebSocket !== 'undefined') startWebSocket();
+            else startFetchSSE();
+            break;
+        case 'push':
+            pushMutations(msg.mutations)
+                .then(result => postMessage({ type: 'push-result', id: msg.id, result }))
+                .catch(err => postMessage({ type: 'push-result', id: msg.id, error: err.message }));
             break;
         case 'query':
             handleQuery(msg.id, msg.query);
@@ -340,5 +754,9 @@ self.onmessage = async function (e) {
         case 'force-sync':
             await fetchDeltas();
             break;
+        case 'verify':
+            await fetchDeltas();
+            await verifyReplica();
+            break;
     }
 };
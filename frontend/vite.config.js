@@ -11,6 +11,11 @@ export default defineConfig({
         target: 'http://localhost:9090',
         changeOrigin: true,
       },
+      '/ws': {
+        target: 'http://localhost:9090',
+        changeOrigin: true,
+        ws: true,
+      },
     },
     headers: {
       'Cross-Origin-Opener-Policy': 'same-origin',